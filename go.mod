@@ -1,3 +1,13 @@
 module github.com/rednafi/hn-alert
 
 go 1.23.4
+
+require golang.org/x/net v0.33.0
+
+require (
+	golang.org/x/term v0.27.0
+	golang.org/x/text v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.28.0 // indirect
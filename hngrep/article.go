@@ -0,0 +1,219 @@
+package hngrep
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// articleTitleTimeout bounds how long fetching a single linked page may take.
+const articleTitleTimeout = 5 * time.Second
+
+// maxArticleBytes caps how much of a linked page is read while looking for its <title>.
+const maxArticleBytes = 1 << 20
+
+// titleTagPattern extracts the contents of an HTML document's <title> element.
+var titleTagPattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// fetchArticleTitle fetches the page at rawURL and returns the text of its <title>
+// element, or an empty string if the page has none.
+func fetchArticleTitle(ctx context.Context, client *http.Client, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("unsupported article URL %q", rawURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error building article request %q: %w", rawURL, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching article %q: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error fetching article %q: unexpected status %s", rawURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxArticleBytes))
+	if err != nil {
+		return "", fmt.Errorf("error reading article %q: %w", rawURL, err)
+	}
+
+	m := titleTagPattern.FindSubmatch(body)
+	if m == nil {
+		return "", nil
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " "), nil
+}
+
+const (
+	// linkCheckTimeout bounds how long checking a single link, redirects included, may take.
+	linkCheckTimeout = 5 * time.Second
+
+	// linkCheckConcurrency caps how many links are checked at once.
+	linkCheckConcurrency = 8
+)
+
+// LinkCheck records whether a story's linked page was reachable.
+type LinkCheck struct {
+	Alive      bool // Whether the final response, after redirects, was below 400.
+	StatusCode int  // Final HTTP status; zero when the request itself failed.
+}
+
+// pageFetcher fetches stories' linked pages, for FetchArticleTitles and CheckLinks.
+// Its methods must be safe for concurrent use.
+type pageFetcher interface {
+	articleTitle(ctx context.Context, rawURL string) (string, error)
+	linkStatus(ctx context.Context, rawURL string) (int, error)
+}
+
+// httpPages fetches linked pages directly, with a client for each kind of request.
+type httpPages struct {
+	articles *http.Client
+	links    *http.Client
+}
+
+// newHTTPPages returns an httpPages whose clients time out after
+// articleTitleTimeout and linkCheckTimeout.
+func newHTTPPages() *httpPages {
+	return &httpPages{
+		articles: &http.Client{Timeout: articleTitleTimeout},
+		links:    &http.Client{Timeout: linkCheckTimeout},
+	}
+}
+
+func (p *httpPages) articleTitle(ctx context.Context, rawURL string) (string, error) {
+	return fetchArticleTitle(ctx, p.articles, rawURL)
+}
+
+func (p *httpPages) linkStatus(ctx context.Context, rawURL string) (int, error) {
+	return linkStatus(ctx, p.links, rawURL)
+}
+
+// newCachedPages returns a cachedPages fetching through a new httpPages and
+// spacing out article title fetches with titles.
+func newCachedPages(titles *pacer) *cachedPages {
+	return &cachedPages{
+		pages:      newHTTPPages(),
+		titlePacer: titles,
+		titles:     make(map[string]pageResult),
+		statuses:   make(map[string]pageResult),
+	}
+}
+
+// pageResult is a remembered linked page title or link status.
+type pageResult struct {
+	title  string
+	status int
+	err    error
+}
+
+// cachedPages wraps a pageFetcher, remembering what it returned for each URL,
+// failures included, so a Feed's pages are fetched once however often it's
+// filtered. It's safe for concurrent use, as checkLinks needs, except for
+// articleTitle while titlePacer is set.
+type cachedPages struct {
+	pages      pageFetcher
+	titlePacer *pacer // Spaces out article title fetches; nil doesn't wait.
+
+	mu       sync.Mutex
+	titles   map[string]pageResult
+	statuses map[string]pageResult
+}
+
+func (p *cachedPages) articleTitle(ctx context.Context, rawURL string) (string, error) {
+	p.mu.Lock()
+	r, ok := p.titles[rawURL]
+	p.mu.Unlock()
+	if !ok {
+		if p.titlePacer != nil {
+			if err := p.titlePacer.wait(ctx); err != nil {
+				return "", err
+			}
+		}
+		r.title, r.err = p.pages.articleTitle(ctx, rawURL)
+		p.mu.Lock()
+		p.titles[rawURL] = r
+		p.mu.Unlock()
+	}
+	return r.title, r.err
+}
+
+func (p *cachedPages) linkStatus(ctx context.Context, rawURL string) (int, error) {
+	p.mu.Lock()
+	r, ok := p.statuses[rawURL]
+	p.mu.Unlock()
+	if !ok {
+		r.status, r.err = p.pages.linkStatus(ctx, rawURL)
+		p.mu.Lock()
+		p.statuses[rawURL] = r
+		p.mu.Unlock()
+	}
+	return r.status, r.err
+}
+
+// checkLinks checks the linked page of every story with an http or https URL,
+// up to linkCheckConcurrency at a time, and records the result in its Link.
+// Self posts and other URLs are left unchecked, with a nil Link.
+func checkLinks(ctx context.Context, pages pageFetcher, stories []Story, logger *log.Logger) {
+	sem := make(chan struct{}, linkCheckConcurrency)
+	var wg sync.WaitGroup
+	for i := range stories {
+		u, err := url.Parse(stories[i].URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(s *Story) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			status, err := pages.linkStatus(ctx, s.URL)
+			if err != nil {
+				logger.Printf("Link check failed for story %d: %v", s.ID, err)
+			} else if status >= 400 {
+				logger.Printf("Link for story %d is dead: status %d", s.ID, status)
+			}
+			s.Link = &LinkCheck{Alive: err == nil && status < 400, StatusCode: status}
+		}(&stories[i])
+	}
+	wg.Wait()
+}
+
+// linkStatus returns the final status of a HEAD request for rawURL, retrying
+// with GET for servers that don't support HEAD.
+func linkStatus(ctx context.Context, client *http.Client, rawURL string) (int, error) {
+	status, err := requestStatus(ctx, client, http.MethodHead, rawURL)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		return requestStatus(ctx, client, http.MethodGet, rawURL)
+	}
+	return status, err
+}
+
+// requestStatus issues a method request for rawURL and returns its status code,
+// without reading the body.
+func requestStatus(ctx context.Context, client *http.Client, method, rawURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error building link request %q: %w", rawURL, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error checking link %q: %w", rawURL, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
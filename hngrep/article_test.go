@@ -0,0 +1,129 @@
+package hngrep
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newArticleServer serves a page with a <title>, a page without one, and 404 for anything else.
+func newArticleServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "<html><head><TITLE>\n  Writing a Postgres &amp;\n Go driver </TITLE></head></html>")
+	})
+	mux.HandleFunc("/untitled", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "<html><body>No title here</body></html>")
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchArticleTitle(t *testing.T) {
+	t.Parallel()
+	srv := newArticleServer(t)
+
+	tests := []struct {
+		name    string
+		rawURL  string
+		want    string
+		wantErr bool
+	}{
+		{name: "Page with title", rawURL: srv.URL + "/article", want: "Writing a Postgres & Go driver"},
+		{name: "Page without title", rawURL: srv.URL + "/untitled", want: ""},
+		{name: "Missing page", rawURL: srv.URL + "/missing", wantErr: true},
+		{name: "Non-HTTP URL", rawURL: "ftp://example.com/file", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fetchArticleTitle(context.Background(), srv.Client(), tt.rawURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchArticleTitle(%q) error = %v, wantErr %v", tt.rawURL, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("fetchArticleTitle(%q) = %q, want %q", tt.rawURL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/gone", http.NotFound)
+	mux.Handle("/moved", http.RedirectHandler("/ok", http.StatusMovedPermanently))
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name string
+		url  string
+		want *LinkCheck
+	}{
+		{name: "Alive", url: srv.URL + "/ok", want: &LinkCheck{Alive: true, StatusCode: http.StatusOK}},
+		{name: "Dead", url: srv.URL + "/gone", want: &LinkCheck{StatusCode: http.StatusNotFound}},
+		{name: "Redirect", url: srv.URL + "/moved", want: &LinkCheck{Alive: true, StatusCode: http.StatusOK}},
+		{name: "HEAD not allowed", url: srv.URL + "/no-head", want: &LinkCheck{Alive: true, StatusCode: http.StatusOK}},
+		{name: "Unreachable", url: "http://127.0.0.1:0/", want: &LinkCheck{}},
+		{name: "Self post", url: "", want: nil},
+		{name: "Non-HTTP URL", url: "ftp://example.com/file", want: nil},
+	}
+
+	stories := make([]Story, len(tests))
+	for i, tt := range tests {
+		stories[i] = Story{ID: i + 1, URL: tt.url}
+	}
+	checkLinks(context.Background(), &httpPages{links: srv.Client()}, stories, log.New(io.Discard, "", 0))
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stories[i].Link; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Link for %q = %+v, want %+v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedPagesPacesTitles(t *testing.T) {
+	t.Parallel()
+	var fetchedAt []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetchedAt = append(fetchedAt, time.Now())
+		_, _ = io.WriteString(w, "<title>Page</title>")
+	}))
+	t.Cleanup(srv.Close)
+
+	const delay = 50 * time.Millisecond
+	pages := &cachedPages{
+		pages:      &httpPages{articles: srv.Client()},
+		titlePacer: &pacer{delay: delay, maxDelay: delay},
+		titles:     make(map[string]pageResult),
+	}
+	for _, path := range []string{"/a", "/b", "/a"} {
+		if _, err := pages.articleTitle(context.Background(), srv.URL+path); err != nil {
+			t.Fatalf("articleTitle(%q) returned error: %v", path, err)
+		}
+	}
+
+	// Each page is fetched once, the second Delay after the first.
+	if len(fetchedAt) != 2 {
+		t.Fatalf("Fetched %d pages, want 2", len(fetchedAt))
+	}
+	if gap := fetchedAt[1].Sub(fetchedAt[0]); gap < delay {
+		t.Errorf("Second title fetch came %s after the first, want at least %s", gap, delay)
+	}
+}
@@ -0,0 +1,476 @@
+package hngrep
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTopStoriesURL is the Firebase endpoint listing the current top story IDs.
+	DefaultTopStoriesURL = "https://hacker-news.firebaseio.com/v0/topstories.json"
+
+	// DefaultItemURLTemplate is the Firebase endpoint for a single item; %d is the item ID.
+	DefaultItemURLTemplate = "https://hacker-news.firebaseio.com/v0/item/%d.json"
+	DefaultUserURLTemplate = "https://hacker-news.firebaseio.com/v0/user/%s.json"
+
+	// DefaultMaxBodyBytes caps API response bodies; real feeds and items are far smaller.
+	DefaultMaxBodyBytes = 4 << 20
+)
+
+// ErrBodyTooLarge is returned by HNClient when a response body exceeds MaxBodyBytes.
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// Errors HNClient returns, wrapped in a *StatusError, for unsuccessful HTTP
+// responses, so callers can tell them apart with errors.Is.
+var (
+	ErrNotFound    = errors.New("not found")    // 404 Not Found.
+	ErrRateLimited = errors.New("rate limited") // 429 Too Many Requests.
+	ErrServerError = errors.New("server error") // Any 5xx status.
+)
+
+// StatusError is returned by HNClient when the API answers with a non-2xx
+// status. It unwraps to ErrNotFound, ErrRateLimited, or ErrServerError when
+// the status is one of theirs.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	RetryAfter time.Duration // Wait the response's Retry-After header asked for; 0 if it had none.
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Unwrap returns the sentinel error for the status code's class, or nil.
+func (e *StatusError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= 500:
+		return ErrServerError
+	}
+	return nil
+}
+
+// ErrIncompleteResponse is returned by HNClient when a response body ends
+// early, like when the connection resets mid-stream. Grep retries feeds that
+// fail with it.
+var ErrIncompleteResponse = errors.New("incomplete response, consider retrying")
+
+// Feeds maps each feed name to its Firebase endpoint. Every feed returns a JSON
+// array of item IDs, except "updates", which lists recently changed items and
+// profiles as {"items": [...], "profiles": [...]}.
+var Feeds = map[string]string{
+	"top":     DefaultTopStoriesURL,
+	"new":     "https://hacker-news.firebaseio.com/v0/newstories.json",
+	"best":    "https://hacker-news.firebaseio.com/v0/beststories.json",
+	"ask":     "https://hacker-news.firebaseio.com/v0/askstories.json",
+	"show":    "https://hacker-news.firebaseio.com/v0/showstories.json",
+	"job":     "https://hacker-news.firebaseio.com/v0/jobstories.json",
+	"updates": "https://hacker-news.firebaseio.com/v0/updates.json",
+}
+
+// Client defines an interface for fetching top stories and individual story details.
+type Client interface {
+	GetTopStories() ([]int, error)
+	GetStory(id int) (*Story, error)
+}
+
+// UserClient is implemented by clients that can look up HN users, which
+// Options.MinAuthorKarma needs.
+type UserClient interface {
+	GetUserKarma(user string) (int, error)
+}
+
+// HNClient implements Client, fetching data from the live Hacker News API.
+type HNClient struct {
+	TopStoriesURL   string // Feed to list IDs from; any of Feeds works.
+	ItemURLTemplate string
+	UserURLTemplate string       // User endpoint, with a %s for the username; empty disables user lookups.
+	HTTPClient      *http.Client // Defaults to http.DefaultClient when nil.
+	Header          http.Header  // Extra headers set on every request, like gateway auth.
+	MaxBodyBytes    int64        // Largest decompressed body read; 0 means no limit.
+
+	// FieldMap renames incoming item keys to the JSON fields Story decodes, for
+	// mirrors with their own schema. It maps field to key, like "title" to
+	// "headline"; see ParseFieldMap.
+	FieldMap map[string]string
+
+	// StrictJSON makes GetStory fail on item fields that neither Story nor the
+	// documented HN item schema models, to spot API changes.
+	StrictJSON bool
+
+	// KeepRaw makes GetStory keep each item's JSON body, after any FieldMap
+	// renaming, in Story.Raw, for Options.MatchField.
+	KeepRaw bool
+}
+
+// Compile-time checks that HNClient implements Client and UserClient.
+var (
+	_ Client     = (*HNClient)(nil)
+	_ UserClient = (*HNClient)(nil)
+)
+
+// NewHNClient returns an HNClient pointed at the public Firebase API.
+func NewHNClient() *HNClient {
+	return &HNClient{
+		TopStoriesURL:   DefaultTopStoriesURL,
+		ItemURLTemplate: DefaultItemURLTemplate,
+		UserURLTemplate: DefaultUserURLTemplate,
+		MaxBodyBytes:    DefaultMaxBodyBytes,
+	}
+}
+
+// CheckItemURLTemplate reports whether tmpl is usable as an ItemURLTemplate:
+// it must hold exactly one %d verb, for the item ID, and spell any literal
+// percent sign, as in a percent-encoded path, as %%.
+func CheckItemURLTemplate(tmpl string) error {
+	verbs := 0
+	for i := 0; i < len(tmpl); i++ {
+		if tmpl[i] != '%' {
+			continue
+		}
+		i++
+		switch {
+		case i == len(tmpl):
+			return fmt.Errorf("trailing %% in %q; write a literal percent sign as %%%%", tmpl)
+		case tmpl[i] == '%':
+		case tmpl[i] == 'd':
+			verbs++
+		default:
+			return fmt.Errorf("unsupported verb %%%c in %q; only %%d is allowed, and a literal percent sign is %%%%", tmpl[i], tmpl)
+		}
+	}
+	if verbs != 1 {
+		return fmt.Errorf("%q must contain exactly one %%d for the item ID, found %d", tmpl, verbs)
+	}
+	return nil
+}
+
+// UserURLTemplateFor derives the user endpoint of the API itemURLTemplate
+// belongs to, so a cache or proxy of the items serves users too: its last
+// "/item/%d" becomes "/user/%s", as in the HN API. It fails for templates
+// without one.
+func UserURLTemplateFor(itemURLTemplate string) (string, error) {
+	i := strings.LastIndex(itemURLTemplate, "/item/%d")
+	if i < 0 {
+		return "", fmt.Errorf("can't derive a user URL from %q, which has no /item/%%d", itemURLTemplate)
+	}
+	return itemURLTemplate[:i] + "/user/%s" + itemURLTemplate[i+len("/item/%d"):], nil
+}
+
+// get issues a GET request for rawURL, asking for a gzip-compressed response and
+// transparently decompressing it. Setting Accept-Encoding ourselves disables the
+// transport's automatic decompression, so it has to be handled here. Non-2xx
+// responses fail with a *StatusError.
+func (c *HNClient) get(rawURL string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.Header {
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return nil, &StatusError{URL: rawURL, StatusCode: resp.StatusCode, RetryAfter: retryAfter}
+	}
+
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("error decompressing response: %w", err)
+		}
+		resp.Body = &gzipReadCloser{Reader: gz, body: resp.Body}
+	}
+	return resp, nil
+}
+
+// parseRetryAfter returns the wait a Retry-After header value asks for, as
+// of now, in either of its forms: delta-seconds, like "120", or an HTTP date.
+// Missing or unparsable values and dates already past give 0.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// readBody reads r in full, failing with ErrBodyTooLarge instead of buffering
+// more than MaxBodyBytes. The limit applies after decompression, so a small
+// gzip bomb can't get around it either.
+func (c *HNClient) readBody(r io.Reader) ([]byte, error) {
+	if c.MaxBodyBytes <= 0 {
+		return io.ReadAll(r)
+	}
+	body, err := io.ReadAll(io.LimitReader(r, c.MaxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > c.MaxBodyBytes {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, c.MaxBodyBytes)
+	}
+	return body, nil
+}
+
+// incomplete wraps err in ErrIncompleteResponse if it shows the body was cut
+// short: a read that hit an unexpected EOF, or JSON that ends mid-value.
+// Other errors are returned as-is.
+func incomplete(err error, body []byte) error {
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+	case errors.As(err, &syntaxErr) && syntaxErr.Offset >= int64(len(bytes.TrimRight(body, " \t\r\n"))):
+	default:
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrIncompleteResponse, err)
+}
+
+// gzipReadCloser reads a decompressed response body and closes both the gzip
+// reader and the underlying body.
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+// Close closes the gzip reader and the underlying response body.
+func (g *gzipReadCloser) Close() error {
+	return errors.Join(g.Reader.Close(), g.body.Close())
+}
+
+// GetTopStories fetches the IDs of the stories in the feed at TopStoriesURL.
+func (c *HNClient) GetTopStories() ([]int, error) {
+	resp, err := c.get(c.TopStoriesURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching top stories: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := c.readBody(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading top stories body: %w", incomplete(err, body))
+	}
+
+	ids, err := parseFeedIDs(body)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling top story IDs: %w", incomplete(err, body))
+	}
+	return ids, nil
+}
+
+// GetUserKarma fetches the karma of an HN user.
+func (c *HNClient) GetUserKarma(user string) (int, error) {
+	if c.UserURLTemplate == "" {
+		return 0, fmt.Errorf("error fetching user %q: no user URL template", user)
+	}
+	resp, err := c.get(fmt.Sprintf(c.UserURLTemplate, url.PathEscape(user)))
+	if err != nil {
+		return 0, fmt.Errorf("error fetching user %q: %w", user, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := c.readBody(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("error reading user body: %w", incomplete(err, body))
+	}
+	var u *struct {
+		Karma int `json:"karma"`
+	}
+	if err := json.Unmarshal(body, &u); err != nil {
+		return 0, fmt.Errorf("error unmarshalling user %q: %w", user, incomplete(err, body))
+	}
+	if u == nil {
+		return 0, fmt.Errorf("user %q not found", user)
+	}
+	return u.Karma, nil
+}
+
+// parseFeedIDs decodes a feed body, either a JSON array of IDs or the updates
+// feed's {"items": [...], "profiles": [...]} object, whose profiles are ignored.
+func parseFeedIDs(body []byte) ([]int, error) {
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var updates struct {
+			Items []int `json:"items"`
+		}
+		if err := json.Unmarshal(trimmed, &updates); err != nil {
+			return nil, err
+		}
+		return updates.Items, nil
+	}
+
+	var ids []int
+	if err := json.Unmarshal(body, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetStory fetches the details of a single story by ID from Hacker News.
+// Items the API returns as null, like just-posted ones, come back as nil, nil.
+func (c *HNClient) GetStory(id int) (*Story, error) {
+	itemURL := fmt.Sprintf(c.ItemURLTemplate, id)
+	resp, err := c.get(itemURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching story %d: %w", id, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := c.readBody(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading story body: %w", incomplete(err, body))
+	}
+	if bytes.Equal(bytes.TrimSpace(body), []byte("null")) {
+		return nil, nil
+	}
+
+	if len(c.FieldMap) > 0 {
+		if body, err = remapFields(body, c.FieldMap); err != nil {
+			return nil, fmt.Errorf("error remapping story %d: %w", id, err)
+		}
+	}
+
+	var s Story
+	if c.StrictJSON {
+		s, err = decodeStrictItem(body)
+	} else {
+		err = json.Unmarshal(body, &s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling story %d: %w", id, incomplete(err, body))
+	}
+
+	if c.KeepRaw {
+		s.Raw = body
+	}
+	s.StoryURL = fmt.Sprintf("https://news.ycombinator.com/item?id=%d", id)
+	return &s, nil
+}
+
+// MappableFields lists the Story JSON fields a FieldMap may map keys onto.
+var MappableFields = []string{"id", "title", "url", "score", "time", "by", "text", "kids"}
+
+// ParseFieldMap parses a comma-separated list of field=key pairs, like
+// "title=headline,url=link", into a FieldMap.
+func ParseFieldMap(s string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		field, key, ok := strings.Cut(pair, "=")
+		field, key = strings.TrimSpace(field), strings.TrimSpace(key)
+		if !ok || field == "" || key == "" {
+			return nil, fmt.Errorf("field map entry must be in 'field=key' form, got %q", pair)
+		}
+		if !slices.Contains(MappableFields, field) {
+			return nil, fmt.Errorf("field map field must be one of %s, got %q", strings.Join(MappableFields, ", "), field)
+		}
+		fields[field] = key
+	}
+	return fields, nil
+}
+
+// remapFields rewrites the top-level keys of a JSON object, moving the value
+// under each fieldMap key to its field. Mapped fields the body already has
+// under their own name are replaced, so the mirror's value wins.
+func remapFields(body []byte, fieldMap map[string]string) ([]byte, error) {
+	var item map[string]json.RawMessage
+	if err := json.Unmarshal(body, &item); err != nil {
+		return nil, err
+	}
+	if item == nil {
+		// A JSON null, as for a missing item, has nothing to remap.
+		return body, nil
+	}
+	for field, key := range fieldMap {
+		if key == field {
+			continue
+		}
+		if v, ok := item[key]; ok {
+			delete(item, key)
+			item[field] = v
+		}
+	}
+	return json.Marshal(item)
+}
+
+// strictItem is the documented HN item schema, for decoding with unknown fields
+// disallowed. The fields Story doesn't use are accepted and discarded.
+type strictItem struct {
+	Story
+	Deleted json.RawMessage `json:"deleted"`
+	Dead    json.RawMessage `json:"dead"`
+	Poll    json.RawMessage `json:"poll"`
+	Parts   json.RawMessage `json:"parts"`
+}
+
+// decodeStrictItem decodes an item body into a Story, failing on any field
+// outside the documented HN item schema.
+func decodeStrictItem(body []byte) (Story, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	var item strictItem
+	if err := dec.Decode(&item); err != nil {
+		return Story{}, err
+	}
+	return item.Story, nil
+}
+
+// FixedIDsClient wraps a Client, replacing the top stories feed with a fixed list of IDs.
+// It looks up users when the wrapped Client does; see userClientOf.
+type FixedIDsClient struct {
+	Client
+	IDs []int
+}
+
+// GetTopStories returns the fixed list of IDs without hitting the feed.
+func (c *FixedIDsClient) GetTopStories() ([]int, error) {
+	return c.IDs, nil
+}
+
+// userClientOf returns client as a UserClient, seeing through FixedIDsClient
+// to the Client it wraps, and reports whether it is one.
+func userClientOf(client Client) (UserClient, bool) {
+	if c, ok := client.(*FixedIDsClient); ok {
+		return userClientOf(c.Client)
+	}
+	users, ok := client.(UserClient)
+	return users, ok
+}
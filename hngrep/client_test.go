@@ -0,0 +1,647 @@
+package hngrep
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHNClientGzip(t *testing.T) {
+	t.Parallel()
+	// writeGzip writes body gzip-compressed, failing the request if the client didn't ask for it.
+	writeGzip := func(w http.ResponseWriter, r *http.Request, body string) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			http.Error(w, "gzip required", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Type", "application/json")
+		gz := gzip.NewWriter(w)
+		_, _ = io.WriteString(gz, body)
+		_ = gz.Close()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/topstories.json", func(w http.ResponseWriter, r *http.Request) {
+		writeGzip(w, r, `[101, 202]`)
+	})
+	mux.HandleFunc("/item/101.json", func(w http.ResponseWriter, r *http.Request) {
+		writeGzip(w, r, `{"id": 101, "title": "Go is cool", "url": "https://golang.org"}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := &HNClient{
+		TopStoriesURL:   srv.URL + "/topstories.json",
+		ItemURLTemplate: srv.URL + "/item/%d.json",
+		HTTPClient:      srv.Client(),
+	}
+
+	ids, err := client.GetTopStories()
+	if err != nil {
+		t.Fatalf("GetTopStories returned error: %v", err)
+	}
+	if want := []int{101, 202}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("GetTopStories = %v, want %v", ids, want)
+	}
+
+	got, err := client.GetStory(101)
+	if err != nil {
+		t.Fatalf("GetStory returned error: %v", err)
+	}
+	want := &Story{
+		ID:       101,
+		Title:    "Go is cool",
+		URL:      "https://golang.org",
+		StoryURL: "https://news.ycombinator.com/item?id=101",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetStory = %+v, want %+v", got, want)
+	}
+}
+
+func TestHNClientHeaders(t *testing.T) {
+	t.Parallel()
+	var got []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Clone())
+		if strings.HasSuffix(r.URL.Path, "/topstories.json") {
+			_, _ = io.WriteString(w, `[101]`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"id": 101, "title": "Go is cool"}`)
+	}))
+	defer srv.Close()
+
+	client := &HNClient{
+		TopStoriesURL:   srv.URL + "/topstories.json",
+		ItemURLTemplate: srv.URL + "/item/%d.json",
+		HTTPClient:      srv.Client(),
+		Header: http.Header{
+			"Authorization":   {"Bearer secret"},
+			"X-Forwarded-For": {"10.0.0.1", "10.0.0.2"},
+		},
+	}
+
+	if _, err := client.GetTopStories(); err != nil {
+		t.Fatalf("GetTopStories returned error: %v", err)
+	}
+	if _, err := client.GetStory(101); err != nil {
+		t.Fatalf("GetStory returned error: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("Server saw %d requests, want 2", len(got))
+	}
+	for i, h := range got {
+		for key, want := range client.Header {
+			if !reflect.DeepEqual(h.Values(key), want) {
+				t.Errorf("Request %d header %s = %v, want %v", i, key, h.Values(key), want)
+			}
+		}
+	}
+}
+
+func TestHNClientUpdatesFeed(t *testing.T) {
+	t.Parallel()
+	var fetched []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/updates.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"items": [8423305, 8420805], "profiles": ["thefox", "mdda"]}`)
+	})
+	mux.HandleFunc("/item/", func(w http.ResponseWriter, r *http.Request) {
+		fetched = append(fetched, r.URL.Path)
+		_, _ = io.WriteString(w, `{"id": 8423305, "title": "Go is cool"}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := &HNClient{
+		TopStoriesURL:   srv.URL + "/updates.json",
+		ItemURLTemplate: srv.URL + "/item/%d.json",
+		HTTPClient:      srv.Client(),
+	}
+
+	ids, err := client.GetTopStories()
+	if err != nil {
+		t.Fatalf("GetTopStories returned error: %v", err)
+	}
+	if want := []int{8423305, 8420805}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("GetTopStories = %v, want %v", ids, want)
+	}
+
+	if _, err := Grep(context.Background(), Options{MaxStories: 10, Keywords: []string{"go"}}, client); err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	// Only the item IDs are fetched; profile names never reach the item endpoint.
+	if want := []string{"/item/8423305.json", "/item/8420805.json"}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("Fetched paths = %v, want %v", fetched, want)
+	}
+}
+
+func TestHNClientMaxBodyBytes(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/topstories.json") {
+			_, _ = io.WriteString(w, "["+strings.Repeat("1,", 100)+"1]")
+			return
+		}
+		_, _ = io.WriteString(w, `{"id": 1, "title": "`+strings.Repeat("x", 1000)+`"}`)
+	}))
+	defer srv.Close()
+
+	client := &HNClient{
+		TopStoriesURL:   srv.URL + "/topstories.json",
+		ItemURLTemplate: srv.URL + "/item/%d.json",
+		HTTPClient:      srv.Client(),
+		MaxBodyBytes:    64,
+	}
+
+	if _, err := client.GetTopStories(); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("GetTopStories error = %v, want %v", err, ErrBodyTooLarge)
+	}
+	if _, err := client.GetStory(1); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("GetStory error = %v, want %v", err, ErrBodyTooLarge)
+	}
+
+	// A body exactly at the limit is still accepted.
+	client.MaxBodyBytes = 203
+	if ids, err := client.GetTopStories(); err != nil || len(ids) != 101 {
+		t.Errorf("GetTopStories at the limit = %d IDs, %v; want 101 IDs", len(ids), err)
+	}
+}
+
+func TestHNClientStrictJSON(t *testing.T) {
+	t.Parallel()
+	items := map[string]string{
+		"/item/1.json": `{"id": 1, "type": "story", "by": "pg", "time": 1160418111, "title": "Go", "url": "https://go.dev", "score": 5, "kids": [2], "descendants": 1}`,
+		"/item/2.json": `{"id": 2, "type": "story", "title": "Go", "reactions": 3}`,
+		"/item/3.json": `{"id": 3, "type": "story", "title": "Go", "TitleSpans": [[0, 99]], "Relevance": 9}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, items[r.URL.Path])
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		strict  bool
+		id      int
+		wantErr string
+	}{
+		{name: "Lenient known fields", id: 1},
+		{name: "Lenient unknown field", id: 2},
+		{name: "Strict known fields", strict: true, id: 1},
+		{name: "Strict unknown field", strict: true, id: 2, wantErr: `unknown field "reactions"`},
+		{name: "Lenient computed fields ignored", id: 3},
+		{name: "Strict computed field", strict: true, id: 3, wantErr: `unknown field "TitleSpans"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &HNClient{
+				ItemURLTemplate: srv.URL + "/item/%d.json",
+				HTTPClient:      srv.Client(),
+				StrictJSON:      tt.strict,
+			}
+			s, err := client.GetStory(tt.id)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GetStory(%d) error = %v, want one containing %q", tt.id, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetStory(%d) returned error: %v", tt.id, err)
+			}
+			if s.ID != tt.id || s.Title != "Go" {
+				t.Errorf("GetStory(%d) = %+v, want ID %d titled Go", tt.id, s, tt.id)
+			}
+			if s.TitleSpans != nil || s.Relevance != 0 {
+				t.Errorf("GetStory(%d) = %+v, want the computed fields left unset", tt.id, s)
+			}
+		})
+	}
+}
+
+func TestParseFieldMap(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input   string
+		want    map[string]string
+		wantErr string
+	}{
+		{input: "title=headline, url=link", want: map[string]string{"title": "headline", "url": "link"}},
+		{input: "", want: map[string]string{}},
+		{input: "title", wantErr: "must be in 'field=key' form"},
+		{input: "title=", wantErr: "must be in 'field=key' form"},
+		{input: "author=by", wantErr: "field map field must be one of id, title, url, score, time, by, text, kids"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseFieldMap(tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseFieldMap(%q) error = %v, want one containing %q", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFieldMap(%q) returned error: %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFieldMap(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHNClientFieldMap(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"id": 7, "headline": "Go on a mirror", "link": "https://go.dev", "title": "stale", "points": 12, "author": "ann", "posted_at": 1700000000}`)
+	}))
+	defer srv.Close()
+
+	client := &HNClient{
+		ItemURLTemplate: srv.URL + "/item/%d.json",
+		HTTPClient:      srv.Client(),
+		FieldMap:        map[string]string{"title": "headline", "url": "link", "score": "points", "by": "author", "time": "posted_at"},
+	}
+	got, err := client.GetStory(7)
+	if err != nil {
+		t.Fatalf("GetStory(7) returned error: %v", err)
+	}
+	want := Story{ID: 7, Title: "Go on a mirror", URL: "https://go.dev", Score: 12, By: "ann", Time: 1700000000, StoryURL: "https://news.ycombinator.com/item?id=7"}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("GetStory(7) = %+v, want %+v", *got, want)
+	}
+}
+
+func TestHNClientComment(t *testing.T) {
+	t.Parallel()
+	items := map[string]string{
+		"/item/2.json": `{"id": 2, "type": "comment", "by": "pg", "parent": 1, "text": "Go is great", "time": 1700000000}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, items[r.URL.Path])
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		id   int
+		want Story
+	}{
+		{
+			id:   2,
+			want: Story{ID: 2, Type: "comment", By: "pg", Parent: 1, Text: "Go is great", Time: 1700000000, StoryURL: "https://news.ycombinator.com/item?id=2"},
+		},
+	}
+
+	for _, strict := range []bool{false, true} {
+		client := &HNClient{
+			ItemURLTemplate: srv.URL + "/item/%d.json",
+			HTTPClient:      srv.Client(),
+			StrictJSON:      strict,
+		}
+		for _, tt := range tests {
+			got, err := client.GetStory(tt.id)
+			if err != nil {
+				t.Fatalf("GetStory(%d) with strict %t returned error: %v", tt.id, strict, err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("GetStory(%d) with strict %t = %+v, want %+v", tt.id, strict, *got, tt.want)
+			}
+		}
+	}
+}
+
+// truncatingServer serves body for every path, but for the first cut requests
+// it sends only half of body and closes the connection mid-response.
+func truncatingServer(t *testing.T, body string, cut int) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		truncate := cut > 0
+		cut--
+		mu.Unlock()
+		if !truncate {
+			_, _ = io.WriteString(w, body)
+			return
+		}
+
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body[:len(body)/2])
+		_ = buf.Flush()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHNClientIncompleteResponse(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantErr bool
+	}{
+		{
+			name:    "Truncated JSON",
+			handler: func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, `[1, 2, 3,`) },
+			wantErr: true,
+		},
+		{
+			name:    "Invalid JSON",
+			handler: func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, `<html>down</html>`) },
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			client := &HNClient{TopStoriesURL: srv.URL, HTTPClient: srv.Client()}
+			_, err := client.GetTopStories()
+			if err == nil {
+				t.Fatal("GetTopStories returned nil error")
+			}
+			if got := errors.Is(err, ErrIncompleteResponse); got != tt.wantErr {
+				t.Errorf("GetTopStories error = %v; is ErrIncompleteResponse = %v, want %v", err, got, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("Connection closed mid-body", func(t *testing.T) {
+		t.Parallel()
+		srv := truncatingServer(t, `[1, 2, 3, 4, 5, 6, 7, 8]`, 1)
+		client := &HNClient{TopStoriesURL: srv.URL, HTTPClient: srv.Client()}
+		if _, err := client.GetTopStories(); !errors.Is(err, ErrIncompleteResponse) {
+			t.Errorf("GetTopStories error = %v, want %v", err, ErrIncompleteResponse)
+		}
+	})
+}
+
+func TestCheckItemURLTemplate(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		tmpl    string
+		wantErr bool
+	}{
+		{tmpl: DefaultItemURLTemplate},
+		{tmpl: "http://cache.local/hn/items/%d"},
+		{tmpl: "http://cache.local/hn%%2Fitem?id=%d"},
+		{tmpl: "http://cache.local/item", wantErr: true},
+		{tmpl: "http://cache.local/%d/%d", wantErr: true},
+		{tmpl: "http://cache.local/%s", wantErr: true},
+		{tmpl: "http://cache.local/hn%2Fitem/%d", wantErr: true},
+		{tmpl: "http://cache.local/%d%", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if err := CheckItemURLTemplate(tt.tmpl); (err != nil) != tt.wantErr {
+			t.Errorf("CheckItemURLTemplate(%q) = %v, want error %t", tt.tmpl, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUserURLTemplateFor(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		tmpl    string
+		want    string
+		wantErr bool
+	}{
+		{tmpl: DefaultItemURLTemplate, want: DefaultUserURLTemplate},
+		{tmpl: "http://cache.local/v0/item/%d.json?auth=k%%3D", want: "http://cache.local/v0/user/%s.json?auth=k%%3D"},
+		{tmpl: "http://cache.local/hn/items/%d", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := UserURLTemplateFor(tt.tmpl)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("UserURLTemplateFor(%q) = %q, %v; want %q, error %t", tt.tmpl, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHNClientItemURLTemplate(t *testing.T) {
+	t.Parallel()
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.RequestURI()
+		_, _ = io.WriteString(w, `{"id": 42, "title": "Cached story"}`)
+	}))
+	defer srv.Close()
+
+	client := NewHNClient()
+	client.HTTPClient = srv.Client()
+	client.ItemURLTemplate = srv.URL + "/mirror/items?id=%d&fmt=json"
+	s, err := client.GetStory(42)
+	if err != nil {
+		t.Fatalf("GetStory returned error: %v", err)
+	}
+	if want := "/mirror/items?id=42&fmt=json"; gotPath != want {
+		t.Errorf("Requested %q, want %q", gotPath, want)
+	}
+	if s.Title != "Cached story" {
+		t.Errorf("Title = %q, want %q", s.Title, "Cached story")
+	}
+}
+
+func TestHNClientMatchField(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/topstories.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `[1, 2]`)
+	})
+	mux.HandleFunc("/item/1.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"id": 1, "title": "Weekly links", "metadata": {"tags": ["databases", "go"], "lang": "en"}}`)
+	})
+	mux.HandleFunc("/item/2.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"id": 2, "title": "More links", "metadata": {"tags": ["rust"]}, "go": true}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := &HNClient{
+		TopStoriesURL:   srv.URL + "/topstories.json",
+		ItemURLTemplate: srv.URL + "/item/%d.json",
+		HTTPClient:      srv.Client(),
+		KeepRaw:         true,
+	}
+	opts := Options{MaxStories: 2, Keywords: []string{"go"}, MatchField: "metadata.tags"}
+	res, err := Grep(context.Background(), opts, client)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	if len(res.Stories) != 1 || res.Stories[0].ID != 1 {
+		t.Fatalf("Grep(...) = %+v, want only story 1, tagged go", res.Stories)
+	}
+	if want := []string{"go"}; !reflect.DeepEqual(res.Stories[0].MatchedKeywords, want) {
+		t.Errorf("MatchedKeywords = %v, want %v", res.Stories[0].MatchedKeywords, want)
+	}
+}
+
+func TestHNClientGetUserKarma(t *testing.T) {
+	t.Parallel()
+	users := map[string]string{
+		"/user/pg.json":    `{"id": "pg", "created": 1160418092, "karma": 157236}`,
+		"/user/ghost.json": `null`,
+		"/user/a b/c.json": `{"id": "a b/c", "karma": 7}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, users[r.URL.Path])
+	}))
+	defer srv.Close()
+	client := &HNClient{UserURLTemplate: srv.URL + "/user/%s.json", HTTPClient: srv.Client()}
+
+	tests := []struct {
+		user    string
+		want    int
+		wantErr bool
+	}{
+		{user: "pg", want: 157236},
+		{user: "a b/c", want: 7},
+		{user: "ghost", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := client.GetUserKarma(tt.user)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("GetUserKarma(%q) = %d, %v; want %d, error %t", tt.user, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHNClientStatusErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		status int
+		want   error // nil for statuses without a sentinel.
+	}{
+		{status: http.StatusNotFound, want: ErrNotFound},
+		{status: http.StatusTooManyRequests, want: ErrRateLimited},
+		{status: http.StatusInternalServerError, want: ErrServerError},
+		{status: http.StatusServiceUnavailable, want: ErrServerError},
+		{status: http.StatusForbidden, want: nil},
+	}
+	sentinels := []error{ErrNotFound, ErrRateLimited, ErrServerError}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"error": "nope"}`, tt.status)
+			}))
+			defer srv.Close()
+			client := &HNClient{TopStoriesURL: srv.URL, ItemURLTemplate: srv.URL + "/item/%d.json", HTTPClient: srv.Client()}
+
+			_, feedErr := client.GetTopStories()
+			_, itemErr := client.GetStory(1)
+			for _, err := range []error{feedErr, itemErr} {
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status {
+					t.Fatalf("Error = %v, want a *StatusError with status %d", err, tt.status)
+				}
+				for _, sentinel := range sentinels {
+					if got := errors.Is(err, sentinel); got != (sentinel == tt.want) {
+						t.Errorf("errors.Is(%v, %v) = %t, want %t", err, sentinel, got, !got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "120", want: 2 * time.Minute},
+		{value: " 0 ", want: 0},
+		{value: "-5", want: 0},
+		{value: "Mon, 06 May 2024 07:08:39 GMT", want: 30 * time.Second},
+		{value: "Mon, 06 May 2024 07:00:00 GMT", want: 0},
+		{value: "soon", want: 0},
+		{value: "", want: 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestHNClientNullItem(t *testing.T) {
+	t.Parallel()
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict %t", strict), func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			requests := 0
+			mux := http.NewServeMux()
+			mux.HandleFunc("/topstories.json", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, `[1]`)
+			})
+			mux.HandleFunc("/item/1.json", func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests++
+				n := requests
+				mu.Unlock()
+				// Null for the direct fetch and Grep's first, filled in for its retry.
+				if n <= 2 {
+					_, _ = io.WriteString(w, "null\n")
+					return
+				}
+				_, _ = io.WriteString(w, `{"id": 1, "title": "Go is cool"}`)
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			client := &HNClient{
+				TopStoriesURL:   srv.URL + "/topstories.json",
+				ItemURLTemplate: srv.URL + "/item/%d.json",
+				HTTPClient:      srv.Client(),
+				StrictJSON:      strict,
+			}
+			s, err := client.GetStory(1)
+			if err != nil || s != nil {
+				t.Fatalf("GetStory(1) of a null item = %+v, %v, want nil, nil", s, err)
+			}
+
+			opts := Options{MaxStories: 1, Keywords: []string{"go"}, RetryNull: true, RetryNullDelay: time.Millisecond}
+			res, err := Grep(context.Background(), opts, client)
+			if err != nil {
+				t.Fatalf("Grep(...) returned error: %v", err)
+			}
+			if got := storyIDs(res.Stories); !reflect.DeepEqual(got, []int{1}) {
+				t.Errorf("Matched IDs = %v, want [1] after the retry", got)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if requests != 3 {
+				t.Errorf("Item requested %d times, want 3", requests)
+			}
+		})
+	}
+}
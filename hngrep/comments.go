@@ -0,0 +1,137 @@
+package hngrep
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// htmlTagPattern matches the tags in HN's HTML comment text.
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// commentText returns the plain text of an HN comment's HTML body.
+func commentText(body string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(htmlTagPattern.ReplaceAllString(body, " "))), " ")
+}
+
+// fetchCommentText walks the comment tree under kids breadth-first, down to
+// maxDepth levels and fetching at most maxFetches comments, and returns their
+// plain text joined by newlines along with how many fetches it made. Each
+// fetch waits its turn with p. Comments that fail to fetch are logged and
+// skipped, along with their replies.
+func fetchCommentText(ctx context.Context, client Client, kids []int, maxDepth, maxFetches int, p *pacer, logger *log.Logger) (string, int, error) {
+	type queued struct{ id, depth int }
+	queue := make([]queued, 0, len(kids))
+	for _, id := range kids {
+		queue = append(queue, queued{id, 1})
+	}
+
+	var texts []string
+	fetched := 0
+	for ; len(queue) > 0 && fetched < maxFetches; fetched++ {
+		if err := p.wait(ctx); err != nil {
+			return "", fetched, err
+		}
+		next := queue[0]
+		queue = queue[1:]
+
+		c, err := client.GetStory(next.id)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", fetched + 1, ctx.Err()
+			}
+			logger.Printf("   Failed to fetch comment %d: %v", next.id, err)
+			continue
+		}
+		if c == nil {
+			continue
+		}
+		if text := commentText(c.Text); text != "" {
+			texts = append(texts, text)
+		}
+		if next.depth < maxDepth {
+			for _, id := range c.Kids {
+				queue = append(queue, queued{id, next.depth + 1})
+			}
+		}
+	}
+	return strings.Join(texts, "\n"), fetched, nil
+}
+
+// summaryRunes caps the length of a match's summary line.
+const summaryRunes = 160
+
+// paragraphBreak matches the <p> tags HN separates paragraphs with.
+var paragraphBreak = regexp.MustCompile(`(?i)<p\b[^>]*>`)
+
+// summaryLine returns the first paragraph of an HN HTML body as plain text,
+// cut to max runes with "..." if it's longer.
+func summaryLine(body string, max int) string {
+	first := paragraphBreak.Split(strings.TrimSpace(body), 2)[0]
+	first, _, _ = strings.Cut(first, "\n")
+	line := commentText(first)
+	if utf8.RuneCountInString(line) <= max {
+		return line
+	}
+	runes := []rune(line)
+	return strings.TrimSpace(string(runes[:max])) + "..."
+}
+
+// fetchSummary returns a one-line summary of a matched story: the first line
+// of its own text, for Ask and Show HN posts, or else of its top comment,
+// which costs one fetch, waiting its turn with p. It returns "" if neither has
+// text or the comment fails to fetch, which is logged.
+func fetchSummary(ctx context.Context, client Client, s *Story, p *pacer, logger *log.Logger) (string, error) {
+	if line := summaryLine(s.Text, summaryRunes); line != "" {
+		return line, nil
+	}
+	if len(s.Kids) == 0 {
+		return "", nil
+	}
+	if err := p.wait(ctx); err != nil {
+		return "", err
+	}
+	c, err := client.GetStory(s.Kids[0])
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		logger.Printf("   Failed to fetch top comment %d: %v", s.Kids[0], err)
+		return "", nil
+	}
+	if c == nil {
+		return "", nil
+	}
+	return summaryLine(c.Text, summaryRunes), nil
+}
+
+// maxParents is how many parents rootStory walks up before giving up, far
+// deeper than any real HN thread.
+const maxParents = 100
+
+// rootStory walks up from comment c through its parents, fetching each with
+// client and waiting its turn with p, and returns the story it belongs to.
+func rootStory(ctx context.Context, client Client, c *Story, p *pacer) (*Story, error) {
+	parent := c.Parent
+	for range maxParents {
+		if err := p.wait(ctx); err != nil {
+			return nil, err
+		}
+		item, err := client.GetStory(parent)
+		if err != nil {
+			return nil, err
+		}
+		if item == nil {
+			return nil, fmt.Errorf("parent %d not found", parent)
+		}
+		if item.Type != "comment" || item.Parent == 0 {
+			return item, nil
+		}
+		parent = item.Parent
+	}
+	return nil, fmt.Errorf("no story within %d parents", maxParents)
+}
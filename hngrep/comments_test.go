@@ -0,0 +1,211 @@
+package hngrep
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGrepFlattenComments(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{1},
+		Stories: map[int]Story{
+			1:  {ID: 1, Title: "Ask HN: What are you working on?", URL: "https://example.com", Kids: []int{10, 11}},
+			10: {ID: 10, Type: "comment", Text: "A compiler", Kids: []int{20}},
+			11: {ID: 11, Type: "comment", Text: "A garden"},
+			20: {ID: 20, Type: "comment", Text: "Is it written in <i>Go</i>?"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantIDs []int
+	}{
+		{
+			name:    "Comments not fetched",
+			opts:    Options{MaxStories: 1, Keywords: []string{"go"}},
+			wantIDs: nil,
+		},
+		{
+			name:    "Keyword in a reply",
+			opts:    Options{MaxStories: 1, Keywords: []string{"go"}, FlattenComments: true, CommentDepth: 3, CommentLimit: 50},
+			wantIDs: []int{1},
+		},
+		{
+			name:    "Reply below the depth limit",
+			opts:    Options{MaxStories: 1, Keywords: []string{"go"}, FlattenComments: true, CommentDepth: 1, CommentLimit: 50},
+			wantIDs: nil,
+		},
+		{
+			name:    "Reply past the fetch limit",
+			opts:    Options{MaxStories: 1, Keywords: []string{"go"}, FlattenComments: true, CommentDepth: 3, CommentLimit: 2},
+			wantIDs: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			res, err := Grep(context.Background(), tt.opts, fakeClient)
+			if err != nil {
+				t.Fatalf("Grep(...) returned error: %v", err)
+			}
+			if got := storyIDs(res.Stories); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("Matched IDs = %v, want %v", got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestGrepCommentFetchCaps(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2, 3},
+		Stories: map[int]Story{
+			1:  {ID: 1, Title: "Go news", Kids: []int{10}},
+			2:  {ID: 2, Title: "Ask HN: Favorite languages?", Kids: []int{20, 21}},
+			3:  {ID: 3, Title: "Ask HN: Side projects?", Kids: []int{30, 31}},
+			10: {ID: 10, Type: "comment", Text: "Nice"},
+			20: {ID: 20, Type: "comment", Text: "Rust"},
+			21: {ID: 21, Type: "comment", Text: "Go, mostly"},
+			30: {ID: 30, Type: "comment", Text: "A garden"},
+			31: {ID: 31, Type: "comment", Text: "A Go linter"},
+		},
+	}
+
+	opts := Options{MaxStories: 3, Keywords: []string{"go"}, FlattenComments: true, CommentDepth: 3, CommentLimit: 50, MaxCommentFetches: 3}
+	res, err := Grep(context.Background(), opts, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	if got, want := storyIDs(res.Stories), []int{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Matched IDs = %v, want %v", got, want)
+	}
+	// Story 1 matched on its title, so its comments aren't fetched, and the
+	// run-wide cap leaves story 3 one comment.
+	if want := []int{1, 2, 20, 21, 3, 30}; !reflect.DeepEqual(fakeClient.Fetched, want) {
+		t.Errorf("Fetched IDs = %v, want %v", fakeClient.Fetched, want)
+	}
+}
+
+func TestFilterCommentFetchCaps(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2, 3},
+		Stories: map[int]Story{
+			1:  {ID: 1, Title: "Go news", Kids: []int{10}},
+			2:  {ID: 2, Title: "Ask HN: Favorite languages?", Kids: []int{20, 21}},
+			3:  {ID: 3, Title: "Ask HN: Side projects?", Kids: []int{30, 31}},
+			10: {ID: 10, Type: "comment", Text: "Rust, surely"},
+			20: {ID: 20, Type: "comment", Text: "Rust"},
+			21: {ID: 21, Type: "comment", Text: "Go, mostly"},
+			30: {ID: 30, Type: "comment", Text: "A garden"},
+			31: {ID: 31, Type: "comment", Text: "A Go linter"},
+		},
+	}
+
+	opts := Options{MaxStories: 3, FlattenComments: true, CommentDepth: 3, CommentLimit: 50, MaxCommentFetches: 3}
+	feed, err := Fetch(context.Background(), opts, fakeClient)
+	if err != nil {
+		t.Fatalf("Fetch(...) returned error: %v", err)
+	}
+	for _, tt := range []struct {
+		keyword string
+		want    []int
+	}{
+		{"go", []int{1, 2}},
+		// Story 2's comments are in the feed; story 1's would need a fetch past the cap.
+		{"rust", []int{2}},
+	} {
+		opts.Keywords = []string{tt.keyword}
+		res, err := Filter(context.Background(), opts, feed, fakeClient)
+		if err != nil {
+			t.Fatalf("Filter(...) for %q returned error: %v", tt.keyword, err)
+		}
+		if got := storyIDs(res.Stories); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Matched IDs for %q = %v, want %v", tt.keyword, got, tt.want)
+		}
+	}
+	// The cap holds across both calls, so the second fetches nothing.
+	if want := []int{1, 2, 3, 20, 21, 30}; !reflect.DeepEqual(fakeClient.Fetched, want) {
+		t.Errorf("Fetched IDs = %v, want %v", fakeClient.Fetched, want)
+	}
+}
+
+func TestGrepCommentInFeed(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{3, 4},
+		Stories: map[int]Story{
+			1: {ID: 1, Type: "story", Title: "Why Go?", Kids: []int{2}},
+			2: {ID: 2, Type: "comment", Parent: 1, Text: "Because", Kids: []int{3}},
+			3: {ID: 3, Type: "comment", Parent: 2, Text: "Agreed"},
+			4: {ID: 4, Type: "comment", Parent: 99, Text: "Orphaned"},
+		},
+	}
+
+	res, err := Grep(context.Background(), Options{MaxStories: 2, Keywords: []string{"go"}}, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	// The comment is matched under its story's title and links into its thread;
+	// the one whose story can't be found has nothing to match.
+	if got := storyIDs(res.Stories); !reflect.DeepEqual(got, []int{3}) {
+		t.Fatalf("Matched IDs = %v, want [3]", got)
+	}
+	s := res.Stories[0]
+	if s.Title != "Why Go?" || s.StoryID != 1 || s.StoryTitle != "Why Go?" || s.StoryURL != "https://news.ycombinator.com/item?id=1#3" {
+		t.Errorf("Matched comment = %+v, want the title, ID, and link of story 1", s)
+	}
+	if want := []int{3, 2, 1, 4, 99}; !reflect.DeepEqual(fakeClient.Fetched, want) {
+		t.Errorf("Fetched IDs = %v, want %v", fakeClient.Fetched, want)
+	}
+}
+
+func TestCommentText(t *testing.T) {
+	t.Parallel()
+	got := commentText("<p>Rust &amp; <a href=\"https://go.dev\">Go</a></p><p>are fine</p>")
+	if want := "Rust & Go are fine"; got != want {
+		t.Errorf("commentText(...) = %q, want %q", got, want)
+	}
+}
+
+func TestGrepMatchContext(t *testing.T) {
+	t.Parallel()
+	longText := "<i>Go</i> " + strings.Repeat("word ", 60)
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2, 3},
+		Stories: map[int]Story{
+			1:  {ID: 1, Title: "Ask HN: Go or Rust?", Text: "I&#x27;m picking a language.<p>Second paragraph.", Kids: []int{10}},
+			2:  {ID: 2, Title: "Go 1.24", URL: "https://go.dev/blog", Kids: []int{20, 21}},
+			3:  {ID: 3, Title: "Show HN: A Go tool", Text: longText},
+			10: {ID: 10, Type: "comment", Text: "Unused"},
+			20: {ID: 20, Type: "comment", Text: "Range over func\nis here.<p>More."},
+			21: {ID: 21, Type: "comment", Text: "Unused"},
+		},
+	}
+
+	res, err := Grep(context.Background(), Options{MaxStories: 3, Keywords: []string{"go"}, MatchContext: true}, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	var got []string
+	for _, s := range res.Stories {
+		got = append(got, s.Summary)
+	}
+	want := []string{
+		"I'm picking a language.",
+		"Range over func",
+		strings.TrimSpace(commentText(longText)[:summaryRunes]) + "...",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Summaries = %q, want %q", got, want)
+	}
+	// Only story 2, which has no text, costs a comment fetch.
+	if wantFetched := []int{1, 2, 20, 3}; !reflect.DeepEqual(fakeClient.Fetched, wantFetched) {
+		t.Errorf("Fetched IDs = %v, want %v", fakeClient.Fetched, wantFetched)
+	}
+}
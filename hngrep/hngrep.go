@@ -0,0 +1,1008 @@
+// Package hngrep fetches Hacker News stories and filters them by keywords and domain.
+//
+// Grep is the entry point: it pulls story IDs from a Client, fetches each story,
+// and returns the ones that match the given Options.
+package hngrep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// ErrTooManyFailures is returned by Grep when it aborts after
+// Options.MaxConsecutiveFailures story fetches fail in a row. The Result
+// returned alongside it holds everything matched up to that point.
+var ErrTooManyFailures = errors.New("too many consecutive fetch failures")
+
+// ErrEmptyFeed is returned by Grep when the feed has no story IDs and
+// Options.FailOnEmpty is set, which usually means an HN outage or a bad endpoint.
+var ErrEmptyFeed = errors.New("feed returned no stories")
+
+// Story represents a Hacker News story.
+// Fields must be exported so the JSON package can unmarshal them.
+type Story struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	Score    int    `json:"score"` // HN points; zero when the item carries none.
+	Type     string `json:"type"`  // Item type, like "story" or "comment".
+	Time     int64  `json:"time"`  // Submission time in Unix seconds; zero when unknown.
+	Text     string `json:"text"`  // HTML body of self posts and comments.
+	Kids     []int  `json:"kids"`  // IDs of the item's direct comments, in ranked order.
+	By       string `json:"by"`    // Username of the submitter.
+	StoryURL string `json:"-"`     // Not in JSON; we'll populate it manually.
+
+	// Comments is the story's total comment count, HN's "descendants";
+	// zero when the item carries none.
+	Comments int `json:"descendants"`
+
+	// Parent is the ID of the item a comment replies to, a comment or story.
+	Parent int `json:"parent"`
+
+	// StoryID and StoryTitle identify the story a comment in the feed, as
+	// from an ID list, belongs to, found by walking its parents. The comment
+	// takes the story's title and links to itself within its discussion.
+	// Not in JSON; populated by Grep and Fetch.
+	StoryID    int    `json:"-"`
+	StoryTitle string `json:"-"`
+
+	// Raw is the item's JSON body, kept by clients like HNClient with KeepRaw
+	// so Options.MatchField can read fields Story doesn't model.
+	Raw json.RawMessage `json:"-"`
+
+	// MatchedKeywords lists the canonical keywords that matched the title.
+	// Not in JSON; populated by Grep.
+	MatchedKeywords []string `json:"-"`
+
+	// Domain is the URL's host without "www.", like HN shows next to titles.
+	// Empty for self posts. Not in JSON; populated by Grep.
+	Domain string `json:"-"`
+
+	// ArticleTitle is the <title> of the linked page, fetched with Options.FetchArticleTitles.
+	ArticleTitle string `json:"-"`
+
+	// Relevance is the summed weight of MatchedKeywords. Not in JSON; populated by Grep.
+	Relevance int `json:"-"`
+
+	// MatchCount is the number of distinct keywords matched. Not in JSON; populated by Grep.
+	MatchCount int `json:"-"`
+
+	// TitleSpans are the byte offsets, start and end, of the keywords matched
+	// in Title, for highlighting, as reported by the Matcher. Not in JSON;
+	// populated by Grep.
+	TitleSpans [][2]int `json:"-"`
+
+	// Snippet is the text around the first matched keyword, which is bracketed.
+	// Not in JSON; populated by Grep.
+	Snippet string `json:"-"`
+
+	// Summary is the first line of the story's text or, failing that, of its
+	// top comment, fetched with Options.MatchContext. Not in JSON; populated by Grep.
+	Summary string `json:"-"`
+
+	// CommentText is the plain text of the comments fetched with
+	// Options.FlattenComments, which keywords are matched against too.
+	// Not in JSON; populated by Grep.
+	CommentText string `json:"-"`
+
+	// AuthorKarma is the submitter's karma, looked up for matches with
+	// Options.MinAuthorKarma. Not in JSON; populated by Grep.
+	AuthorKarma int `json:"-"`
+
+	// Link records whether the linked page is reachable. Only set with
+	// Options.CheckLinks, and not for self posts.
+	Link *LinkCheck `json:"-"`
+}
+
+// Sort orders for Options.Sort.
+const (
+	SortFeed       = "feed"       // Keep matches in feed order.
+	SortMatchCount = "matchcount" // Most distinct keywords matched first; ties keep feed order.
+)
+
+// Domain modes for Options.DomainMode.
+const (
+	DomainModeOr   = "or"   // Match stories that pass the keyword or the domain filter.
+	DomainModeAnd  = "and"  // Match only stories that pass both the keyword and the domain filter.
+	DomainModeOnly = "only" // Match on the domain filter alone, ignoring keywords and rules.
+)
+
+// Options controls which stories Grep fetches and how it filters them.
+type Options struct {
+	MaxStories int           // Maximum number of stories to fetch.
+	Keywords   []string      // Keywords matched as whole words against story titles.
+	Domain     string        // Domain matched against story URLs; empty disables it.
+	Delay      time.Duration // Delay between story fetches.
+	MaxDelay   time.Duration // If greater than Delay, each pause is drawn at random from [Delay, MaxDelay].
+
+	Synonyms     map[string][]string // Canonical keyword to synonyms that also match it.
+	Weights      map[string]int      // Lowercased keyword to weight; missing keywords weigh 1.
+	MinRelevance int                 // Minimum summed weight for a keyword match to count.
+	DomainExact  bool                // Require the URL host to equal Domain rather than contain it.
+	DomainRegex  *regexp.Regexp      // Pattern matched against each story's URL host; nil disables it.
+	DomainMode   string              // DomainModeOr, DomainModeAnd, or DomainModeOnly; empty means DomainModeOr.
+
+	MatchStrategy string  // One of Strategies; empty means StrategyBoundary.
+	Locale        string  // BCP 47 tag, like "tr", whose case rules fold keywords and titles.
+	Matcher       Matcher // Overrides MatchStrategy with a custom Matcher when set.
+	Rules         []Rule  // Scoped keyword rules; a story matching any of them matches.
+
+	// StrictAcronyms matches keywords of up to two characters, like "AI", only
+	// as written and between non-word characters, cutting false matches.
+	StrictAcronyms bool
+
+	// IgnoreHyphens treats hyphenated, joined, and spaced words alike, so
+	// "real-time" and "realtime" match "Real-time", "Realtime", and "Real time".
+	// Titles are matched as written too, so "time" still matches "Real-time".
+	IgnoreHyphens bool
+
+	SinceID int // Skip story IDs at or below this one; 0 disables it.
+
+	// MaxAge skips stories submitted longer ago than this; 0 disables it.
+	// Stories without a submission time are kept. With StopWhenStale, the
+	// first story older than MaxAge ends the run instead, as in time-ordered
+	// feeds like new every story after it is older too.
+	MaxAge        time.Duration
+	StopWhenStale bool
+
+	// RankStart and RankEnd limit the feed to that 1-based, inclusive window of
+	// ranks before anything else is applied; 0 leaves that end open.
+	RankStart int
+	RankEnd   int
+
+	Sample     bool    // Randomly sample MaxStories IDs instead of taking the top ones.
+	SampleRate float64 // If in (0, 1), fetch each of the MaxStories IDs with this probability and skip the rest.
+	Seed       int64   // Seed for Sample, SampleRate, and delay jitter; 0 picks a random seed.
+
+	// RetryNull refetches items that come back null, as just-posted ones
+	// briefly can, up to twice, RetryNullDelay apart. A zero RetryNullDelay
+	// means DefaultRetryNullDelay.
+	RetryNull      bool
+	RetryNullDelay time.Duration
+
+	// SlowThreshold warns about story fetches, retries included, that take
+	// longer than it; 0 disables the warning.
+	SlowThreshold time.Duration
+
+	MaxConsecutiveFailures int    // Abort after this many fetches fail in a row; 0 disables it.
+	Retries                int    // Times to retry a transiently failed story fetch or cut-short feed, Delay apart.
+	RetryBudget            int    // Total retries allowed across the run; 0 disables the cap.
+	RespectRetryAfter      bool   // Retry after a response's Retry-After wait, like a 429's, instead of Delay.
+	FetchArticleTitles     bool   // Also match keywords against each linked page's <title>, fetched Delay apart like stories.
+	MatchURLText           bool   // Also match keywords against the percent-decoded URL path.
+	NormalizeTitle         bool   // Match against NFKC-normalized titles with whitespace collapsed.
+	CleanURLs              bool   // Strip tracking parameters, like utm_source, from matched stories' URLs.
+	CheckLinks             bool   // Check whether each matched story's linked page is reachable.
+	DedupeTitles           bool   // Drop matches whose normalized title duplicates another, keeping the one with the most points.
+	Sort                   string // SortFeed or SortMatchCount; empty means SortFeed.
+	FailOnEmpty            bool   // Return ErrEmptyFeed instead of a warning when the feed has no IDs.
+	Color                  bool   // Highlight matched keywords in logged titles with ANSI escapes.
+
+	// FlattenComments also matches keywords against the comments of each
+	// story that didn't match on its own, fetched breadth-first down to
+	// CommentDepth levels, at most CommentLimit per story and MaxCommentFetches
+	// per run if that's above 0, Delay apart like story fetches.
+	FlattenComments   bool
+	CommentDepth      int
+	CommentLimit      int
+	MaxCommentFetches int
+
+	// MatchContext fills in each match's Summary, fetching its top comment,
+	// Delay after the story, when the story has no text of its own.
+	MatchContext bool
+
+	// MatchField also matches keywords against the JSON field at this
+	// dot-separated path, like "metadata.tags", in each story's Raw body;
+	// empty disables it. Stories without a Raw body never match on it.
+	MatchField string
+
+	// MinVelocity skips stories gaining fewer points per hour since submission;
+	// 0 disables it. Stories without a submission time are skipped too.
+	MinVelocity float64
+
+	// MinAuthorKarma, when above 0, drops matches whose submitter has less
+	// karma, looked up through a client that implements UserClient. Each
+	// author is looked up once, and at most MaxUserLookups times per run if
+	// that's above 0; matches whose author can't be looked up are dropped.
+	MinAuthorKarma int
+	MaxUserLookups int
+
+	// ScorePercentile, when above 0, keeps only matches scoring at or above
+	// that percentile, from 0 to 100, of every story fetched in the run.
+	ScorePercentile float64
+
+	// CompactLogInterval, when above 0, replaces the per-story log lines with a
+	// "Processed 120/250, matched 8." summary every that many stories and
+	// after the last. Warnings, failures, and retries are still logged.
+	CompactLogInterval int
+
+	// Verbose adds debug lines to the log, such as stories skipped for having
+	// no title or URL. They're left out otherwise, even without compaction.
+	Verbose bool
+
+	SelfOnly  bool // Keep only self posts, like Ask HN, that have no URL.
+	LinksOnly bool // Keep only link submissions that have a URL.
+
+	Logger *log.Logger // Receives progress output; discarded when nil.
+
+	// PatternDump, when non-nil, receives the compiled keyword pattern, the
+	// matching flags, and each keyword's final compiled form before any
+	// fetching. It's diagnostic and doesn't change what matches.
+	PatternDump io.Writer
+}
+
+// Result holds the outcome of a Grep call.
+type Result struct {
+	Stories  []Story   // Matched stories, in feed order unless Options.Sort says otherwise.
+	Outcomes []Outcome // Match outcome of every evaluated story, in feed order.
+
+	Available int // Number of IDs the feed returned.
+	Fetched   int // Number of stories fetched successfully.
+	Failed    int // Number of story fetches that returned an error.
+}
+
+// Outcome records how a single fetched story fared against the filters.
+type Outcome struct {
+	ID       int
+	Title    string
+	StoryURL string // The story's HN discussion page.
+	Host     string // The linked page's host without "www.", as in Story.Domain; empty for self posts.
+	Matched  bool
+	Keywords []string // Canonical keywords that matched.
+	Score    int      // Summed weight of Keywords.
+	Domain   bool     // Whether the domain filter matched.
+	Reason   string   // Why the story was rejected, one of the Reject constants; empty if it matched.
+}
+
+// Rejection reasons for Outcome.Reason.
+const (
+	RejectNoKeyword    = "no keyword hit"          // No keyword or rule matched.
+	RejectLowRelevance = "below minimum relevance" // Keywords matched, but their weights sum below MinRelevance.
+	RejectDomain       = "wrong domain"            // The domain filter, required by the domain mode, didn't match.
+	RejectKarma        = "author karma too low"    // The story matched, but its author's karma is below MinAuthorKarma.
+	RejectLowScore     = "below score percentile"  // The story matched, but scored below ScorePercentile's cutoff.
+	RejectLinkPost     = "link post"               // The story has a URL, but SelfOnly keeps only self posts.
+	RejectSelfPost     = "self post"               // The story has no URL, but LinksOnly keeps only link submissions.
+	RejectSlow         = "rising too slowly"       // The story gains fewer points per hour than MinVelocity.
+	RejectOld          = "too old"                 // The story was submitted longer ago than MaxAge.
+	RejectDuplicate    = "duplicate title"         // The story matched, but DedupeTitles kept another with the same title.
+)
+
+// RejectReasons lists every Reject constant, for callers that label or
+// translate them.
+var RejectReasons = []string{
+	RejectNoKeyword, RejectLowRelevance, RejectDomain, RejectKarma,
+	RejectLowScore, RejectLinkPost, RejectSelfPost, RejectSlow, RejectOld,
+	RejectDuplicate,
+}
+
+// compiledKeywords is what compileKeywords builds from Options.
+type compiledKeywords struct {
+	keywords    []string          // Keywords with their synonyms.
+	canonicalOf map[string]string // Lowercased keyword to the name it's reported under.
+	mo          matcherOptions
+	matcher     Matcher
+}
+
+// compileKeywords expands opts' synonyms and builds the Matcher for them, or
+// takes opts.Matcher when it's set, so Grep and Explain match the same way.
+func compileKeywords(opts Options) (compiledKeywords, error) {
+	keywords, canonicalOf := expandSynonyms(opts.Keywords, opts.Synonyms)
+	km := compiledKeywords{
+		keywords:    keywords,
+		canonicalOf: canonicalOf,
+		mo:          matcherOptions{strictAcronyms: opts.StrictAcronyms, ignoreHyphens: opts.IgnoreHyphens},
+		matcher:     opts.Matcher,
+	}
+	if opts.Locale != "" {
+		tag, err := language.Parse(opts.Locale)
+		if err != nil {
+			return km, fmt.Errorf("invalid locale %q: %w", opts.Locale, err)
+		}
+		km.mo.fold = cases.Lower(tag).String
+	}
+	if km.matcher == nil {
+		m, err := newMatcher(opts.MatchStrategy, keywords, canonicalOf, km.mo)
+		if err != nil {
+			return km, err
+		}
+		km.matcher = m
+	}
+	return km, nil
+}
+
+// Feed holds the stories Fetch fetched, for Filter to match. Filter keeps
+// what it looks up for them, like comments, article titles, and author karma,
+// in the Feed too, so filtering one Feed several times fetches each of those
+// once, and the comment fetch and user lookup caps hold across every call.
+type Feed struct {
+	Stories []Story // Fetched stories with a title or URL, in feed order.
+
+	Available int // Number of IDs the feed returned.
+	Fetched   int // Number of stories fetched successfully.
+	Failed    int // Number of story fetches that returned an error.
+
+	ranks          []int // 1-based feed rank of each of Stories.
+	pacer          *pacer
+	pages          *cachedPages
+	karma          *karmaLookup
+	commentFetches int // Comments fetched so far, for Options.MaxCommentFetches.
+}
+
+// Grep fetches story IDs from client, fetches up to opts.MaxStories of those
+// stories, and returns the ones matching opts' keywords or domain. It's Fetch
+// and Filter in one pass, matching each story as soon as it's fetched.
+//
+// If the run aborts early, because ctx is done or fetches keep failing, the
+// returned Result still holds the stories matched so far.
+func Grep(ctx context.Context, opts Options, client Client) (Result, error) {
+	f, err := newFilter(opts, client)
+	if err != nil {
+		return Result{}, err
+	}
+	feed := &Feed{}
+	err = fetchFeed(ctx, opts, client, feed, f.logger, func(i int) error {
+		return f.consider(ctx, feed, i)
+	}, f.logProgress)
+	f.res.Available, f.res.Fetched, f.res.Failed = feed.Available, feed.Fetched, feed.Failed
+	if err != nil {
+		return f.res, err
+	}
+	f.finish(ctx, feed)
+	return f.res, nil
+}
+
+// Fetch fetches the stories opts selects from client's feed, as Grep does,
+// without matching them, so Filter can match them against several sets of
+// keywords. Only opts' fetch options apply, like MaxStories, Sample, Delay,
+// and Retries.
+//
+// If the run aborts early, because ctx is done or fetches keep failing, the
+// returned Feed still holds the stories fetched so far.
+func Fetch(ctx context.Context, opts Options, client Client) (*Feed, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	feed := &Feed{}
+	err := fetchFeed(ctx, opts, client, feed, logger, nil, func(processed, total int) {
+		logger.Printf("Fetched %d/%d.", processed, total)
+	})
+	return feed, err
+}
+
+// Filter matches the stories in feed, as fetched by Fetch, against opts'
+// keywords, domain, and filters, and returns the matches like Grep. Only
+// opts' matching options apply; its fetch options were Fetch's. Comments,
+// summaries, and authors are looked up through client, Delay apart from
+// Fetch's requests and each other, and kept in feed for later calls.
+func Filter(ctx context.Context, opts Options, feed *Feed, client Client) (Result, error) {
+	f, err := newFilter(opts, client)
+	if err != nil {
+		return Result{}, err
+	}
+	f.res.Available, f.res.Fetched, f.res.Failed = feed.Available, feed.Fetched, feed.Failed
+	if feed.pacer == nil {
+		feed.pacer = &pacer{delay: opts.Delay, maxDelay: opts.MaxDelay, rng: newRand(opts.Seed)}
+	}
+	if feed.pages == nil {
+		feed.pages = newCachedPages(feed.pacer)
+	}
+	for i := range feed.Stories {
+		if err := ctx.Err(); err != nil {
+			return f.res, err
+		}
+		if opts.CompactLogInterval > 0 && i > 0 && i%opts.CompactLogInterval == 0 {
+			f.logProgress(i, len(feed.Stories))
+		}
+		if err := f.consider(ctx, feed, i); err != nil {
+			return f.res, err
+		}
+	}
+	if opts.CompactLogInterval > 0 {
+		f.logProgress(len(feed.Stories), len(feed.Stories))
+	}
+	f.finish(ctx, feed)
+	return f.res, nil
+}
+
+// fetchFeed fetches the IDs in client's feed that opts selects, then each of
+// those stories, Delay apart, adding them to feed. It calls each, if non-nil,
+// with a story's index in feed.Stories as soon as it's added, and progress
+// every CompactLogInterval stories and after the last, if that's above 0.
+func fetchFeed(ctx context.Context, opts Options, client Client, feed *Feed, logger *log.Logger, each func(i int) error, progress func(processed, total int)) error {
+	// With CompactLogInterval, per-story lines give way to a periodic
+	// progress summary. Warnings and errors still go to logger.
+	storyLog := logger
+	if opts.CompactLogInterval > 0 {
+		storyLog = log.New(io.Discard, "", 0)
+	}
+	debugLog := log.New(io.Discard, "", 0)
+	if opts.Verbose {
+		debugLog = logger
+	}
+
+	budget := newRetryBudget(opts.RetryBudget)
+	ids, err := getTopStoriesWithRetry(ctx, client, opts.Retries, opts.Delay, opts.RespectRetryAfter, budget, logger)
+	if err != nil {
+		return fmt.Errorf("failed to get top stories: %w", err)
+	}
+	feed.Available = len(ids)
+	if len(ids) == 0 {
+		if opts.FailOnEmpty {
+			return ErrEmptyFeed
+		}
+		logger.Println("Warning: the feed returned no stories; HN may be down or the endpoint may be wrong.")
+	}
+
+	if opts.RankStart > 0 || opts.RankEnd > 0 {
+		window, err := rankWindow(ids, opts.RankStart, opts.RankEnd)
+		if err != nil {
+			return err
+		}
+		logger.Printf("Keeping the %d stories in the requested rank window.", len(window))
+		ids = window
+	}
+
+	if opts.SinceID > 0 {
+		newer := idsAfter(ids, opts.SinceID)
+		logger.Printf("Skipping %d stories with IDs at or below %d.", len(ids)-len(newer), opts.SinceID)
+		ids = newer
+	}
+
+	rng := newRand(opts.Seed)
+	if opts.Sample {
+		logger.Printf("Fetched %d stories. Sampling %d at random...", len(ids), opts.MaxStories)
+		ids = sampleIDs(ids, opts.MaxStories, rng)
+	} else {
+		logger.Printf("Fetched %d stories. Displaying first %d...", len(ids), opts.MaxStories)
+	}
+	if len(ids) < opts.MaxStories {
+		logger.Printf("Only %d stories available, requested %d.", len(ids), opts.MaxStories)
+	}
+	if opts.SampleRate > 0 && opts.SampleRate < 1 {
+		if len(ids) > opts.MaxStories {
+			ids = ids[:opts.MaxStories]
+		}
+		kept := keepAtRate(ids, opts.SampleRate, rng)
+		logger.Printf("Sample rate %g kept %d of %d stories.", opts.SampleRate, len(kept), len(ids))
+		ids = kept
+	}
+	logger.Println(strings.Repeat("=", 80))
+
+	feed.pacer = &pacer{delay: opts.Delay, maxDelay: opts.MaxDelay, rng: rng}
+	feed.pages = newCachedPages(feed.pacer)
+	cutoff := time.Now().Add(-opts.MaxAge)
+	total := min(len(ids), opts.MaxStories)
+	consecutiveFailures := 0
+	for i, id := range ids {
+		if i >= opts.MaxStories {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if opts.CompactLogInterval > 0 && i > 0 && i%opts.CompactLogInterval == 0 {
+			progress(i, total)
+		}
+		if err := feed.pacer.wait(ctx); err != nil {
+			return err
+		}
+
+		fetchStart := time.Now()
+		storyData, err := getStoryWithRetry(ctx, client, id, opts.Retries, opts.Delay, opts.RespectRetryAfter, budget, logger)
+		if err == nil && storyData == nil && opts.RetryNull {
+			nullDelay := opts.RetryNullDelay
+			if nullDelay <= 0 {
+				nullDelay = DefaultRetryNullDelay
+			}
+			storyData, err = retryNullStory(ctx, client, id, nullRetries, nullDelay, logger)
+		}
+		if elapsed := time.Since(fetchStart); opts.SlowThreshold > 0 && elapsed > opts.SlowThreshold {
+			logger.Printf("Warning: fetching story %d took %s, over the %s slow threshold.", id, elapsed.Round(time.Millisecond), opts.SlowThreshold)
+		}
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			logger.Printf("Failed to fetch story %d: %v", id, err)
+			feed.Failed++
+			consecutiveFailures++
+			if opts.MaxConsecutiveFailures > 0 && consecutiveFailures >= opts.MaxConsecutiveFailures {
+				logger.Printf("Aborting after %d consecutive fetch failures.", consecutiveFailures)
+				return fmt.Errorf("%w (%d in a row): %w", ErrTooManyFailures, consecutiveFailures, err)
+			}
+			continue
+		}
+		consecutiveFailures = 0
+
+		if storyData == nil {
+			storyLog.Printf("Story %d not found (nil).", id)
+			continue
+		}
+		feed.Fetched++
+
+		if opts.StopWhenStale && opts.MaxAge > 0 && storyData.Time > 0 && time.Unix(storyData.Time, 0).Before(cutoff) {
+			logger.Printf("Story %d is older than %s; stopping, as the feed is time-ordered.", id, opts.MaxAge)
+			break
+		}
+
+		if storyData.Type == "comment" && storyData.Parent != 0 {
+			root, err := rootStory(ctx, client, storyData, feed.pacer)
+			if err != nil && ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				logger.Printf("Failed to find the story of comment %d: %v", id, err)
+			} else {
+				storyData.StoryID, storyData.StoryTitle = root.ID, root.Title
+				if storyData.Title == "" {
+					storyData.Title = root.Title
+				}
+				storyData.StoryURL = fmt.Sprintf("https://news.ycombinator.com/item?id=%d#%d", root.ID, id)
+			}
+		}
+
+		if storyData.Title == "" && storyData.URL == "" {
+			// Polls, deleted items, and the like carry nothing to match or render.
+			debugLog.Printf("Story %d has no title or URL, skipping.", id)
+			continue
+		}
+
+		feed.Stories = append(feed.Stories, *storyData)
+		feed.ranks = append(feed.ranks, i+1)
+		if each != nil {
+			if err := each(len(feed.Stories) - 1); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.CompactLogInterval > 0 {
+		progress(total, total)
+	}
+	return nil
+}
+
+// filter matches stories against Options, collecting a Result.
+type filter struct {
+	opts     Options
+	client   Client
+	logger   *log.Logger
+	storyLog *log.Logger // logger, or a discarding one with CompactLogInterval.
+	start    time.Time
+
+	matcher Matcher
+	mopts   matchOptions
+	users   UserClient // Looks up authors for MinAuthorKarma; nil without it.
+
+	res Result
+	// scores holds the score of every story with something to match, for
+	// ScorePercentile's cutoff.
+	scores []int
+}
+
+// newFilter checks opts and compiles its keywords and rules, so a bad pattern
+// fails before any fetching and every story is matched the same way.
+func newFilter(opts Options, client Client) (*filter, error) {
+	f := &filter{opts: opts, client: client, logger: opts.Logger, start: time.Now()}
+	if f.logger == nil {
+		f.logger = log.New(io.Discard, "", 0)
+	}
+	f.storyLog = f.logger
+	if opts.CompactLogInterval > 0 {
+		f.storyLog = log.New(io.Discard, "", 0)
+	}
+
+	if opts.ScorePercentile < 0 || opts.ScorePercentile > 100 {
+		return nil, fmt.Errorf("score percentile %g is outside 0 to 100", opts.ScorePercentile)
+	}
+	if opts.MinAuthorKarma > 0 {
+		users, ok := userClientOf(client)
+		if !ok {
+			return nil, fmt.Errorf("min author karma needs a client that can look up users")
+		}
+		f.users = users
+	}
+	if opts.Sort != "" && opts.Sort != SortFeed && opts.Sort != SortMatchCount {
+		return nil, fmt.Errorf("unknown sort order %q", opts.Sort)
+	}
+	switch opts.DomainMode {
+	case "", DomainModeOr:
+	case DomainModeAnd, DomainModeOnly:
+		if opts.Domain == "" && opts.DomainRegex == nil {
+			return nil, fmt.Errorf("domain mode %q needs a domain filter", opts.DomainMode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown domain mode %q", opts.DomainMode)
+	}
+
+	km, err := compileKeywords(opts)
+	if err != nil {
+		return nil, err
+	}
+	keywords, canonicalOf, mo, matcher := km.keywords, km.canonicalOf, km.mo, km.matcher
+	rules, err := compileRules(opts.Rules, opts.MatchStrategy, mo)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PatternDump != nil {
+		if err := dumpPattern(opts.PatternDump, opts.MatchStrategy, keywords, mo, matcher); err != nil {
+			return nil, fmt.Errorf("failed to dump pattern: %w", err)
+		}
+	}
+	f.matcher = matcher
+	f.mopts = matchOptions{
+		matcher:      matcher,
+		rules:        rules,
+		domain:       opts.Domain,
+		domainExact:  opts.DomainExact,
+		domainRegex:  opts.DomainRegex,
+		domainMode:   opts.DomainMode,
+		weights:      canonicalWeights(opts.Weights, canonicalOf),
+		minRelevance: opts.MinRelevance,
+		urlText:      opts.MatchURLText,
+		field:        opts.MatchField,
+
+		normalizeTitle: opts.NormalizeTitle,
+	}
+	return f, nil
+}
+
+// logProgress logs how many of total stories have been processed and matched.
+func (f *filter) logProgress(processed, total int) {
+	f.logger.Printf("Processed %d/%d, matched %d.", processed, total, len(f.res.Stories))
+}
+
+// consider matches feed.Stories[i], recording its Outcome and, if it
+// matched, adding it to the Result. What it fetches for the story, like its
+// comments, is kept in feed.
+func (f *filter) consider(ctx context.Context, feed *Feed, i int) error {
+	opts, storyLog := f.opts, f.storyLog
+	s := feed.Stories[i]
+	rank := i + 1
+	if i < len(feed.ranks) {
+		rank = feed.ranks[i]
+	}
+
+	f.scores = append(f.scores, s.Score)
+	s.Domain = storyDomain(s.URL)
+	// skip records a story that a post filter ruled out before matching.
+	skip := func(reason string) {
+		f.res.Outcomes = append(f.res.Outcomes, Outcome{ID: s.ID, Title: s.Title, StoryURL: s.StoryURL, Host: s.Domain, Reason: reason})
+	}
+	if opts.SelfOnly && s.URL != "" {
+		storyLog.Printf("Story %d is a link post, skipping.", s.ID)
+		skip(RejectLinkPost)
+		return nil
+	}
+	if opts.LinksOnly && s.URL == "" {
+		storyLog.Printf("Story %d is a self post, skipping.", s.ID)
+		skip(RejectSelfPost)
+		return nil
+	}
+	if opts.MaxAge > 0 && s.Time > 0 && time.Unix(s.Time, 0).Before(f.start.Add(-opts.MaxAge)) {
+		storyLog.Printf("Story %d is older than %s, skipping.", s.ID, opts.MaxAge)
+		skip(RejectOld)
+		return nil
+	}
+	if opts.MinVelocity > 0 {
+		if v := velocity(&s, time.Now()); v < opts.MinVelocity {
+			storyLog.Printf("Story %d rises at %.1f points per hour, below the minimum, skipping.", s.ID, v)
+			skip(RejectSlow)
+			return nil
+		}
+	}
+
+	// Log the story title to stdout
+	storyLog.Printf("[%d] Title: %s", rank, s.Title)
+
+	if opts.FetchArticleTitles && s.URL != "" {
+		articleTitle, err := feed.pages.articleTitle(ctx, s.URL)
+		if err != nil {
+			f.logger.Printf("   Failed to fetch article title: %v", err)
+		} else if articleTitle != "" {
+			s.ArticleTitle = articleTitle
+			feed.Stories[i].ArticleTitle = articleTitle
+			storyLog.Printf("   Article title: %s", articleTitle)
+		}
+	}
+
+	// Comments an earlier call fetched only count when they'd be fetched now.
+	comments := s.CommentText
+	s.CommentText = ""
+
+	// Check if this story matches the keywords or domain
+	result := matches(&s, f.mopts)
+
+	// Comments can only add keyword hits, so they're fetched for stories
+	// that missed on their own but aren't ruled out by the domain filter.
+	if opts.FlattenComments && len(s.Kids) > 0 && !result.matched() && result.rejection() != RejectDomain {
+		if comments == "" {
+			limit := opts.CommentLimit
+			if opts.MaxCommentFetches > 0 {
+				limit = min(limit, opts.MaxCommentFetches-feed.commentFetches)
+			}
+			if limit > 0 {
+				text, fetched, err := fetchCommentText(ctx, f.client, s.Kids, opts.CommentDepth, limit, feed.pacer, f.logger)
+				feed.commentFetches += fetched
+				if err != nil {
+					return err
+				}
+				comments = text
+				feed.Stories[i].CommentText = text
+			} else {
+				storyLog.Printf("   Comment fetch cap spent, not fetching comments of story %d.", s.ID)
+			}
+		}
+		s.CommentText = comments
+		result = matches(&s, f.mopts)
+	}
+	if f.users != nil && result.matched() {
+		if feed.karma == nil {
+			feed.karma = newKarmaLookup(f.users, opts.MaxUserLookups, f.logger)
+		}
+		k, ok := feed.karma.of(s.By)
+		s.AuthorKarma = k
+		result.LowKarma = !ok || k < opts.MinAuthorKarma
+	}
+	f.res.Outcomes = append(f.res.Outcomes, Outcome{
+		ID:       s.ID,
+		Title:    s.Title,
+		StoryURL: s.StoryURL,
+		Host:     s.Domain,
+		Matched:  result.matched(),
+		Keywords: result.Keywords,
+		Score:    result.Score,
+		Domain:   result.Domain,
+		Reason:   result.rejection(),
+	})
+
+	if result.matched() {
+		s.MatchedKeywords = result.Keywords
+		s.MatchCount = result.Count
+		s.Relevance = result.Score
+		if opts.CleanURLs {
+			s.URL = cleanURL(s.URL)
+		}
+		if len(s.MatchedKeywords) > 0 {
+			storyLog.Printf("   MATCHED! (%s)", strings.Join(s.MatchedKeywords, ", "))
+			s.TitleSpans = titleSpans(f.matcher, s.Title, opts.NormalizeTitle)
+			if opts.Color {
+				storyLog.Printf("   %s", highlight(s.Title, s.TitleSpans))
+			}
+			s.Snippet = snippet(s.Title, s.TitleSpans, snippetRadius)
+			if s.Snippet == "" && s.ArticleTitle != "" {
+				s.Snippet = snippet(s.ArticleTitle, titleSpans(f.matcher, s.ArticleTitle, opts.NormalizeTitle), snippetRadius)
+			}
+		} else {
+			storyLog.Println("   MATCHED!")
+		}
+		if opts.MatchContext && s.Summary == "" {
+			summary, err := fetchSummary(ctx, f.client, &s, feed.pacer, f.logger)
+			if err != nil {
+				return err
+			}
+			s.Summary = summary
+			feed.Stories[i].Summary = summary
+		}
+		f.res.Stories = append(f.res.Stories, s)
+	} else {
+		storyLog.Println("   NOT MATCHED.")
+	}
+
+	storyLog.Println(strings.Repeat("-", 80))
+	return nil
+}
+
+// finish applies the filters that weigh matches against each other, sorts
+// them, and checks their links, once every story has been considered.
+func (f *filter) finish(ctx context.Context, feed *Feed) {
+	opts, logger := f.opts, f.logger
+	res := &f.res
+
+	if opts.ScorePercentile > 0 && len(res.Stories) > 0 {
+		cutoff := scorePercentile(f.scores, opts.ScorePercentile)
+		kept := res.Stories[:0]
+		dropped := make(map[int]bool)
+		for _, s := range res.Stories {
+			if s.Score >= cutoff {
+				kept = append(kept, s)
+			} else {
+				dropped[s.ID] = true
+			}
+		}
+		for i, o := range res.Outcomes {
+			if o.Matched && dropped[o.ID] {
+				res.Outcomes[i].Matched = false
+				res.Outcomes[i].Reason = RejectLowScore
+			}
+		}
+		if dropped := len(res.Stories) - len(kept); dropped > 0 {
+			logger.Printf("Dropped %d stories scoring below %d points, the %gth percentile.", dropped, cutoff, opts.ScorePercentile)
+		}
+		res.Stories = kept
+	}
+
+	if opts.DedupeTitles {
+		deduped := dedupeTitles(res.Stories)
+		dropped := make(map[int]bool)
+		for _, s := range res.Stories {
+			dropped[s.ID] = true
+		}
+		for _, s := range deduped {
+			delete(dropped, s.ID)
+		}
+		for i, o := range res.Outcomes {
+			if o.Matched && dropped[o.ID] {
+				res.Outcomes[i].Matched = false
+				res.Outcomes[i].Reason = RejectDuplicate
+			}
+		}
+		if len(dropped) > 0 {
+			logger.Printf("Dropped %d stories with duplicate titles.", len(dropped))
+		}
+		res.Stories = deduped
+	}
+
+	if opts.Sort == SortMatchCount {
+		sort.SliceStable(res.Stories, func(i, j int) bool {
+			return res.Stories[i].MatchCount > res.Stories[j].MatchCount
+		})
+	}
+
+	if opts.CheckLinks && len(res.Stories) > 0 {
+		logger.Printf("Checking links of %d matched stories.", len(res.Stories))
+		checkLinks(ctx, feed.pages, res.Stories, logger)
+	}
+
+	logger.Printf("\nMatched %d stories.\n", len(res.Stories))
+	logger.Printf("Run took %s.", time.Since(f.start).Round(time.Millisecond))
+}
+
+// minVelocityAge is the age below which velocity treats a story as this old,
+// so a few early votes don't produce an enormous rate.
+const minVelocityAge = 10 * time.Minute
+
+// velocity returns s's approximate points per hour between its submission and
+// now, or 0 if its submission time is unknown.
+func velocity(s *Story, now time.Time) float64 {
+	if s.Time <= 0 {
+		return 0
+	}
+	age := max(now.Sub(time.Unix(s.Time, 0)), minVelocityAge)
+	return float64(s.Score) / age.Hours()
+}
+
+// scorePercentile returns the nearest-rank pth percentile of scores: the
+// smallest score that at least p percent of scores are at or below. scores
+// must be non-empty and p in (0, 100].
+func scorePercentile(scores []int, p float64) int {
+	sorted := append([]int(nil), scores...)
+	sort.Ints(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// sleep waits for d or until ctx is done, whichever comes first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// rankWindow returns the IDs ranked start through end, 1-based and inclusive.
+// A zero start or end leaves that side open; a start or end past the feed is
+// an error.
+func rankWindow(ids []int, start, end int) ([]int, error) {
+	if start <= 0 {
+		start = 1
+	}
+	if start > len(ids) {
+		return nil, fmt.Errorf("rank start %d is beyond the %d stories in the feed", start, len(ids))
+	}
+	if end > len(ids) {
+		return nil, fmt.Errorf("rank end %d is beyond the %d stories in the feed", end, len(ids))
+	}
+	if end <= 0 {
+		end = len(ids)
+	}
+	if start > end {
+		return nil, fmt.Errorf("rank start %d is after rank end %d", start, end)
+	}
+	return ids[start-1 : end], nil
+}
+
+// idsAfter returns the IDs greater than sinceID, keeping their feed order.
+func idsAfter(ids []int, sinceID int) []int {
+	newer := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if id > sinceID {
+			newer = append(newer, id)
+		}
+	}
+	return newer
+}
+
+// jitteredDelay returns a random duration in [lo, hi], so requests don't go out
+// at a predictable cadence. If hi isn't greater than lo, it returns lo.
+func jitteredDelay(lo, hi time.Duration, rng *rand.Rand) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	return lo + time.Duration(rng.Int63n(int64(hi-lo)+1))
+}
+
+// pacer spaces out a run's requests to the API, waiting a jitteredDelay
+// between each one and the next.
+type pacer struct {
+	delay, maxDelay time.Duration
+	rng             *rand.Rand
+	started         bool // Whether a request has gone out yet.
+}
+
+// wait waits out the pause before the next request, if one went out before.
+func (p *pacer) wait(ctx context.Context) error {
+	if !p.started {
+		p.started = true
+		return ctx.Err()
+	}
+	return sleep(ctx, jitteredDelay(p.delay, p.maxDelay, p.rng))
+}
+
+// newRand returns a random source seeded with seed, or with the current time if seed is 0.
+func newRand(seed int64) *rand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// keepAtRate returns the IDs in ids that each pass a coin flip landing with
+// probability rate, in their original order.
+func keepAtRate(ids []int, rate float64, rng *rand.Rand) []int {
+	var kept []int
+	for _, id := range ids {
+		if rng.Float64() < rate {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// sampleIDs randomly picks n IDs from ids, keeping them in their original feed order.
+// If n is at least len(ids), ids is returned unchanged.
+func sampleIDs(ids []int, n int, rng *rand.Rand) []int {
+	if n >= len(ids) {
+		return ids
+	}
+
+	picked := rng.Perm(len(ids))[:n]
+	sort.Ints(picked)
+
+	sampled := make([]int, n)
+	for i, idx := range picked {
+		sampled[i] = ids[idx]
+	}
+	return sampled
+}
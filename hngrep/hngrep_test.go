@@ -0,0 +1,970 @@
+package hngrep
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeClient is a mock implementation of Client.
+type FakeClient struct {
+	TopStories []int
+	Stories    map[int]Story
+	Fetched    []int         // IDs passed to GetStory, in call order.
+	Errors     map[int]error // Errors returned by GetStory for specific IDs.
+}
+
+// GetTopStories simulates fetching top story IDs.
+func (f *FakeClient) GetTopStories() ([]int, error) {
+	return f.TopStories, nil
+}
+
+// GetStory simulates fetching a story by ID.
+func (f *FakeClient) GetStory(id int) (*Story, error) {
+	f.Fetched = append(f.Fetched, id)
+	if err, ok := f.Errors[id]; ok {
+		return nil, err
+	}
+	st, ok := f.Stories[id]
+	if !ok {
+		// Simulate a story not found (nil, nil).
+		return nil, nil
+	}
+	return &st, nil
+}
+
+// storyIDs returns the IDs of stories, in order.
+func storyIDs(stories []Story) []int {
+	var ids []int
+	for _, s := range stories {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func TestGrep(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{101, 202, 303, 404},
+		Stories: map[int]Story{
+			101: {ID: 101, Title: "Go is cool", URL: "https://golang.org"},
+			202: {ID: 202, Title: "Random article", URL: "https://example.com/abc"},
+			303: {ID: 303, Title: "Rust is also cool", URL: "https://rust-lang.org"},
+		},
+	}
+
+	opts := Options{
+		MaxStories: 10,
+		Keywords:   []string{"go"},
+		Domain:     "example.com",
+	}
+
+	res, err := Grep(context.Background(), opts, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+
+	if want := []int{101, 202}; !reflect.DeepEqual(storyIDs(res.Stories), want) {
+		t.Errorf("Matched IDs = %v, want %v", storyIDs(res.Stories), want)
+	}
+	if res.Available != 4 || res.Fetched != 3 || res.Failed != 0 {
+		t.Errorf("Stats = available %d, fetched %d, failed %d; want 4, 3, 0",
+			res.Available, res.Fetched, res.Failed)
+	}
+
+	wantOutcomes := []Outcome{
+		{ID: 101, Title: "Go is cool", Host: "golang.org", Matched: true, Keywords: []string{"go"}, Score: 1},
+		{ID: 202, Title: "Random article", Host: "example.com", Matched: true, Domain: true},
+		{ID: 303, Title: "Rust is also cool", Host: "rust-lang.org", Matched: false, Reason: RejectNoKeyword},
+	}
+	if !reflect.DeepEqual(res.Outcomes, wantOutcomes) {
+		t.Errorf("Outcomes = %+v, want %+v", res.Outcomes, wantOutcomes)
+	}
+}
+
+func TestGrepFixedIDs(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{101, 202, 303},
+		Stories: map[int]Story{
+			101: {ID: 101, Title: "Go is cool"},
+			202: {ID: 202, Title: "Go is everywhere"},
+			303: {ID: 303, Title: "Rust is also cool"},
+		},
+	}
+	client := &FixedIDsClient{Client: fakeClient, IDs: []int{303, 101}}
+
+	opts := Options{MaxStories: 10, Keywords: []string{"go"}}
+	res, err := Grep(context.Background(), opts, client)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+
+	if want := []int{303, 101}; !reflect.DeepEqual(fakeClient.Fetched, want) {
+		t.Errorf("Fetched IDs = %v, want %v", fakeClient.Fetched, want)
+	}
+	if want := []int{101}; !reflect.DeepEqual(storyIDs(res.Stories), want) {
+		t.Errorf("Matched IDs = %v, want %v", storyIDs(res.Stories), want)
+	}
+}
+
+func TestGrepSkipsEmptyStories(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{101, 202},
+		Stories: map[int]Story{
+			101: {ID: 101},
+			202: {ID: 202, Title: "Go is cool"},
+		},
+	}
+
+	var logBuf bytes.Buffer
+	opts := Options{
+		MaxStories: 10,
+		Keywords:   []string{"go"},
+		Domain:     "example.com",
+		Logger:     log.New(&logBuf, "", 0),
+		Verbose:    true,
+	}
+
+	res, err := Grep(context.Background(), opts, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+
+	if !strings.Contains(logBuf.String(), "Story 101 has no title or URL, skipping.") {
+		t.Errorf("Expected skip log for story 101, got:\n%s", logBuf.String())
+	}
+	if want := []int{202}; !reflect.DeepEqual(storyIDs(res.Stories), want) {
+		t.Errorf("Matched IDs = %v, want %v", storyIDs(res.Stories), want)
+	}
+	for _, o := range res.Outcomes {
+		if o.ID == 101 {
+			t.Errorf("Expected story 101 to be excluded from the outcomes, got %+v", res.Outcomes)
+		}
+	}
+}
+
+func TestGrepSkipsEmptyStoriesQuietlyWithoutVerbose(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{101},
+		Stories:    map[int]Story{101: {ID: 101}},
+	}
+
+	var logBuf bytes.Buffer
+	opts := Options{MaxStories: 10, Keywords: []string{"go"}, Logger: log.New(&logBuf, "", 0)}
+	if _, err := Grep(context.Background(), opts, fakeClient); err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	if strings.Contains(logBuf.String(), "no title or URL") {
+		t.Errorf("Expected no skip log without Verbose, got:\n%s", logBuf.String())
+	}
+}
+
+func TestGrepFetchArticleTitles(t *testing.T) {
+	t.Parallel()
+	srv := newArticleServer(t)
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2},
+		Stories: map[int]Story{
+			1: {ID: 1, Title: "A terse title", URL: srv.URL + "/article"},
+			2: {ID: 2, Title: "Another terse title", URL: srv.URL + "/missing"},
+		},
+	}
+
+	var logBuf bytes.Buffer
+	opts := Options{
+		MaxStories:         10,
+		Keywords:           []string{"postgres"},
+		FetchArticleTitles: true,
+		Logger:             log.New(&logBuf, "", 0),
+	}
+
+	res, err := Grep(context.Background(), opts, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+
+	if want := []int{1}; !reflect.DeepEqual(storyIDs(res.Stories), want) {
+		t.Errorf("Matched IDs = %v, want %v", storyIDs(res.Stories), want)
+	}
+	if !strings.Contains(logBuf.String(), "Failed to fetch article title") {
+		t.Errorf("Expected a log line for the failed article fetch, got:\n%s", logBuf.String())
+	}
+}
+
+func TestGrepShortFeed(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2},
+		Stories: map[int]Story{
+			1: {ID: 1, Title: "Go is cool"},
+			2: {ID: 2, Title: "Rust is cool"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		maxStories int
+		wantNote   bool
+	}{
+		{name: "Feed shorter than requested", maxStories: 250, wantNote: true},
+		{name: "Feed long enough", maxStories: 2, wantNote: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			opts := Options{
+				MaxStories: tt.maxStories,
+				Keywords:   []string{"go"},
+				Logger:     log.New(&logBuf, "", 0),
+			}
+
+			if _, err := Grep(context.Background(), opts, fakeClient); err != nil {
+				t.Fatalf("Grep(...) returned error: %v", err)
+			}
+
+			note := "Only 2 stories available, requested 250."
+			if got := strings.Contains(logBuf.String(), note); got != tt.wantNote {
+				t.Errorf("Log contains %q = %v, want %v. Log:\n%s", note, got, tt.wantNote, logBuf.String())
+			}
+		})
+	}
+}
+
+func TestGrepEmptyFeed(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		failOnEmpty bool
+		wantErr     error
+	}{
+		{name: "Warns by default", failOnEmpty: false, wantErr: nil},
+		{name: "Fails when asked to", failOnEmpty: true, wantErr: ErrEmptyFeed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			opts := Options{
+				MaxStories:  10,
+				Keywords:    []string{"go"},
+				FailOnEmpty: tt.failOnEmpty,
+				Logger:      log.New(&logBuf, "", 0),
+			}
+
+			res, err := Grep(context.Background(), opts, &FakeClient{TopStories: []int{}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Grep(...) error = %v, want %v", err, tt.wantErr)
+			}
+			if len(res.Stories) != 0 {
+				t.Errorf("Expected no stories, got %v", res.Stories)
+			}
+
+			warned := strings.Contains(logBuf.String(), "Warning: the feed returned no stories")
+			if warned == tt.failOnEmpty {
+				t.Errorf("Logged warning = %v, want %v. Log:\n%s", warned, !tt.failOnEmpty, logBuf.String())
+			}
+		})
+	}
+}
+
+func TestGrepPostKind(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2, 3},
+		Stories: map[int]Story{
+			1: {ID: 1, Title: "Ask HN: Go or Rust?"},
+			2: {ID: 2, Title: "Go 1.23 released", URL: "https://go.dev/blog"},
+			3: {ID: 3, Title: "Show HN: A Go linter", URL: "https://example.com/lint"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		opts   Options
+		want   []int
+		reason string // Reason recorded for the stories the filter skips.
+	}{
+		{name: "All posts", opts: Options{}, want: []int{1, 2, 3}},
+		{name: "Self posts only", opts: Options{SelfOnly: true}, want: []int{1}, reason: RejectLinkPost},
+		{name: "Link posts only", opts: Options{LinksOnly: true}, want: []int{2, 3}, reason: RejectSelfPost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.MaxStories = 10
+			opts.Keywords = []string{"go"}
+
+			res, err := Grep(context.Background(), opts, fakeClient)
+			if err != nil {
+				t.Fatalf("Grep(...) returned error: %v", err)
+			}
+			if got := storyIDs(res.Stories); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Matched IDs = %v, want %v", got, tt.want)
+			}
+			// Skipped stories still get an outcome, for reports of every fetched story.
+			if len(res.Outcomes) != 3 {
+				t.Fatalf("Outcomes = %+v, want one for each of the 3 fetched stories", res.Outcomes)
+			}
+			for _, o := range res.Outcomes {
+				if !o.Matched && o.Reason != tt.reason {
+					t.Errorf("Reason for story %d = %q, want %q", o.ID, o.Reason, tt.reason)
+				}
+			}
+		})
+	}
+}
+
+func TestGrepSinceID(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{305, 120, 300, 410, 299},
+		Stories: map[int]Story{
+			305: {ID: 305, Title: "Go 1.23 released"},
+			410: {ID: 410, Title: "Go generics in practice"},
+			120: {ID: 120, Title: "Go at scale"},
+		},
+	}
+
+	opts := Options{MaxStories: 10, Keywords: []string{"go"}, SinceID: 300}
+	res, err := Grep(context.Background(), opts, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+
+	if want := []int{305, 410}; !reflect.DeepEqual(fakeClient.Fetched, want) {
+		t.Errorf("Fetched IDs = %v, want %v", fakeClient.Fetched, want)
+	}
+	if want := []int{305, 410}; !reflect.DeepEqual(storyIDs(res.Stories), want) {
+		t.Errorf("Matched IDs = %v, want %v", storyIDs(res.Stories), want)
+	}
+}
+
+func TestGrepRankWindow(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		start, end int
+		want       []int
+		wantErr    string
+	}{
+		{name: "Middle window", start: 2, end: 4, want: []int{20, 30, 40}},
+		{name: "Open start", end: 2, want: []int{10, 20}},
+		{name: "Open end", start: 4, want: []int{40, 50}},
+		{name: "End at the end of the feed", start: 5, end: 5, want: []int{50}},
+		{name: "End beyond the feed", start: 5, end: 99, wantErr: "rank end 99 is beyond the 5 stories in the feed"},
+		{name: "Start beyond the feed", start: 6, end: 9, wantErr: "rank start 6 is beyond the 5 stories in the feed"},
+		{name: "Start after end", start: 4, end: 3, wantErr: "rank start 4 is after rank end 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := &FakeClient{TopStories: []int{10, 20, 30, 40, 50}}
+			opts := Options{MaxStories: 10, Keywords: []string{"go"}, RankStart: tt.start, RankEnd: tt.end}
+
+			_, err := Grep(context.Background(), opts, fakeClient)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Grep(...) error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Grep(...) returned error: %v", err)
+			}
+			if !reflect.DeepEqual(fakeClient.Fetched, tt.want) {
+				t.Errorf("Fetched IDs = %v, want %v", fakeClient.Fetched, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrepNormalizeTitleHighlight(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{1},
+		Stories:    map[int]Story{1: {ID: 1, Title: "Ｇｏ　１．２４ released"}},
+	}
+
+	res, err := Grep(context.Background(), Options{MaxStories: 1, Keywords: []string{"go"}, NormalizeTitle: true}, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	if len(res.Stories) != 1 {
+		t.Fatalf("Matched %d stories, want 1", len(res.Stories))
+	}
+	// The spans cover the fullwidth keyword in the title as written.
+	s := res.Stories[0]
+	if want := [][2]int{{0, 6}}; !reflect.DeepEqual(s.TitleSpans, want) {
+		t.Errorf("TitleSpans = %v, want %v", s.TitleSpans, want)
+	}
+	if want := "[Ｇｏ]　１．２４ released"; s.Snippet != want {
+		t.Errorf("Snippet = %q, want %q", s.Snippet, want)
+	}
+}
+
+func TestGrepSortMatchCount(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2, 3, 4},
+		Stories: map[int]Story{
+			1: {ID: 1, Title: "Go tips"},
+			2: {ID: 2, Title: "Rust tips"},
+			3: {ID: 3, Title: "Go, Rust, and Zig compared"},
+			4: {ID: 4, Title: "Zig or Go?"},
+		},
+	}
+
+	tests := []struct {
+		sort string
+		want []int
+	}{
+		{sort: "", want: []int{1, 2, 3, 4}},
+		{sort: SortFeed, want: []int{1, 2, 3, 4}},
+		{sort: SortMatchCount, want: []int{3, 4, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			opts := Options{MaxStories: 10, Keywords: []string{"go", "rust", "zig"}, Sort: tt.sort}
+			res, err := Grep(context.Background(), opts, fakeClient)
+			if err != nil {
+				t.Fatalf("Grep(...) returned error: %v", err)
+			}
+			if got := storyIDs(res.Stories); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Matched IDs = %v, want %v", got, tt.want)
+			}
+			if tt.sort == SortMatchCount && res.Stories[0].MatchCount != 3 {
+				t.Errorf("Top story MatchCount = %d, want 3", res.Stories[0].MatchCount)
+			}
+		})
+	}
+}
+
+func TestSampleIDs(t *testing.T) {
+	t.Parallel()
+	ids := []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+
+	first := sampleIDs(ids, 4, newRand(42))
+	second := sampleIDs(ids, 4, newRand(42))
+
+	if len(first) != 4 {
+		t.Fatalf("Expected 4 sampled IDs, got %d: %v", len(first), first)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("Expected identical samples for the same seed, got %v and %v", first, second)
+	}
+
+	// Sampled IDs must come from the feed and keep their feed order.
+	pos := make(map[int]int, len(ids))
+	for i, id := range ids {
+		pos[id] = i
+	}
+	for i, id := range first {
+		p, ok := pos[id]
+		if !ok {
+			t.Fatalf("Sampled ID %d is not in the feed %v", id, ids)
+		}
+		if i > 0 && p <= pos[first[i-1]] {
+			t.Errorf("Sampled IDs %v are not in feed order", first)
+		}
+	}
+
+	if got := sampleIDs(ids, 20, newRand(42)); !reflect.DeepEqual(got, ids) {
+		t.Errorf("Expected all IDs when n exceeds the feed length, got %v", got)
+	}
+}
+
+func TestJitteredDelay(t *testing.T) {
+	t.Parallel()
+	lo, hi := 100*time.Millisecond, 300*time.Millisecond
+
+	rng := newRand(42)
+	for i := 0; i < 1000; i++ {
+		if d := jitteredDelay(lo, hi, rng); d < lo || d > hi {
+			t.Fatalf("jitteredDelay(%v, %v) = %v, want a duration in range", lo, hi, d)
+		}
+	}
+
+	// The same seed must produce the same sequence of delays.
+	a, b := newRand(7), newRand(7)
+	for i := 0; i < 10; i++ {
+		if da, db := jitteredDelay(lo, hi, a), jitteredDelay(lo, hi, b); da != db {
+			t.Fatalf("Delay %d differs for the same seed: %v vs %v", i, da, db)
+		}
+	}
+
+	if d := jitteredDelay(lo, lo, rng); d != lo {
+		t.Errorf("jitteredDelay(%v, %v) = %v, want the fixed delay", lo, lo, d)
+	}
+}
+
+// slowClient wraps a FakeClient, sleeping before fetching the stories in Delays.
+type slowClient struct {
+	*FakeClient
+	Delays map[int]time.Duration
+}
+
+func (c *slowClient) GetStory(id int) (*Story, error) {
+	time.Sleep(c.Delays[id])
+	return c.FakeClient.GetStory(id)
+}
+
+func TestGrepSlowThreshold(t *testing.T) {
+	t.Parallel()
+	client := &slowClient{
+		FakeClient: &FakeClient{
+			TopStories: []int{1, 2},
+			Stories: map[int]Story{
+				1: {ID: 1, Title: "Go tips"},
+				2: {ID: 2, Title: "Rust tips"},
+			},
+		},
+		Delays: map[int]time.Duration{2: 30 * time.Millisecond},
+	}
+
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		wantWarn  bool
+	}{
+		{name: "Fetch over the threshold", threshold: 10 * time.Millisecond, wantWarn: true},
+		{name: "Threshold disabled", threshold: 0, wantWarn: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			opts := Options{
+				MaxStories:    2,
+				Keywords:      []string{"go"},
+				SlowThreshold: tt.threshold,
+				Logger:        log.New(&logBuf, "", 0),
+			}
+			if _, err := Grep(context.Background(), opts, client); err != nil {
+				t.Fatalf("Grep(...) returned error: %v", err)
+			}
+
+			out := logBuf.String()
+			if got := strings.Contains(out, "Warning: fetching story 2 took"); got != tt.wantWarn {
+				t.Errorf("Slow warning for story 2 logged = %v, want %v.\nLog:\n%s", got, tt.wantWarn, out)
+			}
+			if strings.Contains(out, "fetching story 1 took") {
+				t.Errorf("Unexpected slow warning for story 1.\nLog:\n%s", out)
+			}
+			if !strings.Contains(out, "Run took ") {
+				t.Errorf("Log is missing the total run duration.\nLog:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestVelocity(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		s    Story
+		want float64
+	}{
+		{name: "Two hours old", s: Story{Score: 100, Time: now.Add(-2 * time.Hour).Unix()}, want: 50},
+		{name: "Brand new story uses the minimum age", s: Story{Score: 10, Time: now.Add(-time.Minute).Unix()}, want: 60},
+		{name: "Unknown submission time", s: Story{Score: 100}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := velocity(&tt.s, now); got != tt.want {
+				t.Errorf("velocity(%+v) = %v, want %v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrepMinVelocity(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2, 3},
+		Stories: map[int]Story{
+			1: {ID: 1, Title: "Go tips", Score: 100, Time: now.Add(-10 * time.Hour).Unix()},
+			2: {ID: 2, Title: "Go tricks", Score: 100, Time: now.Add(-time.Hour).Unix()},
+			3: {ID: 3, Title: "Go notes", Score: 100},
+		},
+	}
+
+	opts := Options{MaxStories: 3, Keywords: []string{"go"}, MinVelocity: 50}
+	res, err := Grep(context.Background(), opts, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	if got, want := storyIDs(res.Stories), []int{2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Matched IDs = %v, want %v", got, want)
+	}
+	var reasons []string
+	for _, o := range res.Outcomes {
+		reasons = append(reasons, o.Reason)
+	}
+	if want := []string{RejectSlow, "", RejectSlow}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("Outcome reasons = %q, want %q", reasons, want)
+	}
+}
+
+func TestGrepMaxAge(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	newStories := func() *FakeClient {
+		// Newest first, as in the new feed, except the undated story 3.
+		return &FakeClient{
+			TopStories: []int{1, 2, 3, 4, 5},
+			Stories: map[int]Story{
+				1: {ID: 1, Title: "Go tips", Time: now.Add(-time.Hour).Unix()},
+				2: {ID: 2, Title: "Go tricks", Time: now.Add(-2 * time.Hour).Unix()},
+				3: {ID: 3, Title: "Go notes"},
+				4: {ID: 4, Title: "Go news", Time: now.Add(-48 * time.Hour).Unix()},
+				5: {ID: 5, Title: "Go again", Time: now.Add(-72 * time.Hour).Unix()},
+			},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		stopWhenStale bool
+		wantMatched   []int
+		wantReasons   []string
+		wantFetched   []int
+	}{
+		{
+			name:        "Skips old stories",
+			wantMatched: []int{1, 2, 3},
+			wantReasons: []string{"", "", "", RejectOld, RejectOld},
+			wantFetched: []int{1, 2, 3, 4, 5},
+		},
+		{
+			name:          "Stops at the first old story",
+			stopWhenStale: true,
+			wantMatched:   []int{1, 2, 3},
+			wantReasons:   []string{"", "", ""},
+			wantFetched:   []int{1, 2, 3, 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fakeClient := newStories()
+			opts := Options{MaxStories: 5, Keywords: []string{"go"}, MaxAge: 24 * time.Hour, StopWhenStale: tt.stopWhenStale}
+			res, err := Grep(context.Background(), opts, fakeClient)
+			if err != nil {
+				t.Fatalf("Grep(...) returned error: %v", err)
+			}
+			if got := storyIDs(res.Stories); !reflect.DeepEqual(got, tt.wantMatched) {
+				t.Errorf("Matched IDs = %v, want %v", got, tt.wantMatched)
+			}
+			var reasons []string
+			for _, o := range res.Outcomes {
+				reasons = append(reasons, o.Reason)
+			}
+			if !reflect.DeepEqual(reasons, tt.wantReasons) {
+				t.Errorf("Outcome reasons = %q, want %q", reasons, tt.wantReasons)
+			}
+			if !reflect.DeepEqual(fakeClient.Fetched, tt.wantFetched) {
+				t.Errorf("Fetched IDs = %v, want %v", fakeClient.Fetched, tt.wantFetched)
+			}
+		})
+	}
+}
+
+func TestGrepCompactLog(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2, 3, 4, 5, 6, 7},
+		Stories: map[int]Story{
+			1: {ID: 1, Title: "Go tips"},
+			2: {ID: 2, Title: "Rust news"},
+			3: {ID: 3, Title: "Go generics"},
+			4: {ID: 4},
+			5: {ID: 5, Title: "Cooking"},
+			6: {ID: 6, Title: "Go faster"},
+			7: {ID: 7, Title: "Gardening"},
+		},
+		Errors: map[int]error{7: ErrServerError},
+	}
+
+	var logBuf bytes.Buffer
+	opts := Options{
+		MaxStories:         7,
+		Keywords:           []string{"go"},
+		Retries:            1,
+		CompactLogInterval: 3,
+		Logger:             log.New(&logBuf, "", 0),
+	}
+	if _, err := Grep(context.Background(), opts, fakeClient); err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+
+	var summaries []string
+	for _, line := range strings.Split(logBuf.String(), "\n") {
+		if strings.HasPrefix(line, "Processed ") {
+			summaries = append(summaries, line)
+		}
+	}
+	want := []string{"Processed 3/7, matched 2.", "Processed 6/7, matched 3.", "Processed 7/7, matched 3."}
+	if !reflect.DeepEqual(summaries, want) {
+		t.Errorf("Summary lines = %q, want %q", summaries, want)
+	}
+	for _, perStory := range []string{"Title:", "MATCHED", "Story 4 has no title", strings.Repeat("-", 80)} {
+		if strings.Contains(logBuf.String(), perStory) {
+			t.Errorf("Log contains per-story line %q, want only summaries:\n%s", perStory, logBuf.String())
+		}
+	}
+	// Retries and failures aren't per-story chatter, so they're still logged.
+	for _, warning := range []string{"Retrying story 7 (1/1)", "Failed to fetch story 7: server error"} {
+		if !strings.Contains(logBuf.String(), warning) {
+			t.Errorf("Log is missing %q:\n%s", warning, logBuf.String())
+		}
+	}
+}
+
+func TestGrepDomainModeNeedsDomain(t *testing.T) {
+	t.Parallel()
+	opts := Options{MaxStories: 1, Keywords: []string{"go"}, DomainMode: DomainModeAnd}
+	if _, err := Grep(context.Background(), opts, &FakeClient{}); err == nil {
+		t.Error("Grep(...) with domain mode and no domain filter returned nil error")
+	}
+}
+
+func TestScorePercentile(t *testing.T) {
+	t.Parallel()
+	scores := []int{50, 10, 40, 20, 30, 90, 60, 80, 70, 100}
+	tests := []struct {
+		p    float64
+		want int
+	}{
+		{p: 10, want: 10},
+		{p: 50, want: 50},
+		{p: 75, want: 80},
+		{p: 99, want: 100},
+		{p: 100, want: 100},
+	}
+
+	for _, tt := range tests {
+		if got := scorePercentile(scores, tt.p); got != tt.want {
+			t.Errorf("scorePercentile(%v, %g) = %d, want %d", scores, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestGrepScorePercentile(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2, 3, 4, 5, 6, 7, 8},
+		Stories: map[int]Story{
+			1: {ID: 1, Title: "Go tips", Score: 10},
+			2: {ID: 2, Title: "Rust tips", Score: 200},
+			3: {ID: 3, Title: "Go tricks", Score: 150},
+			4: {ID: 4, Title: "Zig tips", Score: 300},
+			5: {ID: 5, Title: "Go notes", Score: 120},
+			6: {ID: 6, Title: "C tips", Score: 5},
+			7: {ID: 7, Title: "Go news", Score: 80},
+			8: {ID: 8, Title: "Java tips", Score: 1},
+		},
+	}
+
+	// Sorted scores are 1 5 10 80 120 150 200 300, so the 75th percentile is 150.
+	opts := Options{MaxStories: 8, Keywords: []string{"go"}, ScorePercentile: 75}
+	res, err := Grep(context.Background(), opts, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	if got, want := storyIDs(res.Stories), []int{3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Matched IDs = %v, want %v", got, want)
+	}
+}
+
+func TestGrepDedupeTitlesOutcomes(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2, 3},
+		Stories: map[int]Story{
+			1: {ID: 1, Title: "Go 1.24 is out", Score: 10},
+			2: {ID: 2, Title: "Rust news", Score: 50},
+			3: {ID: 3, Title: "Go 1.24 Is Out", Score: 90},
+		},
+	}
+
+	opts := Options{MaxStories: 3, Keywords: []string{"go"}, DedupeTitles: true}
+	res, err := Grep(context.Background(), opts, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	if got, want := storyIDs(res.Stories), []int{3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Matched IDs = %v, want %v", got, want)
+	}
+	// The dropped duplicate is rejected, not left marked as a match.
+	var matched []bool
+	var reasons []string
+	for _, o := range res.Outcomes {
+		matched = append(matched, o.Matched)
+		reasons = append(reasons, o.Reason)
+	}
+	if want := []bool{false, false, true}; !reflect.DeepEqual(matched, want) {
+		t.Errorf("Outcome matched = %v, want %v", matched, want)
+	}
+	if want := []string{RejectDuplicate, RejectNoKeyword, ""}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("Outcome reasons = %q, want %q", reasons, want)
+	}
+}
+
+func TestGrepSampleRate(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{Stories: make(map[int]Story)}
+	for id := 1; id <= 2000; id++ {
+		fakeClient.TopStories = append(fakeClient.TopStories, id)
+		fakeClient.Stories[id] = Story{ID: id, Title: "Go tips"}
+	}
+
+	opts := Options{MaxStories: 1000, Keywords: []string{"go"}, SampleRate: 0.25, Seed: 7}
+	res, err := Grep(context.Background(), opts, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	// Only the first MaxStories IDs are sampled, about a quarter of them.
+	if res.Fetched < 200 || res.Fetched > 300 {
+		t.Errorf("Fetched %d stories, want about 250", res.Fetched)
+	}
+	for _, id := range fakeClient.Fetched {
+		if id > opts.MaxStories {
+			t.Fatalf("Fetched story %d, past the first %d", id, opts.MaxStories)
+		}
+	}
+
+	again := &FakeClient{TopStories: fakeClient.TopStories, Stories: fakeClient.Stories}
+	if _, err := Grep(context.Background(), opts, again); err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	if !reflect.DeepEqual(again.Fetched, fakeClient.Fetched) {
+		t.Error("Fetched different stories with the same seed")
+	}
+}
+
+func TestFetchFilter(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2, 3, 4},
+		Stories:    map[int]Story{1: {ID: 1, Title: "Go tips"}, 2: {ID: 2, Title: "Rust news"}, 4: {ID: 4}},
+		Errors:     map[int]error{3: ErrServerError},
+	}
+
+	const delay = 50 * time.Millisecond
+	opts := Options{MaxStories: 4, Delay: delay}
+	feed, err := Fetch(context.Background(), opts, fakeClient)
+	if err != nil {
+		t.Fatalf("Fetch(...) returned error: %v", err)
+	}
+	// Story 4 has nothing to match, so the feed leaves it out.
+	if got := storyIDs(feed.Stories); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("Feed stories = %v, want [1 2]", got)
+	}
+
+	for _, tt := range []struct {
+		keyword string
+		want    []int
+	}{
+		{"go", []int{1}},
+		{"rust", []int{2}},
+	} {
+		opts.Keywords = []string{tt.keyword}
+		start := time.Now()
+		res, err := Filter(context.Background(), opts, feed, fakeClient)
+		if err != nil {
+			t.Fatalf("Filter(...) for %q returned error: %v", tt.keyword, err)
+		}
+		// Nothing is left to fetch, so there's nothing to pause between.
+		if elapsed := time.Since(start); elapsed >= delay {
+			t.Errorf("Filter(...) for %q took %s, want under the %s delay", tt.keyword, elapsed, delay)
+		}
+		if got := storyIDs(res.Stories); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Matched IDs for %q = %v, want %v", tt.keyword, got, tt.want)
+		}
+		if res.Available != 4 || res.Fetched != 3 || res.Failed != 1 {
+			t.Errorf("Filter(...) for %q counted %d available, %d fetched, %d failed, want 4, 3, 1", tt.keyword, res.Available, res.Fetched, res.Failed)
+		}
+	}
+
+	// Every story is fetched once, by Fetch.
+	if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(fakeClient.Fetched, want) {
+		t.Errorf("Fetched IDs = %v, want %v", fakeClient.Fetched, want)
+	}
+}
+
+func TestFilterLookups(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	hits := make(map[string]int) // "METHOD path" to requests.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "<title>Page</title>")
+	}))
+	t.Cleanup(srv.Close)
+
+	users := &karmaClient{
+		FakeClient: &FakeClient{
+			TopStories: []int{1, 2, 3},
+			Stories: map[int]Story{
+				1: {ID: 1, Title: "Go tips", By: "gopher", URL: srv.URL + "/tips"},
+				2: {ID: 2, Title: "Go news", By: "gopher", URL: srv.URL + "/gone"},
+				3: {ID: 3, Title: "Go again", By: "stranger", URL: srv.URL + "/tips"},
+			},
+		},
+		Karma: map[string]int{"gopher": 500},
+	}
+
+	opts := Options{MaxStories: 3, Keywords: []string{"go"}, MinAuthorKarma: 100, FetchArticleTitles: true, CheckLinks: true}
+	feed, err := Fetch(context.Background(), opts, users)
+	if err != nil {
+		t.Fatalf("Fetch(...) returned error: %v", err)
+	}
+	for run := 1; run <= 2; run++ {
+		res, err := Filter(context.Background(), opts, feed, users)
+		if err != nil {
+			t.Fatalf("Filter(...) run %d returned error: %v", run, err)
+		}
+		if got := storyIDs(res.Stories); !reflect.DeepEqual(got, []int{1, 2}) {
+			t.Errorf("Run %d matched %v, want [1 2]", run, got)
+		}
+		if len(res.Stories) == 2 && (res.Stories[0].ArticleTitle != "Page" || res.Stories[1].Link == nil || res.Stories[1].Link.Alive) {
+			t.Errorf("Run %d got article title %q and link %+v, want %q and a dead link", run, res.Stories[0].ArticleTitle, res.Stories[1].Link, "Page")
+		}
+	}
+
+	// Each author is looked up once, the failed lookup too.
+	if want := []string{"gopher", "stranger"}; !reflect.DeepEqual(users.Lookups, want) {
+		t.Errorf("Looked up users %v, want %v", users.Lookups, want)
+	}
+	// Each URL's title and status is fetched once, the dead link's too.
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]int{"GET /tips": 1, "GET /gone": 1, "HEAD /tips": 1, "HEAD /gone": 1}
+	if !reflect.DeepEqual(hits, want) {
+		t.Errorf("Page requests = %v, want %v", hits, want)
+	}
+}
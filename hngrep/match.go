@@ -0,0 +1,622 @@
+package hngrep
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// expandSynonyms returns keywords extended with every synonym group that one of
+// them belongs to, along with a lookup from each lowercased term to the
+// canonical keyword it should be reported under.
+func expandSynonyms(keywords []string, synonyms map[string][]string) ([]string, map[string]string) {
+	// Index every term, canonical or synonym, by its group's canonical keyword.
+	groupOf := make(map[string]string)
+	for canonical, syns := range synonyms {
+		groupOf[strings.ToLower(canonical)] = canonical
+		for _, syn := range syns {
+			if syn = strings.TrimSpace(syn); syn != "" {
+				groupOf[strings.ToLower(syn)] = canonical
+			}
+		}
+	}
+
+	expanded := make([]string, 0, len(keywords))
+	canonicalOf := make(map[string]string)
+	add := func(term, canonical string) {
+		key := strings.ToLower(term)
+		if _, ok := canonicalOf[key]; ok {
+			return
+		}
+		canonicalOf[key] = canonical
+		expanded = append(expanded, term)
+	}
+
+	for _, kw := range keywords {
+		canonical, ok := groupOf[strings.ToLower(kw)]
+		if !ok {
+			add(kw, kw)
+			continue
+		}
+		add(kw, canonical)
+		add(canonical, canonical)
+		for _, syn := range synonyms[canonical] {
+			if syn = strings.TrimSpace(syn); syn != "" {
+				add(syn, canonical)
+			}
+		}
+	}
+	return expanded, canonicalOf
+}
+
+// compilePattern compiles a regex pattern that matches any of the provided keywords as full words.
+// A boundary is only required on a side of the keyword that starts or ends with a word
+// character, so symbol-heavy keywords like ".NET", "C++", or "F#" still match.
+func compilePattern(keywords []string) string {
+	alternatives := make([]string, 0, len(keywords))
+	for _, kw := range keywords {
+		// Lowercase each keyword; QuoteMeta below escapes regex metacharacters
+		kw = strings.ToLower(kw)
+		if kw == "" {
+			continue
+		}
+
+		first, _ := utf8.DecodeRuneInString(kw)
+		last, _ := utf8.DecodeLastRuneInString(kw)
+
+		// Simulate word boundaries: (?:^|[^A-Za-z0-9_]) for the start and (?:$|[^A-Za-z0-9_]) for the end
+		alt := `(` + regexp.QuoteMeta(kw) + `)`
+		if isWordRune(first) {
+			alt = `(?:^|[^A-Za-z0-9_])` + alt
+		}
+		if isWordRune(last) {
+			alt += `(?:$|[^A-Za-z0-9_])`
+		}
+		alternatives = append(alternatives, alt)
+	}
+
+	// Example: (?i)(?:(?:^|[^A-Za-z0-9_])(go)(?:$|[^A-Za-z0-9_])|(\.net)(?:$|[^A-Za-z0-9_]))
+	return `(?i)(?:` + strings.Join(alternatives, "|") + `)`
+}
+
+// isWordRune reports whether r is a letter, digit, or underscore.
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// matchOptions holds the filters a story is matched against.
+type matchOptions struct {
+	matcher     Matcher // Matches the synonym-expanded keywords; nil matches none.
+	rules       []compiledRule
+	domain      string
+	domainExact bool           // Require the URL host to equal domain rather than contain it.
+	domainRegex *regexp.Regexp // Pattern the URL host must match; nil disables it.
+	domainMode  string         // How the domain filter combines with keywords; empty means DomainModeOr.
+
+	weights      map[string]int // Lowercased canonical keyword to weight; missing keywords weigh 1.
+	minRelevance int            // Minimum score for a keyword match to count.
+
+	urlText        bool // Also match keywords against the URL's decoded path, like a slug.
+	normalizeTitle bool // Match against the NFKC-normalized, whitespace-collapsed titles.
+
+	field string // Dot-separated path of a raw JSON field to also match keywords against.
+}
+
+// matchResult describes which filters a story matched.
+type matchResult struct {
+	Keywords []string // Canonical keywords that matched the title.
+	Count    int      // Number of distinct keywords matched, len(Keywords).
+	Score    int      // Sum of the weights of the matched keywords.
+	Relevant bool     // Whether any keyword matched and Score meets the minimum relevance.
+	Domain   bool     // Whether the story's URL matched the domain filter.
+	Rule     bool     // Whether any scoped rule matched.
+	LowKarma bool     // Whether the author's karma is below the minimum, or unknown.
+	mode     string   // The domain mode the result was computed under.
+}
+
+// matched reports whether the story passed the filters, combining the domain
+// filter with the keyword and rule filters according to the domain mode.
+func (r matchResult) matched() bool {
+	if r.LowKarma {
+		return false
+	}
+	switch r.mode {
+	case DomainModeAnd:
+		return r.Domain && (r.Relevant || r.Rule)
+	case DomainModeOnly:
+		return r.Domain
+	default:
+		return r.Domain || r.Relevant || r.Rule
+	}
+}
+
+// rejection returns why the story didn't match, as one of the Reject
+// constants, or "" if it did.
+func (r matchResult) rejection() string {
+	switch {
+	case r.matched():
+		return ""
+	case r.LowKarma:
+		return RejectKarma
+	case r.mode == DomainModeOnly || (r.mode == DomainModeAnd && !r.Domain):
+		return RejectDomain
+	case len(r.Keywords) > 0 && !r.Relevant:
+		return RejectLowRelevance
+	default:
+		return RejectNoKeyword
+	}
+}
+
+// matches checks whether the given story's title or domain (URL) matches any
+// of the specified keywords or the provided domain filter.
+func matches(s *Story, opts matchOptions) matchResult {
+	result := matchResult{mode: opts.domainMode}
+
+	// If domain is non-empty, check the story's URL against it (case-insensitive).
+	if opts.domain != "" && domainMatches(s.URL, opts.domain, opts.domainExact) {
+		result.Domain = true
+	}
+	if opts.domainRegex != nil && hostMatches(s.URL, opts.domainRegex) {
+		result.Domain = true
+	}
+	if opts.domainMode == DomainModeOnly {
+		return result
+	}
+
+	// Check which keywords the story's title, or its linked page's title, matches and score them
+	text := s.Title
+	if s.ArticleTitle != "" {
+		text += "\n" + s.ArticleTitle
+	}
+	if opts.normalizeTitle {
+		text = foldTitle(text)
+	}
+	if opts.matcher != nil {
+		keywordText := text
+		if opts.urlText {
+			keywordText += "\n" + urlPathText(s.URL)
+		}
+		if s.CommentText != "" {
+			keywordText += "\n" + s.CommentText
+		}
+		if opts.field != "" {
+			keywordText += "\n" + fieldText(s.Raw, opts.field)
+		}
+		result.Keywords, _ = opts.matcher.Match(keywordText)
+	}
+	for _, kw := range result.Keywords {
+		weight, ok := opts.weights[strings.ToLower(kw)]
+		if !ok {
+			weight = 1
+		}
+		result.Score += weight
+	}
+	result.Count = len(result.Keywords)
+	result.Relevant = len(result.Keywords) > 0 && result.Score >= opts.minRelevance
+
+	for _, r := range opts.rules {
+		field := text
+		if r.scope == ScopeURL {
+			field = s.URL
+		}
+		if _, ok := r.matcher.Match(field); ok {
+			result.Rule = true
+			break
+		}
+	}
+	return result
+}
+
+// trackingParams are the query parameters cleanURL strips, besides any utm_ ones.
+var trackingParams = map[string]bool{"fbclid": true, "gclid": true, "ref": true}
+
+// cleanURL returns rawURL without tracking query parameters: utm_*, fbclid,
+// gclid, and ref, matched case-insensitively. The other parameters keep their
+// order and encoding. URLs that don't parse are returned as-is.
+func cleanURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+
+	params := strings.Split(u.RawQuery, "&")
+	kept := params[:0]
+	for _, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		key = strings.ToLower(key)
+		if strings.HasPrefix(key, "utm_") || trackingParams[key] {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	u.RawQuery = strings.Join(kept, "&")
+	return u.String()
+}
+
+// urlPathText returns the percent-decoded path of rawURL, like
+// "/2024/rust-performance/", or "" if it doesn't parse.
+func urlPathText(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// domainMatches reports whether rawURL matches domain, case-insensitively. In exact
+// mode the URL's host must equal domain; otherwise the URL only has to contain it.
+// Internationalized hosts and domains are compared in their punycode form, so
+// "müller.de" and "xn--mller-kva.de" are treated as the same domain.
+func domainMatches(rawURL, domain string, exact bool) bool {
+	var host string
+	if u, err := url.Parse(rawURL); err == nil {
+		host = toASCIIDomain(u.Hostname())
+	}
+
+	if !exact {
+		if strings.Contains(strings.ToLower(rawURL), strings.ToLower(domain)) {
+			return true
+		}
+		return host != "" && strings.Contains(host, toASCIIDomain(domain))
+	}
+	return host != "" && host == toASCIIDomain(domain)
+}
+
+// hostMatches reports whether the lowercased host of rawURL matches re.
+// URLs without a host, like Ask HN posts, never match.
+func hostMatches(rawURL string, re *regexp.Regexp) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	return re.MatchString(strings.ToLower(u.Hostname()))
+}
+
+// storyDomain returns the lowercased host of rawURL without a leading "www.",
+// or "" when rawURL has none, as for self posts.
+func storyDomain(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// toASCIIDomain lowercases domain and converts any Unicode labels to punycode.
+// Domains that aren't valid IDNs are returned lowercased as-is.
+func toASCIIDomain(domain string) string {
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return strings.ToLower(domain)
+	}
+	return strings.ToLower(ascii)
+}
+
+// canonicalWeights re-keys weights by the canonical keyword each weighted keyword is
+// reported under, so a weight given to a synonym applies to its whole group.
+func canonicalWeights(weights map[string]int, canonicalOf map[string]string) map[string]int {
+	if len(weights) == 0 {
+		return nil
+	}
+
+	canonical := make(map[string]int, len(weights))
+	for kw, weight := range weights {
+		name, ok := canonicalOf[kw]
+		if !ok {
+			name = kw
+		}
+		canonical[strings.ToLower(name)] = weight
+	}
+	return canonical
+}
+
+// ANSI escapes wrapped around highlighted keyword spans.
+const (
+	highlightStart = "\x1b[1;4m" // Bold and underline.
+	highlightEnd   = "\x1b[0m"
+)
+
+// highlight wraps each of spans, as returned by Matcher.Spans for title, in
+// ANSI bold and underline.
+func highlight(title string, spans [][2]int) string {
+	var b strings.Builder
+	last := 0
+	for _, span := range spans {
+		b.WriteString(title[last:span[0]])
+		b.WriteString(highlightStart)
+		b.WriteString(title[span[0]:span[1]])
+		b.WriteString(highlightEnd)
+		last = span[1]
+	}
+	b.WriteString(title[last:])
+	return b.String()
+}
+
+// snippetRadius is how many characters of context snippet keeps on each side of a match.
+const snippetRadius = 40
+
+// snippet returns the text around the first of spans, as returned by
+// Matcher.Spans for text, with the keyword in brackets and up to radius runes
+// of context on each side. Cut ends are marked with "...". It returns "" when
+// spans is empty.
+func snippet(text string, spans [][2]int, radius int) string {
+	if len(spans) == 0 {
+		return ""
+	}
+	start, end := spans[0][0], spans[0][1]
+
+	from := start
+	for n := 0; n < radius && from > 0; n++ {
+		_, size := utf8.DecodeLastRuneInString(text[:from])
+		from -= size
+	}
+	to := end
+	for n := 0; n < radius && to < len(text); n++ {
+		_, size := utf8.DecodeRuneInString(text[to:])
+		to += size
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("...")
+	}
+	b.WriteString(text[from:start])
+	b.WriteString("[" + text[start:end] + "]")
+	b.WriteString(text[end:to])
+	if to < len(text) {
+		b.WriteString("...")
+	}
+	return b.String()
+}
+
+// normalizeTitle lowercases title, strips punctuation, and collapses whitespace so
+// near-identical reposts compare equal.
+func normalizeTitle(title string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// dedupeTitles drops stories whose normalized title duplicates another one,
+// keeping the one with the most points of each group, or the most relevant
+// among those, in the position of its first occurrence. Ties go to the
+// earlier, higher-ranked story.
+func dedupeTitles(stories []Story) []Story {
+	kept := make([]Story, 0, len(stories))
+	indexOf := make(map[string]int)
+	for _, s := range stories {
+		key := normalizeTitle(s.Title)
+		if i, ok := indexOf[key]; ok {
+			if k := kept[i]; s.Score > k.Score || s.Score == k.Score && s.Relevance > k.Relevance {
+				kept[i] = s
+			}
+			continue
+		}
+		indexOf[key] = len(kept)
+		kept = append(kept, s)
+	}
+	return kept
+}
+
+// Explain writes how opts' keywords are matched, built the same way Grep builds
+// them: the strategy, flags, and compiled form of each keyword, followed by a
+// few sample titles per keyword showing what does and doesn't match.
+func Explain(w io.Writer, opts Options) error {
+	km, err := compileKeywords(opts)
+	if err != nil {
+		return err
+	}
+	if err := dumpPattern(w, opts.MatchStrategy, km.keywords, km.mo, km.matcher); err != nil {
+		return err
+	}
+
+	var notes []string
+	switch {
+	case opts.Matcher != nil:
+		notes = append(notes, "Keywords are matched by a custom matcher.")
+	case opts.MatchStrategy == StrategySubstring:
+		notes = append(notes, "Keywords match case-insensitively anywhere in a title, so a keyword glued to other letters still counts.")
+	case opts.MatchStrategy == StrategyRegex:
+		notes = append(notes, "Keywords are case-insensitive regular expressions, matched anywhere in a title.")
+	default:
+		notes = append(notes, "Keywords match case-insensitively as whole words, so a keyword glued to other letters doesn't count.")
+	}
+	if opts.StrictAcronyms && opts.MatchStrategy != StrategyRegex {
+		notes = append(notes, "Keywords of up to two characters match only as written or in capitals.")
+	}
+	if opts.IgnoreHyphens && opts.MatchStrategy != StrategyRegex {
+		notes = append(notes, `Hyphens are ignored, so "real-time" also matches "realtime" and "real time".`)
+	}
+	if _, err := fmt.Fprintf(w, "\n%s\n\nSample titles:\n", strings.Join(notes, "\n")); err != nil {
+		return err
+	}
+
+	for _, kw := range km.keywords {
+		if kw == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "\n  %s\n", kw); err != nil {
+			return err
+		}
+		for _, sample := range explainSamples(kw, opts) {
+			verdict := "no match"
+			names, ok := km.matcher.Match(sample)
+			if ok {
+				verdict = "match"
+			}
+			line := fmt.Sprintf("    %-8s  %q", verdict, sample)
+			if ok && !strings.EqualFold(strings.Join(names, ", "), kw) {
+				line += " (as " + strings.Join(names, ", ") + ")"
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// explainSamples returns the sample titles Explain tries kw against: kw on its
+// own and glued to other letters, plus the forms opts' flags are about.
+func explainSamples(kw string, opts Options) []string {
+	forms := []string{kw, kw + "lang", "re" + kw}
+	if opts.MatchStrategy != StrategyRegex {
+		if opts.StrictAcronyms && utf8.RuneCountInString(kw) <= maxAcronymLen {
+			forms = append(forms, strings.ToUpper(kw), strings.ToLower(kw))
+		}
+		if opts.IgnoreHyphens && strings.Contains(kw, "-") {
+			forms = append(forms, strings.ReplaceAll(kw, "-", ""), strings.ReplaceAll(kw, "-", " "))
+		}
+	}
+	samples := make([]string, 0, len(forms))
+	seen := make(map[string]bool)
+	for _, form := range forms {
+		if !seen[form] {
+			seen[form] = true
+			samples = append(samples, "Notes on "+form)
+		}
+	}
+	return samples
+}
+
+// foldTitle applies NFKC normalization to title, folding look-alikes such
+// as fullwidth letters into their plain forms, and collapses runs of
+// whitespace within each line into single spaces.
+func foldTitle(title string) string {
+	return foldTitleMapped(title).text
+}
+
+// foldTitleMapped is foldTitle, keeping track of where each byte came from.
+func foldTitleMapped(title string) mappedText {
+	// Normalize a segment at a time, so each output byte knows its source.
+	var normalized mappedText
+	var it norm.Iter
+	it.InitString(norm.NFKC, title)
+	for !it.Done() {
+		start := it.Pos()
+		seg := it.Next()
+		normalized.add(string(seg), start, it.Pos())
+	}
+
+	var t mappedText
+	text := normalized.text
+	lineStart, pendingSpace := true, false
+	var spaceFrom [2]int
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == '\n':
+			t.add("\n", normalized.from[i][0], normalized.from[i][1])
+			lineStart, pendingSpace = true, false
+		case unicode.IsSpace(r):
+			if !lineStart && !pendingSpace {
+				pendingSpace, spaceFrom = true, normalized.from[i]
+			}
+		default:
+			if pendingSpace {
+				t.add(" ", spaceFrom[0], spaceFrom[1])
+				pendingSpace = false
+			}
+			for j := i; j < i+size; j++ {
+				t.add(text[j:j+1], normalized.from[j][0], normalized.from[j][1])
+			}
+			lineStart = false
+		}
+		i += size
+	}
+	return t
+}
+
+// titleSpans returns the spans of matcher's keywords in title, as Matcher.Spans
+// does. With normalize, they're found in the title folded by foldTitle, as
+// matches saw it, and mapped back to title.
+func titleSpans(matcher Matcher, title string, normalize bool) [][2]int {
+	if !normalize {
+		return matcher.Spans(title)
+	}
+	folded := foldTitleMapped(title)
+	return folded.spans(matcher.Spans(folded.text))
+}
+
+// fieldText returns the text of the JSON value at path in raw, where path is a
+// dot-separated list of object keys and array indexes, like "metadata.tags" or
+// "links.0.title". Missing fields and undecodable bodies give "".
+func fieldText(raw json.RawMessage, path string) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return ""
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return ""
+			}
+			v = node[i]
+		default:
+			return ""
+		}
+	}
+	return valueText(v)
+}
+
+// valueText stringifies a decoded JSON value for matching: strings as they
+// are, numbers and booleans as written, and arrays and objects as their
+// elements' text, one per line, objects in key order.
+func valueText(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case []any:
+		lines := make([]string, 0, len(v))
+		for _, elem := range v {
+			lines = append(lines, valueText(elem))
+		}
+		return strings.Join(lines, "\n")
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		lines := make([]string, 0, len(keys))
+		for _, key := range keys {
+			lines = append(lines, valueText(v[key]))
+		}
+		return strings.Join(lines, "\n")
+	}
+	return ""
+}
@@ -0,0 +1,820 @@
+package hngrep
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCompilePattern(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		keywords []string
+		input    string
+		want     bool
+	}{
+		{
+			name:     "Single keyword, partial match",
+			keywords: []string{"go"},
+			input:    "I love golang", // Should NOT match
+			want:     false,
+		},
+		{
+			name:     "Single keyword, full word match",
+			keywords: []string{"go"},
+			input:    "Let's learn go today", // Should match
+			want:     true,
+		},
+		{
+			name:     "Multiple keywords, one matches",
+			keywords: []string{"java", "rust", "python"},
+			input:    "Rust is fast", // Should match "rust"
+			want:     true,
+		},
+		{
+			name:     "Case-insensitivity",
+			keywords: []string{"gO"},
+			input:    "Let's learn Go today", // "gO" => should match
+			want:     true,
+		},
+		{
+			name:     "No match",
+			keywords: []string{"go"},
+			input:    "Rust is also cool", // Doesn't contain "go"
+			want:     false,
+		},
+		{
+			name:     "Special regex characters, should be escaped",
+			keywords: []string{"c++", "c#"},
+			input:    "I like c++ and c# a lot", // Should match both
+			want:     true,
+		},
+		{
+			name:     "Leading symbol keyword inside a word",
+			keywords: []string{".NET"},
+			input:    "What's new in ASP.NET Core", // '.' needs no boundary before it
+			want:     true,
+		},
+		{
+			name:     "Leading symbol keyword as its own word",
+			keywords: []string{".NET"},
+			input:    "Porting .NET apps to Linux",
+			want:     true,
+		},
+		{
+			name:     "Leading symbol keyword, trailing boundary still enforced",
+			keywords: []string{".NET"},
+			input:    "Why .network effects matter",
+			want:     false,
+		},
+		{
+			name:     "Trailing symbol keyword followed by a digit",
+			keywords: []string{"C++"},
+			input:    "C++20 modules in practice", // '+' needs no boundary after it
+			want:     true,
+		},
+		{
+			name:     "Trailing symbol keyword, leading boundary still enforced",
+			keywords: []string{"C++"},
+			input:    "ObjC++ is weird",
+			want:     false,
+		},
+		{
+			name:     "Hash keyword",
+			keywords: []string{"F#"},
+			input:    "Functional web apps with F#",
+			want:     true,
+		},
+		{
+			name:     "Hash keyword, leading boundary still enforced",
+			keywords: []string{"F#"},
+			input:    "Notes on IF# macros",
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern := compilePattern(tt.keywords)
+			re := regexp.MustCompile(pattern)
+
+			got := re.MatchString(tt.input)
+			if got != tt.want {
+				t.Errorf("For keywords=%v and input=%q, pattern=%q => got %v, want %v",
+					tt.keywords, tt.input, pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		s        Story
+		keywords []string
+		domain   string
+		want     bool
+	}{
+		{
+			name:     "Domain match only",
+			s:        Story{Title: "Random Title", URL: "https://example.com/path"},
+			keywords: []string{"go"},
+			domain:   "example.com",
+			want:     true,
+		},
+		{
+			name:     "Keyword match only",
+			s:        Story{Title: "Go is awesome", URL: "https://otherdomain.com"},
+			keywords: []string{"go"},
+			domain:   "",
+			want:     true,
+		},
+		{
+			name:     "Neither domain nor keyword match",
+			s:        Story{Title: "Rust tips", URL: "https://otherdomain.com"},
+			keywords: []string{"go"},
+			domain:   "example.com",
+			want:     false,
+		},
+		{
+			name:     "Multiple keywords, domain mismatch",
+			s:        Story{Title: "Python concurrency", URL: "https://xyz.com/python"},
+			keywords: []string{"go", "rust", "python"},
+			domain:   "example.com",
+			want:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := matchOptions{matcher: mustMatcher(t, StrategyBoundary, tt.keywords), domain: tt.domain}
+			got := matches(&tt.s, opts).matched()
+			if got != tt.want {
+				t.Errorf("matches(%+v, %+v, %q) = %v, want %v",
+					tt.s, tt.keywords, tt.domain, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesURLText(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		url     string
+		urlText bool
+		want    []string
+	}{
+		{name: "Keyword only in the slug", url: "https://example.com/2024/rust-performance/", urlText: true, want: []string{"rust"}},
+		{name: "Slug ignored by default", url: "https://example.com/2024/rust-performance/", want: nil},
+		{name: "Percent-encoded slug", url: "https://example.com/posts/why%20go%3F", urlText: true, want: []string{"go"}},
+		{name: "Host isn't matched", url: "https://rust.example.com/post", urlText: true, want: nil},
+		{name: "Glued keyword in the slug", url: "https://example.com/golang-tips", urlText: true, want: nil},
+		{name: "Self post", url: "", urlText: true, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := matchOptions{matcher: mustMatcher(t, StrategyBoundary, []string{"go", "rust"}), urlText: tt.urlText}
+			got := matches(&Story{Title: "Weekend links", URL: tt.url}, opts)
+			if !reflect.DeepEqual(got.Keywords, tt.want) {
+				t.Errorf("Keywords = %v, want %v", got.Keywords, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesNormalizeTitle(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		title     string
+		normalize bool
+		want      []string
+	}{
+		{name: "Fullwidth keyword", title: "Ｇｏ　１．２４ released", normalize: true, want: []string{"go"}},
+		{name: "Fullwidth ignored by default", title: "Ｇｏ　１．２４ released", want: nil},
+		{name: "Plain title", title: "Go 1.24 released", normalize: true, want: []string{"go"}},
+		{name: "Ligature", title: "Ruﬆ tips", normalize: true, want: []string{"rust"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := matchOptions{matcher: mustMatcher(t, StrategyBoundary, []string{"go", "rust"}), normalizeTitle: tt.normalize}
+			got := matches(&Story{Title: tt.title}, opts)
+			if !reflect.DeepEqual(got.Keywords, tt.want) {
+				t.Errorf("Keywords = %v, want %v", got.Keywords, tt.want)
+			}
+		})
+	}
+}
+
+func TestFoldTitle(t *testing.T) {
+	t.Parallel()
+	got := foldTitle("Ｓｈｏｗ  ＨＮ:\t a   tool\nsecond   line")
+	if want := "Show HN: a tool\nsecond line"; got != want {
+		t.Errorf("foldTitle(...) = %q, want %q", got, want)
+	}
+}
+
+func TestTitleSpansNormalized(t *testing.T) {
+	t.Parallel()
+	m := mustMatcher(t, StrategyBoundary, []string{"go", "rust"})
+	tests := []struct {
+		name        string
+		title       string
+		want        [][2]int
+		wantSnippet string
+	}{
+		{name: "Fullwidth", title: "Ｇｏ　１．２４ released", want: [][2]int{{0, 6}}, wantSnippet: "[Ｇｏ]　１．２４ released"},
+		{name: "Ligature", title: "Ruﬆ tips", want: [][2]int{{0, 5}}, wantSnippet: "[Ruﬆ] tips"},
+		{name: "Collapsed whitespace", title: "  A   Go\ttool", want: [][2]int{{6, 8}}, wantSnippet: "  A   [Go]\ttool"},
+		{name: "No match", title: "Ｚｉｇ tips", want: nil, wantSnippet: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := titleSpans(m, tt.title, true)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("titleSpans(%q) = %v, want %v", tt.title, got, tt.want)
+			}
+			if s := snippet(tt.title, got, snippetRadius); s != tt.wantSnippet {
+				t.Errorf("snippet(%q) = %q, want %q", tt.title, s, tt.wantSnippet)
+			}
+		})
+	}
+}
+
+func TestFieldText(t *testing.T) {
+	t.Parallel()
+	raw := json.RawMessage(`{"metadata": {"tags": ["go", "db"], "rank": 3, "nsfw": false, "author": {"name": "ann", "site": "ann.dev"}}, "links": [{"title": "First"}]}`)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "metadata.tags", want: "go\ndb"},
+		{path: "metadata.tags.1", want: "db"},
+		{path: "metadata.rank", want: "3"},
+		{path: "metadata.nsfw", want: "false"},
+		{path: "metadata.author", want: "ann\nann.dev"},
+		{path: "links.0.title", want: "First"},
+		{path: "links.1.title", want: ""},
+		{path: "metadata.missing", want: ""},
+		{path: "metadata.rank.value", want: ""},
+	}
+	for _, tt := range tests {
+		if got := fieldText(raw, tt.path); got != tt.want {
+			t.Errorf("fieldText(raw, %q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	if got := fieldText(nil, "metadata.tags"); got != "" {
+		t.Errorf("fieldText(nil, ...) = %q, want empty", got)
+	}
+}
+
+func TestCleanURL(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		rawURL string
+		want   string
+	}{
+		{
+			name:   "UTM parameters removed",
+			rawURL: "https://example.com/post?utm_source=hn&utm_medium=social&utm_campaign=launch",
+			want:   "https://example.com/post",
+		},
+		{
+			name:   "Click IDs and ref removed, others kept in order",
+			rawURL: "https://example.com/search?q=go+generics&fbclid=abc&page=2&gclid=xyz&ref=hn",
+			want:   "https://example.com/search?q=go+generics&page=2",
+		},
+		{
+			name:   "Case-insensitive keys",
+			rawURL: "https://example.com/?UTM_Source=hn&id=7",
+			want:   "https://example.com/?id=7",
+		},
+		{
+			name:   "Lookalike parameters kept",
+			rawURL: "https://example.com/?referrer=a&utm=b&refs=c",
+			want:   "https://example.com/?referrer=a&utm=b&refs=c",
+		},
+		{
+			name:   "Fragment kept",
+			rawURL: "https://example.com/post?utm_source=hn#section-2",
+			want:   "https://example.com/post#section-2",
+		},
+		{name: "No query", rawURL: "https://example.com/post", want: "https://example.com/post"},
+		{name: "Self post", rawURL: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanURL(tt.rawURL); got != tt.want {
+				t.Errorf("cleanURL(%q) = %q, want %q", tt.rawURL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesRelevance(t *testing.T) {
+	t.Parallel()
+	opts := matchOptions{
+		matcher:      mustMatcher(t, StrategyBoundary, []string{"go", "rust", "python"}),
+		weights:      map[string]int{"go": 3},
+		minRelevance: 3,
+	}
+
+	tests := []struct {
+		name      string
+		title     string
+		wantScore int
+		want      bool
+	}{
+		{name: "High-weight keyword meets threshold", title: "Go generics", wantScore: 3, want: true},
+		{name: "Low-weight keywords fall below threshold", title: "Rust vs Python", wantScore: 2, want: false},
+		{name: "Mixed weights add up", title: "Go, Rust, and Python", wantScore: 5, want: true},
+		{name: "No keywords", title: "Weekend links", wantScore: 0, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matches(&Story{Title: tt.title}, opts)
+			if got.Score != tt.wantScore || got.matched() != tt.want {
+				t.Errorf("matches(%q) = score %d, matched %v; want score %d, matched %v",
+					tt.title, got.Score, got.matched(), tt.wantScore, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesSubstring(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		title     string
+		substring bool
+		want      bool
+	}{
+		{name: "Whole-word mode skips glued keyword", title: "Why I love golang", substring: false, want: false},
+		{name: "Substring mode matches glued keyword", title: "Why I love golang", substring: true, want: true},
+		{name: "Substring mode is case-insensitive", title: "GoLang tips", substring: true, want: true},
+		{name: "Whole-word mode matches standalone keyword", title: "Go tips", substring: false, want: true},
+		{name: "Substring mode, no match", title: "Rust tips", substring: true, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := StrategyBoundary
+			if tt.substring {
+				strategy = StrategySubstring
+			}
+			opts := matchOptions{matcher: mustMatcher(t, strategy, []string{"go"})}
+			if got := matches(&Story{Title: tt.title}, opts).matched(); got != tt.want {
+				t.Errorf("matches(%q, substring=%v) = %v, want %v", tt.title, tt.substring, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesDomainRegex(t *testing.T) {
+	t.Parallel()
+	re := regexp.MustCompile(`.*\.edu$`)
+	tests := []struct {
+		name  string
+		s     Story
+		want  bool
+		wantK bool
+	}{
+		{name: "Academic host", s: Story{Title: "Lecture notes", URL: "https://mit.edu/notes"}, want: true},
+		{name: "Academic subdomain", s: Story{Title: "Lecture notes", URL: "https://CSAIL.MIT.EDU/notes"}, want: true},
+		{name: "edu as a leading label", s: Story{Title: "Lecture notes", URL: "https://edu.example.com/notes"}, want: false},
+		{name: "edu only in the path", s: Story{Title: "Lecture notes", URL: "https://example.com/x.edu"}, want: false},
+		{name: "No URL", s: Story{Title: "Ask HN: Lecture notes?"}, want: false},
+		{name: "Keyword still matches", s: Story{Title: "Go lecture notes", URL: "https://example.com"}, want: true, wantK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matches(&tt.s, matchOptions{matcher: mustMatcher(t, StrategyBoundary, []string{"go"}), domainRegex: re})
+			if got.matched() != tt.want || got.Relevant != tt.wantK {
+				t.Errorf("matches(%q) = matched %v, keyword %v; want matched %v, keyword %v",
+					tt.s.URL, got.matched(), got.Relevant, tt.want, tt.wantK)
+			}
+		})
+	}
+}
+
+func TestMatchesDomainMode(t *testing.T) {
+	t.Parallel()
+	stories := map[string]Story{
+		"both":    {Title: "Go release notes", URL: "https://go.dev/doc"},
+		"keyword": {Title: "Go release notes", URL: "https://example.com/doc"},
+		"domain":  {Title: "Release notes", URL: "https://go.dev/doc"},
+		"neither": {Title: "Release notes", URL: "https://example.com/doc"},
+	}
+	tests := []struct {
+		mode string
+		want map[string]bool
+	}{
+		{mode: "", want: map[string]bool{"both": true, "keyword": true, "domain": true}},
+		{mode: DomainModeOr, want: map[string]bool{"both": true, "keyword": true, "domain": true}},
+		{mode: DomainModeAnd, want: map[string]bool{"both": true}},
+		{mode: DomainModeOnly, want: map[string]bool{"both": true, "domain": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			opts := matchOptions{matcher: mustMatcher(t, StrategyBoundary, []string{"go"}), domain: "go.dev", domainMode: tt.mode}
+			for name, s := range stories {
+				got := matches(&s, opts)
+				if got.matched() != tt.want[name] {
+					t.Errorf("matches(%q story) = %v, want %v", name, got.matched(), tt.want[name])
+				}
+				if tt.mode == DomainModeOnly && len(got.Keywords) > 0 {
+					t.Errorf("matches(%q story) keywords = %v, want none in only mode", name, got.Keywords)
+				}
+			}
+		})
+	}
+}
+
+func TestCanonicalWeights(t *testing.T) {
+	t.Parallel()
+	got := canonicalWeights(
+		map[string]int{"k8s": 4, "go": 2},
+		map[string]string{"k8s": "kubernetes", "kubernetes": "kubernetes", "go": "go"},
+	)
+	want := map[string]int{"kubernetes": 4, "go": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("canonicalWeights = %v, want %v", got, want)
+	}
+}
+
+func TestDomainMatches(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		rawURL string
+		domain string
+		exact  bool
+		want   bool
+	}{
+		{name: "Subdomain, default mode", rawURL: "https://www.example.com/post", domain: "example.com", want: true},
+		{name: "Subdomain, exact mode", rawURL: "https://www.example.com/post", domain: "example.com", exact: true, want: false},
+		{name: "Same host, exact mode", rawURL: "https://www.example.com/post", domain: "www.example.com", exact: true, want: true},
+		{name: "Case-insensitive host, exact mode", rawURL: "https://WWW.Example.com/post", domain: "www.example.COM", exact: true, want: true},
+		{name: "Host with port, exact mode", rawURL: "https://www.example.com:8443/post", domain: "www.example.com", exact: true, want: true},
+		{name: "Different host, exact mode", rawURL: "https://notexample.com", domain: "example.com", exact: true, want: false},
+		{name: "Empty URL, exact mode", rawURL: "", domain: "example.com", exact: true, want: false},
+		{name: "Unicode domain, punycode host", rawURL: "https://xn--mller-kva.de/blog", domain: "müller.de", want: true},
+		{name: "Punycode domain, Unicode host", rawURL: "https://müller.de/blog", domain: "xn--mller-kva.de", want: true},
+		{name: "Unicode domain, punycode subdomain host", rawURL: "https://blog.xn--mller-kva.de/", domain: "MÜLLER.de", want: true},
+		{name: "Unicode domain, punycode host, exact mode", rawURL: "https://xn--mller-kva.de/blog", domain: "müller.de", exact: true, want: true},
+		{name: "Punycode domain, Unicode host, exact mode", rawURL: "https://müller.de/blog", domain: "xn--mller-kva.de", exact: true, want: true},
+		{name: "Different IDN host", rawURL: "https://xn--mller-kva.de/blog", domain: "möller.de", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := domainMatches(tt.rawURL, tt.domain, tt.exact)
+			if got != tt.want {
+				t.Errorf("domainMatches(%q, %q, %v) = %v, want %v", tt.rawURL, tt.domain, tt.exact, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoryDomain(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		rawURL string
+		want   string
+	}{
+		{rawURL: "https://go.dev/blog/go1.23", want: "go.dev"},
+		{rawURL: "https://www.Example.com/post", want: "example.com"},
+		{rawURL: "https://blog.rust-lang.org:8443/", want: "blog.rust-lang.org"},
+		{rawURL: "https://müller.de/blog", want: "müller.de"},
+		{rawURL: "https://www2.example.com", want: "www2.example.com"},
+		{rawURL: "", want: ""},
+		{rawURL: "://bad", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rawURL, func(t *testing.T) {
+			if got := storyDomain(tt.rawURL); got != tt.want {
+				t.Errorf("storyDomain(%q) = %q, want %q", tt.rawURL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandSynonyms(t *testing.T) {
+	t.Parallel()
+	synonyms := map[string][]string{
+		"kubernetes": {"k8s", "kube"},
+	}
+
+	tests := []struct {
+		name          string
+		keywords      []string
+		wantExpanded  []string
+		wantCanonical map[string]string
+	}{
+		{
+			name:         "Synonym pulls in its group",
+			keywords:     []string{"k8s", "go"},
+			wantExpanded: []string{"k8s", "kubernetes", "kube", "go"},
+			wantCanonical: map[string]string{
+				"k8s":        "kubernetes",
+				"kubernetes": "kubernetes",
+				"kube":       "kubernetes",
+				"go":         "go",
+			},
+		},
+		{
+			name:         "Canonical keyword pulls in its synonyms",
+			keywords:     []string{"Kubernetes"},
+			wantExpanded: []string{"Kubernetes", "k8s", "kube"},
+			wantCanonical: map[string]string{
+				"kubernetes": "kubernetes",
+				"k8s":        "kubernetes",
+				"kube":       "kubernetes",
+			},
+		},
+		{
+			name:          "No synonyms",
+			keywords:      []string{"rust"},
+			wantExpanded:  []string{"rust"},
+			wantCanonical: map[string]string{"rust": "rust"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotExpanded, gotCanonical := expandSynonyms(tt.keywords, synonyms)
+			if !reflect.DeepEqual(gotExpanded, tt.wantExpanded) {
+				t.Errorf("expanded = %v, want %v", gotExpanded, tt.wantExpanded)
+			}
+			if !reflect.DeepEqual(gotCanonical, tt.wantCanonical) {
+				t.Errorf("canonical = %v, want %v", gotCanonical, tt.wantCanonical)
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	t.Parallel()
+	const on, off = highlightStart, highlightEnd
+	tests := []struct {
+		name      string
+		title     string
+		keywords  []string
+		substring bool
+		want      string
+	}{
+		{
+			name:     "Single keyword, boundaries left unwrapped",
+			title:    "Why Go is fun",
+			keywords: []string{"go"},
+			want:     "Why " + on + "Go" + off + " is fun",
+		},
+		{
+			name:     "Multiple keywords",
+			title:    "Rust or Go?",
+			keywords: []string{"go", "rust"},
+			want:     on + "Rust" + off + " or " + on + "Go" + off + "?",
+		},
+		{
+			name:     "Glued keyword untouched in whole-word mode",
+			title:    "Golang tips",
+			keywords: []string{"go"},
+			want:     "Golang tips",
+		},
+		{
+			name:      "Glued keyword wrapped in substring mode",
+			title:     "Golang tips",
+			keywords:  []string{"go"},
+			substring: true,
+			want:      on + "Go" + off + "lang tips",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := StrategyBoundary
+			if tt.substring {
+				strategy = StrategySubstring
+			}
+			m := mustMatcher(t, strategy, tt.keywords)
+			got := highlight(tt.title, m.Spans(tt.title))
+			if got != tt.want {
+				t.Errorf("highlight(%q) = %q, want %q", tt.title, got, tt.want)
+			}
+
+			// Every highlighted segment must be exactly the text a span covers.
+			var spans []string
+			for _, span := range m.Spans(tt.title) {
+				spans = append(spans, tt.title[span[0]:span[1]])
+			}
+			var segments []string
+			for _, part := range strings.Split(got, on)[1:] {
+				segments = append(segments, part[:strings.Index(part, off)])
+			}
+			if !reflect.DeepEqual(segments, spans) {
+				t.Errorf("Highlighted segments %q, want match spans %q", segments, spans)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	t.Parallel()
+	m := mustMatcher(t, StrategyBoundary, []string{"go"})
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "Match near the start",
+			text: "Go is a language for building simple, reliable, and efficient software",
+			want: "[Go] is a lang...",
+		},
+		{
+			name: "Match in the middle",
+			text: "A long discussion about why Go generics took a decade to land",
+			want: "...about why [Go] generics ...",
+		},
+		{
+			name: "Match near the end",
+			text: "Rewriting our whole billing pipeline from Python in Go",
+			want: "...Python in [Go]",
+		},
+		{
+			name: "Cuts on rune boundaries",
+			text: "もっと日本語のテキストと Go と日本語のテキストです",
+			want: "...日本語のテキストと [Go] と日本語のテキスト...",
+		},
+		{
+			name: "Short text kept whole",
+			text: "Go tips",
+			want: "[Go] tips",
+		},
+		{
+			name: "No match",
+			text: "Rust tips",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snippet(tt.text, m.Spans(tt.text), 10); got != tt.want {
+				t.Errorf("snippet(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{name: "Case differs", a: "Go 1.24 Released", b: "go 1.24 released", same: true},
+		{name: "Case and punctuation differ", a: "Go 1.24 is released!", b: "go 124 is released", same: true},
+		{name: "Whitespace differs", a: "The  state of\tRust", b: " The state of Rust ", same: true},
+		{name: "Dashes and quotes", a: "\"Real-time\" systems", b: "realtime systems", same: true},
+		{name: "Unicode letters kept", a: "Café culture", b: "Cafe culture", same: false},
+		{name: "Different words", a: "Show HN: My Go tool", b: "My Go tool", same: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			na, nb := normalizeTitle(tt.a), normalizeTitle(tt.b)
+			if (na == nb) != tt.same {
+				t.Errorf("normalizeTitle(%q) = %q, normalizeTitle(%q) = %q; same = %v, want %v",
+					tt.a, na, tt.b, nb, na == nb, tt.same)
+			}
+		})
+	}
+}
+
+func TestDedupeTitles(t *testing.T) {
+	t.Parallel()
+	stories := []Story{
+		{ID: 1, Title: "Go 1.24 released", Score: 10, Relevance: 1},
+		{ID: 2, Title: "Rust in the kernel", Score: 10, Relevance: 2},
+		{ID: 3, Title: "Go 1.24 Released!", Score: 10, Relevance: 3},
+		{ID: 4, Title: "rust in the kernel", Score: 10, Relevance: 2},
+		{ID: 5, Title: "Show HN: A Go linter", Score: 5, Relevance: 3},
+		{ID: 6, Title: "Show HN: a Go linter", Score: 250, Relevance: 1},
+		{ID: 7, Title: "show hn: A Go linter", Score: 40, Relevance: 3},
+	}
+
+	// The repost with the most points replaces the first, then the more
+	// relevant one among equal points; full ties keep the first.
+	if want := []int{3, 2, 6}; !reflect.DeepEqual(storyIDs(dedupeTitles(stories)), want) {
+		t.Errorf("dedupeTitles kept IDs %v, want %v", storyIDs(dedupeTitles(stories)), want)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		opts      Options
+		wantLines []string
+	}{
+		{
+			name: "Boundary",
+			opts: Options{Keywords: []string{"go", "C++"}},
+			wantLines: []string{
+				"Strategy: boundary",
+				"Pattern: " + compilePattern([]string{"go", "C++"}),
+				"as whole words",
+				`match     "Notes on go"`,
+				`no match  "Notes on golang"`,
+				`no match  "Notes on rego"`,
+				`match     "Notes on C++"`,
+				`match     "Notes on C++lang"`, // No boundary is needed after a trailing symbol.
+				`no match  "Notes on reC++"`,
+			},
+		},
+		{
+			name: "Substring",
+			opts: Options{Keywords: []string{"go"}, MatchStrategy: StrategySubstring},
+			wantLines: []string{
+				"Strategy: substring",
+				"anywhere in a title",
+				`match     "Notes on golang"`,
+				`match     "Notes on rego"`,
+			},
+		},
+		{
+			name: "Synonyms",
+			opts: Options{Keywords: []string{"go"}, Synonyms: map[string][]string{"go": {"golang"}}},
+			wantLines: []string{
+				`match     "Notes on go"`,
+				`match     "Notes on golang" (as go)`,
+			},
+		},
+		{
+			name: "Ignore hyphens",
+			opts: Options{Keywords: []string{"real-time"}, IgnoreHyphens: true},
+			wantLines: []string{
+				"Flags: case-insensitive, ignore-hyphens",
+				`match     "Notes on realtime"`,
+				`match     "Notes on real time"`,
+			},
+		},
+		{
+			name: "Strict acronyms",
+			opts: Options{Keywords: []string{"AI"}, StrictAcronyms: true},
+			wantLines: []string{
+				"(case-sensitive)",
+				`match     "Notes on AI"`,
+				`no match  "Notes on ai"`,
+				`no match  "Notes on reAI"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Explain(&buf, tt.opts); err != nil {
+				t.Fatalf("Explain returned error: %v", err)
+			}
+			out := buf.String()
+			for _, want := range tt.wantLines {
+				if !strings.Contains(out, want) {
+					t.Errorf("Explain output is missing %q.\nOutput:\n%s", want, out)
+				}
+			}
+		})
+	}
+
+	if err := Explain(io.Discard, Options{Keywords: []string{"go("}, MatchStrategy: StrategyRegex}); err == nil {
+		t.Error("Expected an error for an invalid regex keyword")
+	}
+}
+
+// mustMatcher builds the Matcher for strategy and keywords, failing the test on error.
+func mustMatcher(t *testing.T, strategy string, keywords []string) Matcher {
+	t.Helper()
+	m, err := newMatcher(strategy, keywords, nil, matcherOptions{})
+	if err != nil {
+		t.Fatalf("newMatcher(%q, %v) returned error: %v", strategy, keywords, err)
+	}
+	return m
+}
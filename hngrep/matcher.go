@@ -0,0 +1,529 @@
+package hngrep
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Match strategies for Options.MatchStrategy.
+const (
+	StrategyBoundary  = "boundary"  // Keywords match case-insensitively as whole words.
+	StrategySubstring = "substring" // Keywords match case-insensitively anywhere in the title.
+	StrategyRegex     = "regex"     // Keywords are case-insensitive regular expressions.
+)
+
+// Strategies lists the supported match strategies, in the order they're documented.
+var Strategies = []string{StrategyBoundary, StrategySubstring, StrategyRegex}
+
+// Matcher decides which keywords a story's title matches.
+type Matcher interface {
+	// Match returns the names of the keywords that title matches, in keyword
+	// order and without duplicates, and whether any matched.
+	Match(title string) (keywords []string, ok bool)
+
+	// Spans returns the start and end byte offsets in title of the text the
+	// keywords matched, in order and without overlaps, for highlighting.
+	Spans(title string) [][2]int
+}
+
+// matcherOptions tweaks how newMatcher treats keywords, on top of the strategy.
+type matcherOptions struct {
+	// fold, if non-nil, folds keywords and titles before matching, on top of
+	// the strategy's own case-insensitivity.
+	fold func(string) string
+
+	// strictAcronyms matches keywords of up to two characters, like "AI" or
+	// "Go", only as written or in capitals and only between non-word
+	// characters, so they don't fire on common words. Regex keywords are exempt.
+	strictAcronyms bool
+
+	// ignoreHyphens strips hyphens from keywords and titles, so "real-time"
+	// and "realtime" match each other. A hyphenated keyword also matches its
+	// spaced form, "real time". Regex keywords are exempt.
+	ignoreHyphens bool
+}
+
+// newMatcher returns the Matcher for strategy, reporting each keyword under
+// canonicalOf[lowercased keyword] when present. An empty strategy means
+// StrategyBoundary.
+func newMatcher(strategy string, keywords []string, canonicalOf map[string]string, mo matcherOptions) (Matcher, error) {
+	var acronyms []string
+	if mo.strictAcronyms && strategy != StrategyRegex {
+		rest := make([]string, 0, len(keywords))
+		for _, kw := range keywords {
+			if n := utf8.RuneCountInString(kw); n > 0 && n <= maxAcronymLen {
+				acronyms = append(acronyms, kw)
+			} else {
+				rest = append(rest, kw)
+			}
+		}
+		keywords = rest
+	}
+
+	ignoreHyphens := mo.ignoreHyphens && strategy != StrategyRegex
+	keywordFold := mo.fold
+	if ignoreHyphens {
+		keywords, canonicalOf = withSpacedHyphens(keywords, canonicalOf)
+		keywordFold = stripHyphens
+		if mo.fold != nil {
+			keywordFold = func(s string) string { return stripHyphens(mo.fold(s)) }
+		}
+	}
+
+	m, err := newStrategyMatcher(strategy, keywords, canonicalOf, keywordFold)
+	if err != nil {
+		return nil, err
+	}
+	if ignoreHyphens {
+		// The title as written still matches parts like "time" in "Real-time";
+		// the other forms let "realtime" match "Real-time" and "Real time".
+		m = &formsMatcher{forms: []func(string) mappedText{nil, stripHyphensMapped, joinWordPairs}, inner: m}
+	}
+	if mo.fold != nil {
+		m = &foldingMatcher{fold: mo.fold, inner: m}
+	}
+	if len(acronyms) > 0 {
+		// Acronyms see the original title, since folding would erase their case.
+		m = mergedMatcher{newAcronymMatcher(acronyms, canonicalOf), m}
+	}
+	return m, nil
+}
+
+// newStrategyMatcher builds the concrete Matcher for strategy, folding the
+// keywords, but not their reported names, with fold when it's non-nil.
+// Regex keywords are left alone, since folding could change what a pattern
+// means, like `\S` into `\s`; (?i) already covers their case.
+func newStrategyMatcher(strategy string, keywords []string, canonicalOf map[string]string, fold func(string) string) (Matcher, error) {
+	names := make([]string, 0, len(keywords))
+	terms := make([]string, 0, len(keywords))
+	for _, kw := range keywords {
+		if kw == "" {
+			continue
+		}
+		name, ok := canonicalOf[strings.ToLower(kw)]
+		if !ok {
+			name = kw
+		}
+		names = append(names, name)
+		if fold != nil && strategy != StrategyRegex {
+			kw = fold(kw)
+		}
+		terms = append(terms, kw)
+	}
+
+	switch strategy {
+	case "", StrategyBoundary:
+		m := &boundaryMatcher{names: names, patterns: make([]*regexp.Regexp, len(terms))}
+		for i, kw := range terms {
+			m.patterns[i] = regexp.MustCompile(compilePattern([]string{kw}))
+		}
+		return m, nil
+	case StrategySubstring:
+		m := &substringMatcher{names: names, terms: make([]string, len(terms)), patterns: make([]*regexp.Regexp, len(terms))}
+		for i, kw := range terms {
+			m.terms[i] = strings.ToLower(kw)
+			m.patterns[i] = regexp.MustCompile(`(?i)` + regexp.QuoteMeta(kw))
+		}
+		return m, nil
+	case StrategyRegex:
+		m := &regexMatcher{names: names, patterns: make([]*regexp.Regexp, len(terms))}
+		for i, kw := range terms {
+			re, err := regexp.Compile(`(?i)` + kw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid keyword pattern %q: %w", kw, err)
+			}
+			m.patterns[i] = re
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unknown match strategy %q", strategy)
+	}
+}
+
+// stripHyphens removes ASCII hyphens from s.
+func stripHyphens(s string) string {
+	return strings.ReplaceAll(s, "-", "")
+}
+
+// stripHyphensMapped is stripHyphens, keeping track of where each byte came from.
+func stripHyphensMapped(s string) mappedText {
+	var t mappedText
+	for i := 0; i < len(s); i++ {
+		if s[i] != '-' {
+			t.add(s[i:i+1], i, i+1)
+		}
+	}
+	return t
+}
+
+// joinWordPairs returns each pair of adjacent words in s, split at spaces and
+// hyphens, written without the break between them: "Real time chat" gives
+// "Realtime timechat".
+func joinWordPairs(s string) mappedText {
+	var words [][2]int
+	start := -1
+	for i, r := range s {
+		if r == '-' || unicode.IsSpace(r) {
+			if start >= 0 {
+				words = append(words, [2]int{start, i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, [2]int{start, len(s)})
+	}
+
+	var t mappedText
+	for i := 1; i < len(words); i++ {
+		if i > 1 {
+			// The space between pairs isn't in s; credit it to the previous word's end.
+			t.add(" ", words[i-1][1], words[i-1][1])
+		}
+		for _, w := range words[i-1 : i+1] {
+			for j := w[0]; j < w[1]; j++ {
+				t.add(s[j:j+1], j, j+1)
+			}
+		}
+	}
+	return t
+}
+
+// mappedText is text derived from a title, with the title span each of its
+// bytes came from, so spans found in the text can be mapped back to the title.
+type mappedText struct {
+	text string
+	from [][2]int
+}
+
+// add appends s to t as coming from title[start:end].
+func (t *mappedText) add(s string, start, end int) {
+	t.text += s
+	for range len(s) {
+		t.from = append(t.from, [2]int{start, end})
+	}
+}
+
+// spans maps spans found in t.text back to the title, dropping any that map
+// to nothing.
+func (t mappedText) spans(spans [][2]int) [][2]int {
+	var mapped [][2]int
+	for _, span := range spans {
+		if span[1] <= span[0] || span[1] > len(t.from) {
+			continue
+		}
+		start, end := t.from[span[0]][0], t.from[span[1]-1][1]
+		if end > start {
+			mapped = append(mapped, [2]int{start, end})
+		}
+	}
+	return mergeSpans(mapped)
+}
+
+// foldMapped folds title rune by rune, keeping track of where each byte came
+// from. It reports false when fold doesn't work rune by rune, since the
+// offsets wouldn't line up.
+func foldMapped(fold func(string) string, title string) (mappedText, bool) {
+	var t mappedText
+	for i, r := range title {
+		t.add(fold(string(r)), i, i+utf8.RuneLen(r))
+	}
+	return t, t.text == fold(title)
+}
+
+// mergeSpans sorts spans by start and merges the ones that overlap.
+func mergeSpans(spans [][2]int) [][2]int {
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	var merged [][2]int
+	for _, span := range spans {
+		if n := len(merged); n > 0 && span[0] < merged[n-1][1] {
+			merged[n-1][1] = max(merged[n-1][1], span[1])
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
+
+// patternSpans returns the spans of every non-empty match of patterns in
+// title, using the given capture group; 0 means the whole match.
+func patternSpans(patterns []*regexp.Regexp, title string, group int) [][2]int {
+	var spans [][2]int
+	for _, re := range patterns {
+		for _, m := range re.FindAllStringSubmatchIndex(title, -1) {
+			if start, end := m[2*group], m[2*group+1]; start >= 0 && end > start {
+				spans = append(spans, [2]int{start, end})
+			}
+		}
+	}
+	return mergeSpans(spans)
+}
+
+// withSpacedHyphens returns keywords with the spaced form of each hyphenated
+// keyword added after it, like "real time" for "real-time", and canonicalOf
+// extended to report it under the same name.
+func withSpacedHyphens(keywords []string, canonicalOf map[string]string) ([]string, map[string]string) {
+	expanded := make([]string, 0, len(keywords))
+	canonical := make(map[string]string, len(canonicalOf))
+	for k, v := range canonicalOf {
+		canonical[k] = v
+	}
+	for _, kw := range keywords {
+		expanded = append(expanded, kw)
+		if !strings.Contains(kw, "-") {
+			continue
+		}
+		name, ok := canonicalOf[strings.ToLower(kw)]
+		if !ok {
+			name = kw
+		}
+		spaced := strings.ReplaceAll(kw, "-", " ")
+		if _, ok := canonical[strings.ToLower(spaced)]; !ok {
+			canonical[strings.ToLower(spaced)] = name
+			expanded = append(expanded, spaced)
+		}
+	}
+	return expanded, canonical
+}
+
+// boundaryMatcher matches keywords as whole words; see compilePattern.
+type boundaryMatcher struct {
+	names    []string
+	patterns []*regexp.Regexp
+}
+
+func (m *boundaryMatcher) Match(title string) ([]string, bool) {
+	return collectMatches(m.names, func(i int) bool { return m.patterns[i].MatchString(title) })
+}
+
+// Spans leaves out the boundary characters compilePattern matches around
+// each keyword.
+func (m *boundaryMatcher) Spans(title string) [][2]int {
+	return patternSpans(m.patterns, title, 1)
+}
+
+// substringMatcher matches keywords anywhere in the title, so "go" also matches "golang".
+type substringMatcher struct {
+	names    []string
+	terms    []string         // Lowercased keywords.
+	patterns []*regexp.Regexp // Quoted, case-insensitive keywords, for Spans.
+}
+
+func (m *substringMatcher) Match(title string) ([]string, bool) {
+	lower := strings.ToLower(title)
+	return collectMatches(m.names, func(i int) bool { return strings.Contains(lower, m.terms[i]) })
+}
+
+// Spans searches title itself rather than its lowercased form, whose byte
+// offsets can differ.
+func (m *substringMatcher) Spans(title string) [][2]int {
+	return patternSpans(m.patterns, title, 0)
+}
+
+// regexMatcher treats each keyword as a case-insensitive regular expression.
+type regexMatcher struct {
+	names    []string
+	patterns []*regexp.Regexp
+}
+
+func (m *regexMatcher) Match(title string) ([]string, bool) {
+	return collectMatches(m.names, func(i int) bool { return m.patterns[i].MatchString(title) })
+}
+
+func (m *regexMatcher) Spans(title string) [][2]int {
+	return patternSpans(m.patterns, title, 0)
+}
+
+// foldingMatcher folds titles before handing them to a Matcher built from
+// keywords folded the same way.
+type foldingMatcher struct {
+	fold  func(string) string
+	inner Matcher
+}
+
+func (m *foldingMatcher) Match(title string) ([]string, bool) {
+	return m.inner.Match(m.fold(title))
+}
+
+// Spans maps the spans found in the folded title back to title. When fold
+// can't be mapped rune by rune, it falls back to the spans of title as written.
+func (m *foldingMatcher) Spans(title string) [][2]int {
+	folded, ok := foldMapped(m.fold, title)
+	if !ok {
+		return m.inner.Spans(title)
+	}
+	return folded.spans(m.inner.Spans(folded.text))
+}
+
+// formsMatcher matches several forms of each title, the title itself for a
+// nil form, and reports the keywords any of them matched, without duplicates.
+type formsMatcher struct {
+	forms []func(string) mappedText
+	inner Matcher
+}
+
+func (m *formsMatcher) Match(title string) ([]string, bool) {
+	var names []string
+	for _, form := range m.forms {
+		text := title
+		if form != nil {
+			text = form(title).text
+		}
+		got, _ := m.inner.Match(text)
+		names = append(names, got...)
+	}
+	return collectMatches(names, func(int) bool { return true })
+}
+
+func (m *formsMatcher) Spans(title string) [][2]int {
+	var spans [][2]int
+	for _, form := range m.forms {
+		if form == nil {
+			spans = append(spans, m.inner.Spans(title)...)
+			continue
+		}
+		t := form(title)
+		spans = append(spans, t.spans(m.inner.Spans(t.text))...)
+	}
+	return mergeSpans(spans)
+}
+
+// maxAcronymLen is the longest keyword, in runes, that strict acronym matching applies to.
+const maxAcronymLen = 2
+
+// acronymMatcher matches short keywords between non-word characters, as written
+// or in capitals, so "AI" matches "AI is here" but not "Thailand" or "ai", and
+// "ml" matches "ML" and "ml" but not "Ml".
+type acronymMatcher struct {
+	names    []string
+	patterns []*regexp.Regexp
+}
+
+func newAcronymMatcher(keywords []string, canonicalOf map[string]string) *acronymMatcher {
+	m := &acronymMatcher{}
+	for _, kw := range keywords {
+		name, ok := canonicalOf[strings.ToLower(kw)]
+		if !ok {
+			name = kw
+		}
+		m.names = append(m.names, name)
+		forms := regexp.QuoteMeta(kw) + `|` + regexp.QuoteMeta(strings.ToUpper(kw))
+		m.patterns = append(m.patterns, regexp.MustCompile(`(?:^|[^\pL\pN_])(`+forms+`)(?:$|[^\pL\pN_])`))
+	}
+	return m
+}
+
+func (m *acronymMatcher) Match(title string) ([]string, bool) {
+	return collectMatches(m.names, func(i int) bool { return m.patterns[i].MatchString(title) })
+}
+
+func (m *acronymMatcher) Spans(title string) [][2]int {
+	return patternSpans(m.patterns, title, 1)
+}
+
+// mergedMatcher reports the keywords matched by any of its Matchers, in
+// Matcher order and without duplicates.
+type mergedMatcher []Matcher
+
+func (mm mergedMatcher) Match(title string) ([]string, bool) {
+	var names []string
+	for _, m := range mm {
+		got, _ := m.Match(title)
+		names = append(names, got...)
+	}
+	return collectMatches(names, func(int) bool { return true })
+}
+
+func (mm mergedMatcher) Spans(title string) [][2]int {
+	var spans [][2]int
+	for _, m := range mm {
+		spans = append(spans, m.Spans(title)...)
+	}
+	return mergeSpans(spans)
+}
+
+// collectMatches returns the names whose index hit reports true, without duplicates,
+// along with whether there were any.
+func collectMatches(names []string, hit func(i int) bool) ([]string, bool) {
+	var matched []string
+	seen := make(map[string]bool)
+	for i, name := range names {
+		if seen[name] || !hit(i) {
+			continue
+		}
+		seen[name] = true
+		matched = append(matched, name)
+	}
+	return matched, len(matched) > 0
+}
+
+// dumpPattern writes the pattern compilePattern builds from keywords, for the
+// boundary strategy, the flags matching runs with, and the compiled form of
+// each keyword in m to w.
+func dumpPattern(w io.Writer, strategy string, keywords []string, mo matcherOptions, m Matcher) error {
+	if strategy == "" {
+		strategy = StrategyBoundary
+	}
+	flags := []string{"case-insensitive"}
+	if mo.fold != nil {
+		flags = append(flags, "locale-folded")
+	}
+	if mo.strictAcronyms {
+		flags = append(flags, "strict-acronyms")
+	}
+	if mo.ignoreHyphens {
+		flags = append(flags, "ignore-hyphens")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Strategy: %s\n", strategy)
+	fmt.Fprintf(&b, "Keywords: %s\n", strings.Join(keywords, ", "))
+	if strategy == StrategyBoundary {
+		fmt.Fprintf(&b, "Pattern: %s\n", compilePattern(keywords))
+	}
+	fmt.Fprintf(&b, "Flags: %s\n", strings.Join(flags, ", "))
+	b.WriteString("Compiled:\n")
+	describeMatcher(&b, m)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// describeMatcher writes one line per keyword in m, naming the keyword and
+// what it's matched with.
+func describeMatcher(b *strings.Builder, m Matcher) {
+	switch m := m.(type) {
+	case *boundaryMatcher:
+		for i, name := range m.names {
+			fmt.Fprintf(b, "  %s: %s\n", name, m.patterns[i])
+		}
+	case *regexMatcher:
+		for i, name := range m.names {
+			fmt.Fprintf(b, "  %s: %s\n", name, m.patterns[i])
+		}
+	case *acronymMatcher:
+		for i, name := range m.names {
+			fmt.Fprintf(b, "  %s: %s (case-sensitive)\n", name, m.patterns[i])
+		}
+	case *substringMatcher:
+		for i, name := range m.names {
+			fmt.Fprintf(b, "  %s: substring %q\n", name, m.terms[i])
+		}
+	case *foldingMatcher:
+		describeMatcher(b, m.inner)
+	case *formsMatcher:
+		describeMatcher(b, m.inner)
+	case mergedMatcher:
+		for _, inner := range m {
+			describeMatcher(b, inner)
+		}
+	default:
+		fmt.Fprintf(b, "  custom matcher %T\n", m)
+	}
+}
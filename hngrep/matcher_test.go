@@ -0,0 +1,276 @@
+package hngrep
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+func TestMatcherCanonicalNames(t *testing.T) {
+	t.Parallel()
+	keywords, canonicalOf := expandSynonyms(
+		[]string{"k8s", "go"},
+		map[string][]string{"kubernetes": {"k8s"}},
+	)
+	m, err := newMatcher(StrategyBoundary, keywords, canonicalOf, matcherOptions{})
+	if err != nil {
+		t.Fatalf("newMatcher returned error: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		title string
+		want  []string
+	}{
+		{
+			name:  "Synonym reported under canonical keyword",
+			title: "Running k8s at home",
+			want:  []string{"kubernetes"},
+		},
+		{
+			name:  "Canonical and synonym reported once",
+			title: "Kubernetes vs k8s: a naming story",
+			want:  []string{"kubernetes"},
+		},
+		{
+			name:  "Multiple keywords",
+			title: "Writing Kubernetes operators in Go",
+			want:  []string{"kubernetes", "go"},
+		},
+		{
+			name:  "No match",
+			title: "Rust tips",
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := m.Match(tt.title)
+			if !reflect.DeepEqual(got, tt.want) || ok != (tt.want != nil) {
+				t.Errorf("Match(%q) = %v, %v; want %v", tt.title, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		strategy string
+		keywords []string
+		title    string
+		want     []string
+	}{
+		{name: "Boundary, whole word", strategy: StrategyBoundary, keywords: []string{"go"}, title: "Go 1.23 is out", want: []string{"go"}},
+		{name: "Boundary, glued word", strategy: StrategyBoundary, keywords: []string{"go"}, title: "Golang tips", want: nil},
+		{name: "Boundary, symbol keyword", strategy: StrategyBoundary, keywords: []string{"C++"}, title: "C++20 modules", want: []string{"C++"}},
+		{name: "Substring, glued word", strategy: StrategySubstring, keywords: []string{"go"}, title: "Golang tips", want: []string{"go"}},
+		{name: "Substring, no match", strategy: StrategySubstring, keywords: []string{"go"}, title: "Rust tips", want: nil},
+		{name: "Regex, alternation", strategy: StrategyRegex, keywords: []string{`postgres(ql)?`}, title: "Scaling PostgreSQL", want: []string{`postgres(ql)?`}},
+		{name: "Regex, anchored", strategy: StrategyRegex, keywords: []string{`^show hn`}, title: "Show HN: A tiny grep", want: []string{`^show hn`}},
+		{name: "Regex, anchored no match", strategy: StrategyRegex, keywords: []string{`^show hn`}, title: "Why I love Show HN", want: nil},
+		{name: "Empty strategy means boundary", strategy: "", keywords: []string{"go"}, title: "Golang tips", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mustMatcher(t, tt.strategy, tt.keywords)
+			got, ok := m.Match(tt.title)
+			if !reflect.DeepEqual(got, tt.want) || ok != (tt.want != nil) {
+				t.Errorf("%s Match(%q) = %v, %v; want %v", tt.strategy, tt.title, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatcherLocaleFolding(t *testing.T) {
+	t.Parallel()
+	turkish := cases.Lower(language.Turkish).String
+
+	tests := []struct {
+		name     string
+		strategy string
+		keyword  string
+		title    string
+		fold     func(string) string
+		want     bool
+	}{
+		// Default folding maps "İ" to "i̇" (i plus a combining dot), so it never equals "i".
+		{name: "Dotted capital I, default folding", keyword: "istanbul", title: "İSTANBUL'DA GO", want: false},
+		{name: "Dotted capital I, Turkish folding", keyword: "istanbul", title: "İSTANBUL'DA GO", fold: turkish, want: true},
+		// Default folding maps "I" to "i", never to the dotless "ı".
+		{name: "Dotless i, default folding", keyword: "ılık", title: "ILIK SU", want: false},
+		{name: "Dotless i, Turkish folding", keyword: "ılık", title: "ILIK SU", fold: turkish, want: true},
+		{name: "Substring, Turkish folding", strategy: StrategySubstring, keyword: "izmir", title: "İZMİRLİ", fold: turkish, want: true},
+		{name: "Regex, Turkish folding", strategy: StrategyRegex, keyword: `^ılık\b`, title: "ILIK SU", fold: turkish, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMatcher(tt.strategy, []string{tt.keyword}, nil, matcherOptions{fold: tt.fold})
+			if err != nil {
+				t.Fatalf("newMatcher returned error: %v", err)
+			}
+			got, ok := m.Match(tt.title)
+			if ok != tt.want {
+				t.Errorf("Match(%q) with keyword %q = %v, %v; want %v", tt.title, tt.keyword, got, ok, tt.want)
+			}
+			if ok && got[0] != tt.keyword {
+				t.Errorf("Match(%q) reported %q, want the keyword as given, %q", tt.title, got[0], tt.keyword)
+			}
+		})
+	}
+}
+
+func TestMatcherStrictAcronyms(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		strategy string
+		keywords []string
+		title    string
+		want     []string
+	}{
+		{name: "Acronym as a word", keywords: []string{"AI"}, title: "AI is here", want: []string{"AI"}},
+		{name: "Acronym inside a word", strategy: StrategySubstring, keywords: []string{"AI"}, title: "Trip to Thailand", want: nil},
+		{name: "Acronym in lowercase", keywords: []string{"AI"}, title: "Ai weiwei's new show", want: nil},
+		{name: "Lowercase keyword matches capitals", keywords: []string{"ml"}, title: "ML in production", want: []string{"ml"}},
+		{name: "Lowercase keyword in mixed case", keywords: []string{"ml"}, title: "Ml notes", want: nil},
+		{name: "Acronym next to punctuation", keywords: []string{"AI"}, title: "Is (AI) overhyped?", want: []string{"AI"}},
+		{name: "Longer keywords stay case-insensitive", keywords: []string{"AI", "rust"}, title: "RUST and AI", want: []string{"AI", "rust"}},
+		{name: "Longer substring keyword still glues", strategy: StrategySubstring, keywords: []string{"rust"}, title: "Trustworthy code", want: []string{"rust"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMatcher(tt.strategy, tt.keywords, nil, matcherOptions{strictAcronyms: true})
+			if err != nil {
+				t.Fatalf("newMatcher returned error: %v", err)
+			}
+			got, ok := m.Match(tt.title)
+			if !reflect.DeepEqual(got, tt.want) || ok != (tt.want != nil) {
+				t.Errorf("Match(%q) = %v, %v; want %v", tt.title, got, ok, tt.want)
+			}
+		})
+	}
+
+	// Without the strict mode, substring matching fires on "Thailand".
+	if _, ok := mustMatcher(t, StrategySubstring, []string{"AI"}).Match("Trip to Thailand"); !ok {
+		t.Error(`Expected substring mode without strict acronyms to match "Thailand"`)
+	}
+}
+
+func TestMatcherIgnoreHyphens(t *testing.T) {
+	t.Parallel()
+	titles := []string{"Real-time collaboration", "Realtime collaboration", "Real time collaboration"}
+	tests := []struct {
+		name     string
+		strategy string
+		keyword  string
+		ignore   bool
+		want     []bool // Whether each of titles matches.
+	}{
+		{name: "Hyphenated keyword", keyword: "real-time", ignore: true, want: []bool{true, true, true}},
+		{name: "Joined keyword", keyword: "realtime", ignore: true, want: []bool{true, true, true}},
+		{name: "Spaced keyword", keyword: "real time", ignore: true, want: []bool{false, false, true}},
+		{name: "Substring strategy", strategy: StrategySubstring, keyword: "real-time", ignore: true, want: []bool{true, true, true}},
+		{name: "Part of a hyphenated title", keyword: "time", ignore: true, want: []bool{true, false, true}},
+		{name: "Hyphens kept by default", keyword: "realtime", want: []bool{false, true, false}},
+		{name: "Parts match by default", keyword: "time", want: []bool{true, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMatcher(tt.strategy, []string{tt.keyword}, nil, matcherOptions{ignoreHyphens: tt.ignore})
+			if err != nil {
+				t.Fatalf("newMatcher returned error: %v", err)
+			}
+			for i, title := range titles {
+				got, ok := m.Match(title)
+				if ok != tt.want[i] {
+					t.Errorf("Match(%q) = %v, want %v", title, ok, tt.want[i])
+				}
+				if ok && !reflect.DeepEqual(got, []string{tt.keyword}) {
+					t.Errorf("Match(%q) reported %v, want [%s]", title, got, tt.keyword)
+				}
+			}
+		})
+	}
+}
+
+func TestMatcherSpans(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		strategy string
+		keywords []string
+		mo       matcherOptions
+		title    string
+		want     [][2]int
+	}{
+		{name: "Boundary", keywords: []string{"go", "rust"}, title: "Go, Rust, and Golang: go!", want: [][2]int{{0, 2}, {4, 8}, {22, 24}}},
+		{name: "Substring", strategy: StrategySubstring, keywords: []string{"go"}, title: "Golang and go", want: [][2]int{{0, 2}, {11, 13}}},
+		{name: "Regex", strategy: StrategyRegex, keywords: []string{`rust(acean)?`}, title: "Rustaceans", want: [][2]int{{0, 9}}},
+		{name: "Overlapping keywords merge", strategy: StrategySubstring, keywords: []string{"go", "golang"}, title: "Golang tips", want: [][2]int{{0, 6}}},
+		// "İ" folds from two bytes to one, so folded offsets have to be mapped back.
+		{name: "Locale folding", keywords: []string{"istanbul"}, mo: matcherOptions{fold: cases.Lower(language.Turkish).String}, title: "İSTANBUL'DA GO", want: [][2]int{{0, 9}}},
+		{name: "Ignore hyphens, hyphenated title", keywords: []string{"realtime"}, mo: matcherOptions{ignoreHyphens: true}, title: "Real-time chat", want: [][2]int{{0, 9}}},
+		{name: "Ignore hyphens, spaced title", keywords: []string{"realtime"}, mo: matcherOptions{ignoreHyphens: true}, title: "Try real time chat", want: [][2]int{{4, 13}}},
+		{name: "Strict acronyms", keywords: []string{"ai", "rust"}, mo: matcherOptions{strictAcronyms: true}, title: "AI in Rust, not Thailand", want: [][2]int{{0, 2}, {6, 10}}},
+		{name: "No match", keywords: []string{"go"}, title: "Rust tips", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMatcher(tt.strategy, tt.keywords, nil, tt.mo)
+			if err != nil {
+				t.Fatalf("newMatcher returned error: %v", err)
+			}
+			if got := m.Spans(tt.title); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Spans(%q) = %v, want %v", tt.title, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMatcherStrategy(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		strategy string
+		want     Matcher
+		wantErr  string
+	}{
+		{strategy: "", want: &boundaryMatcher{}},
+		{strategy: StrategyBoundary, want: &boundaryMatcher{}},
+		{strategy: StrategySubstring, want: &substringMatcher{}},
+		{strategy: StrategyRegex, want: &regexMatcher{}},
+		{strategy: "fuzzy", wantErr: `unknown match strategy "fuzzy"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			got, err := newMatcher(tt.strategy, []string{"go"}, nil, matcherOptions{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newMatcher(%q) error = %v, want %q", tt.strategy, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newMatcher(%q) returned error: %v", tt.strategy, err)
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("newMatcher(%q) = %T, want %T", tt.strategy, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := newMatcher(StrategyRegex, []string{"go("}, nil, matcherOptions{}); err == nil {
+		t.Error("Expected an error for an invalid regex keyword")
+	}
+}
@@ -0,0 +1,136 @@
+package hngrep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+)
+
+// Notifier delivers matched stories somewhere, like a chat channel or a webhook.
+type Notifier interface {
+	Notify(ctx context.Context, stories []Story) error
+}
+
+// Notify sends stories to every notifier, even if earlier ones fail, and returns
+// their errors joined together, or nil if all of them succeeded.
+func Notify(ctx context.Context, notifiers []Notifier, stories []Story) error {
+	var errs []error
+	for i, n := range notifiers {
+		if err := n.Notify(ctx, stories); err != nil {
+			errs = append(errs, fmt.Errorf("notifier %d (%T): %w", i, n, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// defaultCommandConcurrency caps how many CommandNotifier commands run at once
+// when Concurrency isn't set.
+const defaultCommandConcurrency = 4
+
+// CommandNotifier runs a shell command once per story, passing the story in
+// the HNGREP_ID, HNGREP_TITLE, HNGREP_URL, and HNGREP_SCORE environment variables.
+type CommandNotifier struct {
+	Command     string // Run with sh -c, so it may use pipes and $HNGREP_TITLE.
+	Concurrency int    // Commands run at once; 0 means defaultCommandConcurrency.
+
+	// Exec runs command with env added to the environment. Nil runs it with
+	// sh -c, sharing the process's stdout and stderr.
+	Exec func(ctx context.Context, command string, env []string) error
+}
+
+// Notify runs the command for every story, even if some runs fail, and returns
+// the failures, like non-zero exit statuses, joined together.
+func (n *CommandNotifier) Notify(ctx context.Context, stories []Story) error {
+	run := n.Exec
+	if run == nil {
+		run = execShell
+	}
+	limit := n.Concurrency
+	if limit <= 0 {
+		limit = defaultCommandConcurrency
+	}
+
+	errs := make([]error, len(stories))
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, s := range stories {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := run(ctx, n.Command, storyEnv(s)); err != nil {
+				errs[i] = fmt.Errorf("command for story %d: %w", s.ID, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// storyEnv returns the environment variables describing s to a CommandNotifier command.
+func storyEnv(s Story) []string {
+	return []string{
+		"HNGREP_ID=" + strconv.Itoa(s.ID),
+		"HNGREP_TITLE=" + s.Title,
+		"HNGREP_URL=" + s.URL,
+		"HNGREP_SCORE=" + strconv.Itoa(s.Score),
+	}
+}
+
+// execShell runs command with sh -c and env added to the current environment.
+func execShell(ctx context.Context, command string, env []string) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// WebhookNotifier POSTs the matched stories to URL as JSON, rendered by Encode.
+type WebhookNotifier struct {
+	URL        string
+	HTTPClient *http.Client // Nil means http.DefaultClient.
+
+	// Encode renders the request body. Nil means a JSON array of the
+	// stories in the HN API's item format.
+	Encode func(stories []Story) ([]byte, error)
+}
+
+// Notify posts stories in one request, failing on any non-2xx response.
+func (n *WebhookNotifier) Notify(ctx context.Context, stories []Story) error {
+	encode := n.Encode
+	if encode == nil {
+		encode = func(stories []Story) ([]byte, error) { return json.Marshal(stories) }
+	}
+	body, err := encode(stories)
+	if err != nil {
+		return fmt.Errorf("failed to encode stories: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := n.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned %s", n.URL, resp.Status)
+	}
+	return nil
+}
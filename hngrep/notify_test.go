@@ -0,0 +1,108 @@
+package hngrep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCommandNotifier(t *testing.T) {
+	t.Parallel()
+	stories := []Story{
+		{ID: 1, Title: "Go 1.23", URL: "https://go.dev/blog", Score: 42},
+		{ID: 2, Title: "Ask HN: Rust or Go?", Score: 7},
+		{ID: 3, Title: "Zig tips", URL: "https://ziglang.org", Score: 3},
+	}
+
+	var (
+		mu      sync.Mutex
+		envs    [][]string
+		running atomic.Int32
+		peak    atomic.Int32
+	)
+	errExit := errors.New("exit status 1")
+	n := &CommandNotifier{
+		Command:     "notify-me",
+		Concurrency: 2,
+		Exec: func(ctx context.Context, command string, env []string) error {
+			if command != "notify-me" {
+				t.Errorf("Exec got command %q, want %q", command, "notify-me")
+			}
+			now := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if now <= p || peak.CompareAndSwap(p, now) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			envs = append(envs, env)
+			mu.Unlock()
+			if strings.Contains(strings.Join(env, "\n"), "HNGREP_ID=2") {
+				return errExit
+			}
+			return nil
+		},
+	}
+
+	err := n.Notify(context.Background(), stories)
+	if !errors.Is(err, errExit) || !strings.Contains(err.Error(), "command for story 2") {
+		t.Errorf("Notify error = %v, want the exit status of story 2", err)
+	}
+	if p := peak.Load(); p > 2 {
+		t.Errorf("Ran %d commands at once, want at most 2", p)
+	}
+
+	sort.Slice(envs, func(i, j int) bool { return envs[i][0] < envs[j][0] })
+	want := [][]string{
+		{"HNGREP_ID=1", "HNGREP_TITLE=Go 1.23", "HNGREP_URL=https://go.dev/blog", "HNGREP_SCORE=42"},
+		{"HNGREP_ID=2", "HNGREP_TITLE=Ask HN: Rust or Go?", "HNGREP_URL=", "HNGREP_SCORE=7"},
+		{"HNGREP_ID=3", "HNGREP_TITLE=Zig tips", "HNGREP_URL=https://ziglang.org", "HNGREP_SCORE=3"},
+	}
+	if !reflect.DeepEqual(envs, want) {
+		t.Errorf("Command environments = %v, want %v", envs, want)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	t.Parallel()
+	var got []Story
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Webhook got %s with Content-Type %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+		if r.URL.Path == "/down" {
+			http.Error(w, "down", http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	stories := []Story{{ID: 1, Title: "Go 1.23", URL: "https://go.dev/blog", Score: 42}}
+	n := &WebhookNotifier{URL: srv.URL + "/hook", HTTPClient: srv.Client()}
+	if err := n.Notify(context.Background(), stories); err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got, stories) {
+		t.Errorf("Webhook got %+v, want %+v", got, stories)
+	}
+
+	n.URL = srv.URL + "/down"
+	if err := n.Notify(context.Background(), stories); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Notify error = %v, want the 502 status", err)
+	}
+}
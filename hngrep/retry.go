@@ -0,0 +1,130 @@
+package hngrep
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// retryBudget caps the number of retries a whole run may spend, so per-story
+// retries can't multiply into runaway traffic when the API is struggling.
+// It's safe for concurrent use.
+type retryBudget struct {
+	unlimited bool
+	remaining atomic.Int64
+}
+
+// newRetryBudget returns a budget of n retries; n <= 0 means no cap.
+func newRetryBudget(n int) *retryBudget {
+	b := &retryBudget{unlimited: n <= 0}
+	b.remaining.Store(int64(n))
+	return b
+}
+
+// take spends one retry, reporting false once the budget is exhausted.
+func (b *retryBudget) take() bool {
+	if b.unlimited {
+		return true
+	}
+	return b.remaining.Add(-1) >= 0
+}
+
+// DefaultRetryNullDelay is the wait between refetches of a null item with
+// Options.RetryNull; new items usually fill in within seconds.
+const DefaultRetryNullDelay = 2 * time.Second
+
+// nullRetries is how many times Options.RetryNull refetches a null item.
+const nullRetries = 2
+
+// retryNullStory refetches story id, which came back null, up to retries
+// times, delay apart, until it's non-null. It returns nil, nil if the item is
+// still null, and gives up on the first fetch error.
+func retryNullStory(ctx context.Context, client Client, id, retries int, delay time.Duration, logger *log.Logger) (*Story, error) {
+	for attempt := 1; attempt <= retries; attempt++ {
+		logger.Printf("   Story %d is null, refetching (%d/%d).", id, attempt, retries)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+		s, err := client.GetStory(id)
+		if err != nil || s != nil {
+			return s, err
+		}
+	}
+	return nil, nil
+}
+
+// retryAfter returns the Retry-After wait err carries as a *StatusError, or 0.
+func retryAfter(err error) time.Duration {
+	var serr *StatusError
+	if errors.As(err, &serr) {
+		return serr.RetryAfter
+	}
+	return 0
+}
+
+// retryDelay returns how long to wait before retrying after err: delay,
+// unless respectRetryAfter is set and err asked for a wait of its own.
+func retryDelay(err error, delay time.Duration, respectRetryAfter bool) time.Duration {
+	if d := retryAfter(err); respectRetryAfter && d > 0 {
+		return d
+	}
+	return delay
+}
+
+// transient reports whether err might clear up on a retry: a 5xx or 429
+// response, a body cut short, or a network failure. Missing items, oversized
+// bodies, and undecodable JSON fail the same way every time.
+func transient(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, ErrServerError) || errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrIncompleteResponse) || errors.As(err, &netErr)
+}
+
+// getStoryWithRetry fetches story id, retrying transient failures up to retries
+// times, delay apart, while budget allows. With respectRetryAfter, a response
+// carrying Retry-After is retried after that wait instead. It returns the last
+// error if every attempt fails, or ctx's error if ctx is done while waiting to retry.
+func getStoryWithRetry(ctx context.Context, client Client, id, retries int, delay time.Duration, respectRetryAfter bool, budget *retryBudget, logger *log.Logger) (*Story, error) {
+	s, err := client.GetStory(id)
+	for attempt := 1; transient(err) && attempt <= retries; attempt++ {
+		if !budget.take() {
+			logger.Printf("   Retry budget spent, not retrying story %d.", id)
+			break
+		}
+		wait := retryDelay(err, delay, respectRetryAfter)
+		logger.Printf("   Retrying story %d (%d/%d) in %s after error: %v", id, attempt, retries, wait, err)
+		if serr := sleep(ctx, wait); serr != nil {
+			return nil, serr
+		}
+		s, err = client.GetStory(id)
+	}
+	return s, err
+}
+
+// getTopStoriesWithRetry fetches the feed, retrying up to retries times, delay
+// apart, while budget allows, but only when it fails with
+// ErrIncompleteResponse or, with respectRetryAfter, a response carrying
+// Retry-After, retried after that wait; other feed errors are unlikely to
+// clear up on their own.
+func getTopStoriesWithRetry(ctx context.Context, client Client, retries int, delay time.Duration, respectRetryAfter bool, budget *retryBudget, logger *log.Logger) ([]int, error) {
+	ids, err := client.GetTopStories()
+	retryable := func(err error) bool {
+		return errors.Is(err, ErrIncompleteResponse) || (respectRetryAfter && retryAfter(err) > 0)
+	}
+	for attempt := 1; retryable(err) && attempt <= retries; attempt++ {
+		if !budget.take() {
+			logger.Println("Retry budget spent, not retrying the feed.")
+			break
+		}
+		wait := retryDelay(err, delay, respectRetryAfter)
+		logger.Printf("Retrying the feed (%d/%d) in %s after error: %v", attempt, retries, wait, err)
+		if serr := sleep(ctx, wait); serr != nil {
+			return nil, serr
+		}
+		ids, err = client.GetTopStories()
+	}
+	return ids, err
+}
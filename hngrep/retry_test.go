@@ -0,0 +1,276 @@
+package hngrep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGrepRetryBudget(t *testing.T) {
+	t.Parallel()
+	errDown := &StatusError{URL: "https://hn.example/item", StatusCode: http.StatusServiceUnavailable}
+	fakeClient := &FakeClient{
+		TopStories: []int{1, 2, 3},
+		Errors:     map[int]error{1: errDown, 2: errDown, 3: errDown},
+	}
+
+	opts := Options{
+		MaxStories:  10,
+		Keywords:    []string{"go"},
+		Retries:     3,
+		RetryBudget: 4,
+	}
+	res, err := Grep(context.Background(), opts, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+
+	// Story 1 spends 3 retries, story 2 the last one, and story 3 gets none.
+	if want := []int{1, 1, 1, 1, 2, 2, 3}; !reflect.DeepEqual(fakeClient.Fetched, want) {
+		t.Errorf("Fetched IDs = %v, want %v", fakeClient.Fetched, want)
+	}
+	if res.Failed != 3 {
+		t.Errorf("Failed = %d, want 3", res.Failed)
+	}
+}
+
+func TestGrepRetryOnlyTransient(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		err         error
+		wantFetched []int
+	}{
+		{name: "Not found", err: &StatusError{URL: "https://hn.example/item", StatusCode: http.StatusNotFound}, wantFetched: []int{1}},
+		{name: "Body too large", err: ErrBodyTooLarge, wantFetched: []int{1}},
+		{name: "Rate limited", err: &StatusError{URL: "https://hn.example/item", StatusCode: http.StatusTooManyRequests}, wantFetched: []int{1, 1, 1}},
+		{name: "Cut short", err: fmt.Errorf("%w: %w", ErrIncompleteResponse, io.ErrUnexpectedEOF), wantFetched: []int{1, 1, 1}},
+		{name: "Network failure", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, wantFetched: []int{1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fakeClient := &FakeClient{TopStories: []int{1}, Errors: map[int]error{1: tt.err}}
+			if _, err := Grep(context.Background(), Options{MaxStories: 1, Keywords: []string{"go"}, Retries: 2}, fakeClient); err != nil {
+				t.Fatalf("Grep(...) returned error: %v", err)
+			}
+			if !reflect.DeepEqual(fakeClient.Fetched, tt.wantFetched) {
+				t.Errorf("Fetched IDs = %v, want %v", fakeClient.Fetched, tt.wantFetched)
+			}
+		})
+	}
+}
+
+func TestGrepRetrySucceeds(t *testing.T) {
+	t.Parallel()
+	fakeClient := &flakyClient{
+		FakeClient: FakeClient{
+			TopStories: []int{1},
+			Stories:    map[int]Story{1: {ID: 1, Title: "Go is cool"}},
+		},
+		failures: 2,
+	}
+
+	res, err := Grep(context.Background(), Options{MaxStories: 10, Keywords: []string{"go"}, Retries: 2}, fakeClient)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	if len(res.Stories) != 1 || res.Failed != 0 {
+		t.Errorf("Got %d stories and %d failures, want 1 and 0", len(res.Stories), res.Failed)
+	}
+}
+
+func TestGrepRetriesIncompleteFeed(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		retries int
+		wantErr bool
+	}{
+		{name: "Retried", retries: 1},
+		{name: "Retries disabled", retries: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			srv := truncatingServer(t, `[1, 2, 3, 4, 5, 6, 7, 8]`, 1)
+			client := &HNClient{TopStoriesURL: srv.URL, HTTPClient: srv.Client()}
+			// MaxStories 0 fetches no items, so only the feed is requested.
+			res, err := Grep(context.Background(), Options{Keywords: []string{"go"}, Retries: tt.retries}, client)
+			if tt.wantErr {
+				if !errors.Is(err, ErrIncompleteResponse) {
+					t.Errorf("Grep(...) error = %v, want %v", err, ErrIncompleteResponse)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Grep(...) returned error: %v", err)
+			}
+			if res.Available != 8 {
+				t.Errorf("Available = %d, want 8", res.Available)
+			}
+		})
+	}
+}
+
+// lateClient is a FakeClient whose stories come back null for their first
+// Late[id] fetches, like items the API hasn't filled in yet.
+type lateClient struct {
+	*FakeClient
+	Late map[int]int
+}
+
+func (c *lateClient) GetStory(id int) (*Story, error) {
+	if c.Late[id] > 0 {
+		c.Late[id]--
+		c.Fetched = append(c.Fetched, id)
+		return nil, nil
+	}
+	return c.FakeClient.GetStory(id)
+}
+
+func TestGrepRetryNull(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		retryNull   bool
+		late        int
+		wantIDs     []int
+		wantFetched []int
+	}{
+		{name: "Filled in on retry", retryNull: true, late: 1, wantIDs: []int{1}, wantFetched: []int{1, 1}},
+		{name: "Still null after retries", retryNull: true, late: 5, wantIDs: nil, wantFetched: []int{1, 1, 1}},
+		{name: "Retries off", late: 1, wantIDs: nil, wantFetched: []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			client := &lateClient{
+				FakeClient: &FakeClient{
+					TopStories: []int{1},
+					Stories:    map[int]Story{1: {ID: 1, Title: "Go 1.24 is out"}},
+				},
+				Late: map[int]int{1: tt.late},
+			}
+			opts := Options{MaxStories: 1, Keywords: []string{"go"}, RetryNull: tt.retryNull, RetryNullDelay: time.Millisecond}
+			res, err := Grep(context.Background(), opts, client)
+			if err != nil {
+				t.Fatalf("Grep(...) returned error: %v", err)
+			}
+			if got := storyIDs(res.Stories); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("Matched IDs = %v, want %v", got, tt.wantIDs)
+			}
+			if !reflect.DeepEqual(client.Fetched, tt.wantFetched) {
+				t.Errorf("Fetched IDs = %v, want %v", client.Fetched, tt.wantFetched)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetConcurrent(t *testing.T) {
+	t.Parallel()
+	budget := newRetryBudget(100)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if budget.take() {
+					mu.Lock()
+					granted++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if granted != 100 {
+		t.Errorf("Granted %d retries, want exactly the budget of 100", granted)
+	}
+	if !newRetryBudget(0).take() {
+		t.Error("Expected a zero budget to be unlimited")
+	}
+}
+
+// flakyClient fails the first failures GetStory calls, then behaves like FakeClient.
+type flakyClient struct {
+	FakeClient
+	failures int
+}
+
+func (c *flakyClient) GetStory(id int) (*Story, error) {
+	if c.failures > 0 {
+		c.failures--
+		return nil, &StatusError{URL: "https://hn.example/item", StatusCode: http.StatusBadGateway}
+	}
+	return c.FakeClient.GetStory(id)
+}
+
+func TestGrepRespectRetryAfter(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var itemRequests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/topstories.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `[1]`)
+	})
+	mux.HandleFunc("/item/1.json", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		itemRequests++
+		first := itemRequests == 1
+		mu.Unlock()
+		if first {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = io.WriteString(w, `{"id": 1, "title": "Go is cool"}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := &HNClient{
+		TopStoriesURL:   srv.URL + "/topstories.json",
+		ItemURLTemplate: srv.URL + "/item/%d.json",
+		HTTPClient:      srv.Client(),
+	}
+	opts := Options{
+		MaxStories:        1,
+		Keywords:          []string{"go"},
+		Delay:             10 * time.Millisecond,
+		Retries:           1,
+		RespectRetryAfter: true,
+	}
+	start := time.Now()
+	res, err := Grep(context.Background(), opts, client)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	if len(res.Stories) != 1 {
+		t.Fatalf("Grep(...) matched %d stories, want 1 after the retry", len(res.Stories))
+	}
+	if elapsed < time.Second {
+		t.Errorf("Grep(...) took %v, want at least the 1s Retry-After", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if itemRequests != 2 {
+		t.Errorf("Item requested %d times, want 2", itemRequests)
+	}
+}
@@ -0,0 +1,65 @@
+package hngrep
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rule scopes name the story field a Rule's keywords are matched against.
+const (
+	ScopeTitle = "title" // Matched with Options.MatchStrategy, like Keywords.
+	ScopeURL   = "url"   // Matched as case-insensitive substrings anywhere in the URL.
+)
+
+// Rule matches a story when any of its keywords matches the field named by Scope.
+// Rules are independent of Keywords: a story matches if any rule matches.
+type Rule struct {
+	Scope    string
+	Keywords []string
+}
+
+// ParseRule parses a "scope:keyword,keyword" rule, like "title:security,cve".
+func ParseRule(s string) (Rule, error) {
+	scope, list, ok := strings.Cut(s, ":")
+	if !ok {
+		return Rule{}, fmt.Errorf("rule must be in 'scope:keyword,...' form, got %q", s)
+	}
+
+	r := Rule{Scope: strings.ToLower(strings.TrimSpace(scope))}
+	if r.Scope != ScopeTitle && r.Scope != ScopeURL {
+		return Rule{}, fmt.Errorf("rule scope must be %s or %s, got %q", ScopeTitle, ScopeURL, scope)
+	}
+	for _, kw := range strings.Split(list, ",") {
+		if kw = strings.TrimSpace(kw); kw != "" {
+			r.Keywords = append(r.Keywords, kw)
+		}
+	}
+	if len(r.Keywords) == 0 {
+		return Rule{}, fmt.Errorf("rule %q has no keywords", s)
+	}
+	return r, nil
+}
+
+// compiledRule is a Rule with its Matcher built once per run.
+type compiledRule struct {
+	scope   string
+	matcher Matcher
+}
+
+// compileRules builds a matcher per rule. Title rules use strategy and mo, the
+// same as Keywords; URL rules always match plain substrings.
+func compileRules(rules []Rule, strategy string, mo matcherOptions) ([]compiledRule, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, r := range rules {
+		s, ropts := strategy, mo
+		if r.Scope == ScopeURL {
+			s, ropts = StrategySubstring, matcherOptions{}
+		}
+		m, err := newMatcher(s, r.Keywords, nil, ropts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s rule: %w", r.Scope, err)
+		}
+		compiled = append(compiled, compiledRule{scope: r.Scope, matcher: m})
+	}
+	return compiled, nil
+}
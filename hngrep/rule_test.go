@@ -0,0 +1,73 @@
+package hngrep
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      string
+		want    Rule
+		wantErr string
+	}{
+		{in: "title:security,cve", want: Rule{Scope: ScopeTitle, Keywords: []string{"security", "cve"}}},
+		{in: " URL : github.com , ", want: Rule{Scope: ScopeURL, Keywords: []string{"github.com"}}},
+		{in: "security", wantErr: "rule must be in 'scope:keyword,...' form"},
+		{in: "body:security", wantErr: "rule scope must be title or url"},
+		{in: "title: , ", wantErr: "has no keywords"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseRule(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseRule(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRule(%q) returned error: %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRule(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesRules(t *testing.T) {
+	t.Parallel()
+	rules, err := compileRules([]Rule{
+		{Scope: ScopeTitle, Keywords: []string{"security"}},
+		{Scope: ScopeURL, Keywords: []string{"cve"}},
+	}, StrategyBoundary, matcherOptions{})
+	if err != nil {
+		t.Fatalf("compileRules returned error: %v", err)
+	}
+	opts := matchOptions{rules: rules}
+
+	tests := []struct {
+		name string
+		s    Story
+		want bool
+	}{
+		{name: "Title rule", s: Story{Title: "Security at scale", URL: "https://example.com"}, want: true},
+		{name: "URL rule", s: Story{Title: "A bad week", URL: "https://nvd.nist.gov/vuln/CVE-2024-3094"}, want: true},
+		{name: "Both rules", s: Story{Title: "Security advisory", URL: "https://example.com/cve"}, want: true},
+		{name: "URL keyword only in title", s: Story{Title: "Reading a CVE", URL: "https://example.com"}, want: false},
+		{name: "Title keyword only in URL", s: Story{Title: "A bad week", URL: "https://example.com/security"}, want: false},
+		{name: "No rule", s: Story{Title: "Go tips", URL: "https://go.dev"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matches(&tt.s, opts).matched(); got != tt.want {
+				t.Errorf("matches(%+v) = %v, want %v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
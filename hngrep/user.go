@@ -0,0 +1,54 @@
+package hngrep
+
+import "log"
+
+// karmaLookup looks up and caches authors' karma for Options.MinAuthorKarma,
+// so each author costs at most one request per run.
+type karmaLookup struct {
+	client    UserClient
+	remaining int // Lookups left; negative means no cap.
+	karma     map[string]int
+	failed    map[string]bool
+	logger    *log.Logger
+}
+
+// newKarmaLookup returns a karmaLookup allowing max lookups; max <= 0 means no cap.
+func newKarmaLookup(client UserClient, max int, logger *log.Logger) *karmaLookup {
+	if max <= 0 {
+		max = -1
+	}
+	return &karmaLookup{
+		client:    client,
+		remaining: max,
+		karma:     make(map[string]int),
+		failed:    make(map[string]bool),
+		logger:    logger,
+	}
+}
+
+// of returns user's karma, reporting false if it's unknown: user is empty, as
+// for deleted items, the lookup failed, or the lookup cap is spent.
+func (k *karmaLookup) of(user string) (int, bool) {
+	if karma, ok := k.karma[user]; ok {
+		return karma, true
+	}
+	if user == "" || k.failed[user] {
+		return 0, false
+	}
+	if k.remaining == 0 {
+		k.logger.Printf("   User lookup cap spent, not looking up %s.", user)
+		return 0, false
+	}
+	if k.remaining > 0 {
+		k.remaining--
+	}
+
+	karma, err := k.client.GetUserKarma(user)
+	if err != nil {
+		k.logger.Printf("   Failed to look up user %s: %v", user, err)
+		k.failed[user] = true
+		return 0, false
+	}
+	k.karma[user] = karma
+	return karma, true
+}
@@ -0,0 +1,108 @@
+package hngrep
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// karmaClient is a FakeClient that also looks up user karma.
+type karmaClient struct {
+	*FakeClient
+	Karma   map[string]int
+	Lookups []string // Users passed to GetUserKarma, in call order.
+}
+
+func (c *karmaClient) GetUserKarma(user string) (int, error) {
+	c.Lookups = append(c.Lookups, user)
+	karma, ok := c.Karma[user]
+	if !ok {
+		return 0, errors.New("user not found")
+	}
+	return karma, nil
+}
+
+func TestGrepMinAuthorKarma(t *testing.T) {
+	t.Parallel()
+	newClient := func() *karmaClient {
+		return &karmaClient{
+			FakeClient: &FakeClient{
+				TopStories: []int{1, 2, 3, 4, 5, 6},
+				Stories: map[int]Story{
+					1: {ID: 1, Title: "Go tips", By: "veteran"},
+					2: {ID: 2, Title: "Go tricks", By: "newbie"},
+					3: {ID: 3, Title: "Go notes", By: "veteran"},
+					4: {ID: 4, Title: "Go news", By: "ghost"},
+					5: {ID: 5, Title: "Rust tips", By: "newbie"},
+					6: {ID: 6, Title: "Go again", By: "regular"},
+				},
+			},
+			Karma: map[string]int{"veteran": 5000, "newbie": 3, "regular": 800},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		maxLookups  int
+		wantIDs     []int
+		wantLookups []string
+	}{
+		{
+			name:        "Each author looked up once",
+			wantIDs:     []int{1, 3, 6},
+			wantLookups: []string{"veteran", "newbie", "ghost", "regular"},
+		},
+		{
+			name:        "Lookup cap",
+			maxLookups:  2,
+			wantIDs:     []int{1, 3},
+			wantLookups: []string{"veteran", "newbie"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			client := newClient()
+			opts := Options{MaxStories: 6, Keywords: []string{"go"}, MinAuthorKarma: 100, MaxUserLookups: tt.maxLookups}
+			res, err := Grep(context.Background(), opts, client)
+			if err != nil {
+				t.Fatalf("Grep(...) returned error: %v", err)
+			}
+			if got := storyIDs(res.Stories); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("Matched IDs = %v, want %v", got, tt.wantIDs)
+			}
+			if !reflect.DeepEqual(client.Lookups, tt.wantLookups) {
+				t.Errorf("Looked up %v, want %v", client.Lookups, tt.wantLookups)
+			}
+			if got := res.Outcomes[1].Reason; got != RejectKarma {
+				t.Errorf("Reason for story 2 = %q, want %q", got, RejectKarma)
+			}
+		})
+	}
+}
+
+func TestGrepMinAuthorKarmaNeedsUserClient(t *testing.T) {
+	t.Parallel()
+	opts := Options{MaxStories: 1, Keywords: []string{"go"}, MinAuthorKarma: 100}
+	if _, err := Grep(context.Background(), opts, &FakeClient{}); err == nil {
+		t.Error("Grep(...) with a client that can't look up users returned nil error")
+	}
+	if _, err := Grep(context.Background(), opts, &FixedIDsClient{Client: &FakeClient{}}); err == nil {
+		t.Error("Grep(...) with fixed IDs over a client that can't look up users returned nil error")
+	}
+
+	// Fixed IDs over a client that can look up users keep the filter working.
+	client := &karmaClient{
+		FakeClient: &FakeClient{Stories: map[int]Story{1: {ID: 1, Title: "Go tips", By: "newbie"}}},
+		Karma:      map[string]int{"newbie": 3},
+	}
+	res, err := Grep(context.Background(), opts, &FixedIDsClient{Client: client, IDs: []int{1}})
+	if err != nil {
+		t.Fatalf("Grep(...) returned error: %v", err)
+	}
+	if len(res.Stories) != 0 || !reflect.DeepEqual(client.Lookups, []string{"newbie"}) {
+		t.Errorf("Matched %v after looking up %v, want no matches after looking up [newbie]", storyIDs(res.Stories), client.Lookups)
+	}
+}
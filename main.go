@@ -1,265 +1,2449 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"embed"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/rednafi/hn-alert/hngrep"
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v3"
+)
+
+// Build information, injected at build time via
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
 )
 
-// story represents a Hacker News story.
-// Fields must be exported so the JSON package can unmarshal them.
-type story struct {
-	ID       int    `json:"id"`
-	Title    string `json:"title"`
-	URL      string `json:"url"`
-	StoryURL string // Not in JSON; we'll populate it manually.
+// versionString describes the running build for -version.
+func versionString() string {
+	return fmt.Sprintf("hn-grep %s (commit %s, built %s)", version, commit, date)
+}
+
+// templatesFS holds the built-in HTML templates selectable via -template-style.
+//
+//go:embed template.html template_compact.html
+var templatesFS embed.FS
+
+// templateStyles maps each -template-style value to its embedded template file.
+var templateStyles = map[string]string{
+	"full":    "template.html",
+	"compact": "template_compact.html",
+}
+
+// translations maps each -lang value, other than English, to the translations
+// of the built-in templates' labels. The English labels are the keys.
+var translations = map[string]map[string]string{
+	"de": {
+		"Match stories by keywords or domain in Hacker News' Top %d": "Stories nach Stichwörtern oder Domain in den Top %d von Hacker News filtern",
+		"Keywords":                             "Stichwörter",
+		"Domain":                               "Domain",
+		"Matched %d stories":                   "%d Stories gefunden",
+		"%d points":                            "%d Punkte",
+		"Matched":                              "Treffer",
+		"Dead link":                            "Toter Link",
+		"Origin":                               "Quelle",
+		"Discussion":                           "Diskussion",
+		"Generated %s from %d fetched stories": "Erstellt am %s aus %d abgerufenen Stories",
+		"Made with ❤️ by":                      "Mit ❤️ gemacht von",
+		"Source available on":                  "Quellcode auf",
+		"Rejected stories (%d)":                "Abgelehnte Stories (%d)",
+		"no keyword hit":                       "kein Stichwort-Treffer",
+		"below minimum relevance":              "unter der Mindestrelevanz",
+		"wrong domain":                         "falsche Domain",
+		"author karma too low":                 "Karma des Autors zu niedrig",
+		"below score percentile":               "unter dem Punkte-Perzentil",
+		"link post":                            "Link-Beitrag",
+		"self post":                            "Textbeitrag",
+		"rising too slowly":                    "steigt zu langsam",
+		"too old":                              "zu alt",
+		"duplicate title":                      "doppelter Titel",
+	},
+	"es": {
+		"Match stories by keywords or domain in Hacker News' Top %d": "Historias filtradas por palabras clave o dominio en el Top %d de Hacker News",
+		"Keywords":                             "Palabras clave",
+		"Domain":                               "Dominio",
+		"Matched %d stories":                   "%d historias coincidentes",
+		"%d points":                            "%d puntos",
+		"Matched":                              "Coincidencias",
+		"Dead link":                            "Enlace roto",
+		"Origin":                               "Origen",
+		"Discussion":                           "Discusión",
+		"Generated %s from %d fetched stories": "Generado el %s a partir de %d historias obtenidas",
+		"Made with ❤️ by":                      "Hecho con ❤️ por",
+		"Source available on":                  "Código fuente en",
+		"Rejected stories (%d)":                "Historias descartadas (%d)",
+		"no keyword hit":                       "ninguna palabra clave coincide",
+		"below minimum relevance":              "por debajo de la relevancia mínima",
+		"wrong domain":                         "dominio incorrecto",
+		"author karma too low":                 "karma del autor demasiado bajo",
+		"below score percentile":               "por debajo del percentil de puntos",
+		"link post":                            "publicación con enlace",
+		"self post":                            "publicación de texto",
+		"rising too slowly":                    "sube demasiado despacio",
+		"too old":                              "demasiado antigua",
+		"duplicate title":                      "título duplicado",
+	},
+	"fr": {
+		"Match stories by keywords or domain in Hacker News' Top %d": "Articles filtrés par mots-clés ou domaine dans le Top %d de Hacker News",
+		"Keywords":                             "Mots-clés",
+		"Domain":                               "Domaine",
+		"Matched %d stories":                   "%d articles correspondants",
+		"%d points":                            "%d points",
+		"Matched":                              "Correspondances",
+		"Dead link":                            "Lien mort",
+		"Origin":                               "Source",
+		"Discussion":                           "Discussion",
+		"Generated %s from %d fetched stories": "Généré le %s à partir de %d articles récupérés",
+		"Made with ❤️ by":                      "Fait avec ❤️ par",
+		"Source available on":                  "Code source sur",
+		"Rejected stories (%d)":                "Articles rejetés (%d)",
+		"no keyword hit":                       "aucun mot-clé trouvé",
+		"below minimum relevance":              "sous la pertinence minimale",
+		"wrong domain":                         "mauvais domaine",
+		"author karma too low":                 "karma de l'auteur trop faible",
+		"below score percentile":               "sous le centile de points",
+		"link post":                            "publication avec lien",
+		"self post":                            "publication texte",
+		"rising too slowly":                    "monte trop lentement",
+		"too old":                              "trop ancien",
+		"duplicate title":                      "titre en double",
+	},
+}
+
+// langFuncs returns the template functions for lang: t, which translates an
+// English label and formats it with any arguments, and lang, which returns the
+// language in effect. Unknown languages and missing labels fall back to English.
+func langFuncs(lang string) template.FuncMap {
+	lang, _, _ = strings.Cut(strings.ToLower(lang), "-")
+	table, ok := translations[lang]
+	if !ok {
+		lang = "en"
+	}
+	return template.FuncMap{
+		"t": func(label string, args ...any) string {
+			if translated, ok := table[label]; ok {
+				label = translated
+			}
+			if len(args) == 0 {
+				return label
+			}
+			return fmt.Sprintf(label, args...)
+		},
+		"lang": func() string { return lang },
+	}
+}
+
+// highlightFuncs returns the highlight template function, which renders a
+// story's title with each matched keyword, up to max of them if max is above
+// 0, wrapped in <mark>. The rest of the title is HTML-escaped.
+func highlightFuncs(max int) template.FuncMap {
+	return template.FuncMap{
+		"highlight": func(s hngrep.Story) template.HTML {
+			spans := s.TitleSpans
+			if max > 0 && len(spans) > max {
+				spans = spans[:max]
+			}
+			var b strings.Builder
+			last := 0
+			for _, span := range spans {
+				b.WriteString(template.HTMLEscapeString(s.Title[last:span[0]]))
+				b.WriteString("<mark>")
+				b.WriteString(template.HTMLEscapeString(s.Title[span[0]:span[1]]))
+				b.WriteString("</mark>")
+				last = span[1]
+			}
+			b.WriteString(template.HTMLEscapeString(s.Title[last:]))
+			return template.HTML(b.String())
+		},
+	}
+}
+
+// timeFuncs returns the localtime template function, which converts Unix
+// seconds, like a story's Time, to a time.Time in loc for formatting.
+func timeFuncs(loc *time.Location) template.FuncMap {
+	return template.FuncMap{
+		"localtime": func(unix int64) time.Time {
+			return time.Unix(unix, 0).In(loc)
+		},
+	}
+}
+
+// templateFuncs are available to every template, built-in or custom. They accept
+// zero values, so templates can reference optional story fields that a run
+// didn't populate without failing to render.
+var templateFuncs = template.FuncMap{
+	// join joins elems with sep; a nil slice yields "".
+	"join": func(elems []string, sep string) string {
+		return strings.Join(elems, sep)
+	},
+	// default returns value, or fallback if value is nil, zero, or empty.
+	"default": func(fallback, value any) any {
+		if value == nil {
+			return fallback
+		}
+		v := reflect.ValueOf(value)
+		switch {
+		case v.IsZero():
+			return fallback
+		case (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0:
+			return fallback
+		}
+		return value
+	},
+}
+
+// outputFormats maps each -output-format value to its default output file.
+// HTML defaults to -html-file instead.
+var outputFormats = map[string]string{
+	"html":     "",
+	"json":     "stories.json",
+	"markdown": "stories.md",
+	"opml":     "stories.opml",
+	"tsv":      "stories.tsv",
+	"csv":      "stories.csv",
+	"yaml":     "stories.yaml",
+}
+
+// outputFormatNames returns the -output-format values, sorted.
+func outputFormatNames() []string {
+	return slices.Sorted(maps.Keys(outputFormats))
+}
+
+// outputFileDefaults describes the default output file of every format, for
+// the -output-file usage.
+func outputFileDefaults() string {
+	var defaults []string
+	for _, name := range outputFormatNames() {
+		file := outputFormats[name]
+		if file == "" {
+			file = "-html-file"
+		}
+		defaults = append(defaults, file+" for "+name)
+	}
+	return joinWords(defaults, "and")
+}
+
+// joinWords joins words into a list like "a, b, and c", with conj before the
+// last one.
+func joinWords(words []string, conj string) string {
+	switch len(words) {
+	case 0:
+		return ""
+	case 1:
+		return words[0]
+	case 2:
+		return words[0] + " " + conj + " " + words[1]
+	}
+	return strings.Join(words[:len(words)-1], ", ") + ", " + conj + " " + words[len(words)-1]
+}
+
+// markdownStyles are the -markdown-style values: a plain bulleted list of links,
+// or a GitHub task list to tick off, e.g. in an issue.
+var markdownStyles = map[string]string{
+	"list":     "- ",
+	"tasklist": "- [ ] ",
+}
+
+// jsonSchemaVersion is the version of the JSON envelope written by
+// -output-format=json. Bump it whenever the envelope's shape changes.
+const jsonSchemaVersion = 1
+
+// jsonOutput is the envelope written by -output-format=json.
+type jsonOutput struct {
+	Version     int         `json:"version"`
+	GeneratedAt time.Time   `json:"generated_at"`
+	Keywords    []string    `json:"keywords"`
+	Domain      string      `json:"domain,omitempty"`
+	Stories     []jsonStory `json:"stories"`
+}
+
+// jsonStory is a matched story in the JSON envelope and the YAML output.
+type jsonStory struct {
+	ID              int       `json:"id" yaml:"id"`
+	Title           string    `json:"title" yaml:"title"`
+	URL             string    `json:"url" yaml:"url"`
+	Domain          string    `json:"domain,omitempty" yaml:"domain,omitempty"`
+	HNURL           string    `json:"hn_url" yaml:"hn_url"`
+	Score           int       `json:"score" yaml:"score"`
+	MatchedKeywords []string  `json:"matched_keywords" yaml:"matched_keywords"`
+	Relevance       int       `json:"relevance" yaml:"relevance"`
+	Snippet         string    `json:"snippet,omitempty" yaml:"snippet,omitempty"`
+	Summary         string    `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Link            *jsonLink `json:"link,omitempty" yaml:"link,omitempty"`
+}
+
+// jsonLink is the result of checking a story's link with -check-links.
+type jsonLink struct {
+	Alive      bool `json:"alive" yaml:"alive"`
+	StatusCode int  `json:"status_code,omitempty" yaml:"status_code,omitempty"`
+}
+
+// cliFlags holds all command-line flag values.
+type cliFlags struct {
+	maxStories int
+	keywords   []string
+	domain     string
+	htmlFile   string
+	delay      time.Duration // Minimum delay between requests.
+	maxDelay   time.Duration // Maximum delay between requests; equals delay when not jittering.
+	synonyms   map[string][]string
+
+	templateStyle string
+	templateFile  string
+	templateDir   string // Directory of *.html templates that can include each other.
+	templateName  string // Template in templateDir to execute.
+	lang          string // Language of the built-in templates' labels.
+
+	maxHighlights int // Most keyword spans the highlight template function marks per title; 0 means all.
+
+	minify bool // Collapse whitespace and strip comments in the HTML output.
+
+	timezone *time.Location // Zone displayed timestamps are in; nil means UTC.
+
+	outputFormat  string
+	mergeFile     string   // Earlier JSON output merged into -output-format=json's.
+	csvFields     []string // Columns of -output-format=csv, in order.
+	outputFile    string   // Output path for non-HTML formats.
+	markdownStyle string
+
+	sample     bool
+	sampleRate float64
+	seed       int64
+
+	feed    string
+	idsFile string
+	sinceID int
+
+	since         time.Duration // Skip stories submitted longer ago than this.
+	stopWhenStale bool
+
+	rankStart int
+	rankEnd   int
+
+	reportFile  string
+	archiveFile string // JSON Lines log that newly matched stories are appended to.
+
+	dumpPatternFile string // File the compiled keyword pattern is written to before the run.
+
+	// profiles, when set, stand in for keywords, domain, and the output file:
+	// each is matched against the same fetched stories and written on its own.
+	profiles []keywordProfile
+
+	includeRejected bool // List rejected stories and why in the HTML output.
+
+	maxConsecutiveFailures int
+	retries                int
+	retryBudget            int
+	retryNull              bool
+	retryAfterRespect      bool
+	slowThreshold          time.Duration
+
+	domainExact bool
+	domainRegex *regexp.Regexp
+	domainMode  string
+
+	matchStrategy  string
+	locale         string
+	strictAcronyms bool
+	ignoreHyphens  bool
+	rules          []hngrep.Rule
+
+	weights      map[string]int // Explicit keyword weights, keyed by lowercased keyword.
+	minRelevance int
+	minVelocity  float64
+
+	scorePercentile float64
+
+	minAuthorKarma int
+	maxUserLookups int
+
+	fetchArticleTitles bool
+	checkLinks         bool
+	matchURLText       bool
+	matchField         string
+	normalizeTitle     bool
+	cleanURLs          bool
+
+	flattenComments bool
+	commentDepth    int
+	commentLimit    int
+	matchContext    bool
+
+	maxCommentFetches int // Comments -flatten-comments fetches across the run; 0 means no cap.
+
+	dedupeTitles bool
+	sortBy       string
+
+	failOnEmpty bool
+
+	selfOnly  bool
+	linksOnly bool
+
+	color     bool
+	logPrefix string
+
+	compactLogInterval int // Stories between progress summaries with -compact-log; 0 logs every story.
+	verbose            bool
+
+	headers      http.Header // Extra headers sent with every HN API request.
+	maxBodyBytes int64
+	strictJSON   bool
+	caFile       string // PEM bundle of extra CAs to trust for HN API requests.
+	insecureTLS  bool
+	fieldMap     map[string]string // Item field to the key it's read from.
+
+	itemURLTemplate string // Item endpoint, with a %d for the item ID.
+
+	// notifiers receive the matched stories after the outputs are written, like
+	// the -on-match command.
+	notifiers []hngrep.Notifier
+
+	// warnings describe flag values parseFlags worked around, like duplicate
+	// keywords, for main to log before the run.
+	warnings []string
+
+	showVersion bool
+	explain     bool
+	count       bool
+	interactive bool // Browse the matched stories in the terminal after the run.
+
+	cpuProfile string
+	memProfile string
+}
+
+// repeatedFlag collects the values of a flag that may be given several times,
+// like -header or -rule. parseFlags validates the values.
+type repeatedFlag []string
+
+// String returns the collected values, one per line.
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, "\n")
+}
+
+// Set appends value.
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// location returns the zone displayed timestamps are in, UTC unless -timezone
+// says otherwise.
+func (c *cliFlags) location() *time.Location {
+	if c.timezone == nil {
+		return time.UTC
+	}
+	return c.timezone
+}
+
+// options maps the flags that drive fetching and filtering onto hngrep.Options.
+func (c *cliFlags) options(logger *log.Logger) hngrep.Options {
+	return hngrep.Options{
+		MaxStories: c.maxStories,
+		Keywords:   c.keywords,
+		Domain:     c.domain,
+		Delay:      c.delay,
+		MaxDelay:   c.maxDelay,
+
+		Synonyms:     c.synonyms,
+		Weights:      c.weights,
+		MinRelevance: c.minRelevance,
+		MinVelocity:  c.minVelocity,
+		DomainExact:  c.domainExact,
+		DomainRegex:  c.domainRegex,
+		DomainMode:   c.domainMode,
+
+		ScorePercentile: c.scorePercentile,
+
+		MinAuthorKarma: c.minAuthorKarma,
+		MaxUserLookups: c.maxUserLookups,
+
+		MatchStrategy: c.matchStrategy,
+		Locale:        c.locale,
+
+		StrictAcronyms: c.strictAcronyms,
+		IgnoreHyphens:  c.ignoreHyphens,
+		Rules:          c.rules,
+
+		SinceID: c.sinceID,
+
+		MaxAge:        c.since,
+		StopWhenStale: c.stopWhenStale,
+
+		RankStart: c.rankStart,
+		RankEnd:   c.rankEnd,
+
+		Sample:     c.sample,
+		SampleRate: c.sampleRate,
+		Seed:       c.seed,
+
+		MaxConsecutiveFailures: c.maxConsecutiveFailures,
+		Retries:                c.retries,
+		RetryBudget:            c.retryBudget,
+		RetryNull:              c.retryNull,
+		RespectRetryAfter:      c.retryAfterRespect,
+		SlowThreshold:          c.slowThreshold,
+		FetchArticleTitles:     c.fetchArticleTitles,
+		CheckLinks:             c.checkLinks,
+		MatchURLText:           c.matchURLText,
+		MatchField:             c.matchField,
+		NormalizeTitle:         c.normalizeTitle,
+		CleanURLs:              c.cleanURLs,
+		FlattenComments:        c.flattenComments,
+		CommentDepth:           c.commentDepth,
+		CommentLimit:           c.commentLimit,
+		MaxCommentFetches:      c.maxCommentFetches,
+		MatchContext:           c.matchContext,
+		DedupeTitles:           c.dedupeTitles,
+		Sort:                   c.sortBy,
+		FailOnEmpty:            c.failOnEmpty,
+		Color:                  c.color,
+		CompactLogInterval:     c.compactLogInterval,
+		Verbose:                c.verbose,
+
+		SelfOnly:  c.selfOnly,
+		LinksOnly: c.linksOnly,
+
+		Logger: logger,
+	}
+}
+
+// HTMLData represents the data passed to the HTML template.
+type HTMLData struct {
+	Keywords   string
+	Domain     string
+	Stories    []hngrep.Story
+	MaxStories int
+
+	GeneratedAt  time.Time // When the run that produced the page finished.
+	TotalFetched int       // Stories fetched and evaluated, matched or not.
+
+	// Rejected lists the evaluated stories that didn't match, with -include-rejected.
+	Rejected []rejectedStory
+}
+
+// rejectedStory is a story that didn't match, with the reason it was rejected.
+type rejectedStory struct {
+	Title    string
+	StoryURL string
+	Reason   string // One of the hngrep.Reject constants.
+}
+
+// parseFlags parses and validates command-line flags, returning a fully populated *cliFlags.
+func parseFlags() (*cliFlags, error) {
+	maxStories := flag.Int("max-stories", 100, "Maximum number of stories to fetch")
+	keywords := flag.String("keywords", "", "Comma-separated list of keywords to filter stories, each optionally weighted as keyword:weight")
+	domain := flag.String("domain", "", "Domain to filter stories by URL, (default '')")
+	htmlFile := flag.String("html-file", "index.html", "Output HTML file for matched stories")
+	delay := flag.Duration("delay", 100*time.Millisecond, "Delay between requests; shortcut for equal -min-delay and -max-delay")
+	minDelay := flag.Duration("min-delay", 0, "Minimum random delay between requests (default -delay)")
+	maxDelay := flag.Duration("max-delay", 0, "Maximum random delay between requests (default -min-delay)")
+	synonymsFile := flag.String("synonyms-file", "", "JSON or CSV file mapping canonical keywords to synonyms")
+	profilesFile := flag.String("profiles-file", "", "JSON file of keyword profiles, each with its own keywords, domain, and output file, all matched against one fetch of the feed")
+	templateStyle := flag.String("template-style", "full", "Built-in HTML template to use: full or compact")
+	timezone := flag.String("timezone", "UTC", "IANA time zone, like Europe/Berlin, that displayed timestamps are shown in")
+	lang := flag.String("lang", "en", "Language of the HTML labels: en, de, es, or fr; unknown languages fall back to English")
+	outputFormat := flag.String("output-format", "html", "Output format: "+joinWords(outputFormatNames(), "or"))
+	outputFile := flag.String("output-file", "", "Output file; defaults to "+outputFileDefaults())
+	markdownStyle := flag.String("markdown-style", "list", "Markdown output style: list or tasklist")
+	csvFields := flag.String("csv-fields", defaultCSVFields, "Comma-separated columns of -output-format=csv, in order, among "+strings.Join(slices.Sorted(maps.Keys(storyColumns)), ", "))
+	mergeFile := flag.String("merge-file", "", "Optional earlier -output-format=json file whose stories are merged into this run's, newer copies winning, for a rolling digest")
+	templateFile := flag.String("template", "", "Path to a custom HTML template; overrides -template-style")
+	templateDir := flag.String("template-dir", "", "Directory of custom *.html templates, which can include each other with {{template \"name\"}}; overrides -template-style")
+	templateName := flag.String("template-name", "index.html", "Template in -template-dir to render the page with")
+	minify := flag.Bool("minify", false, "Minify the HTML output, collapsing whitespace and stripping comments, for emailing or embedding")
+	sample := flag.Bool("sample", false, "Randomly sample max-stories IDs from the feed instead of taking the top ones")
+	sampleRate := flag.Float64("sample-rate", 1, "Fraction of the stories, above 0 and up to 1, to fetch, skipping the rest at random, for cheap match rate estimates")
+	seed := flag.Int64("seed", 0, "Seed for random sampling; 0 picks a random seed")
+	onMatch := flag.String("on-match", "", "Shell command to run for each matched story, which gets HNGREP_ID, HNGREP_TITLE, HNGREP_URL, and HNGREP_SCORE in its environment")
+	notifiersFile := flag.String("notifiers-file", "", "JSON file of notifiers, each a command or a webhook, that get the matched stories after every run")
+	archiveFile := flag.String("archive-file", "", "Optional JSON Lines file that every newly matched story is appended to, with the time it was seen")
+	includeRejected := flag.Bool("include-rejected", false, "List the stories that didn't match, and why, in a collapsible section of the HTML output")
+	reportFile := flag.String("report-file", "", "Optional JSON file recording the match outcome of every fetched story")
+	dumpPatternFile := flag.String("dump-pattern-file", "", "Optional file to write the compiled keyword pattern and its flags to before fetching")
+	minVelocity := flag.Float64("min-velocity", 0, "Skip stories gaining fewer points per hour since submission; 0 disables the filter")
+	scorePercentile := flag.Float64("score-percentile", 0, "Keep only matches scoring at or above this percentile, 0 to 100, of all fetched stories; 0 disables the filter")
+	minAuthorKarma := flag.Int("min-author-karma", 0, "Drop matches whose submitter has less karma, looked up from the user endpoint beside -item-url-template; 0 disables the filter")
+	maxUserLookups := flag.Int("max-user-lookups", 100, "Most distinct authors -min-author-karma looks up per run; 0 disables the cap")
+	minRelevance := flag.Int("min-relevance", 0, "Minimum sum of matched keyword weights for a keyword match to count")
+	fetchArticleTitles := flag.Bool("fetch-article-titles", false, "Also match keywords against the <title> of each story's linked page")
+	matchURLText := flag.Bool("match-url-text", false, "Also match keywords against each story's decoded URL path, like '/2024/rust-performance/'")
+	matchField := flag.String("match-field", "", "Also match keywords against the item JSON field at this dot-separated path, like 'metadata.tags', for mirrors with richer payloads")
+	normalizeTitle := flag.Bool("normalize-title", false, "Match against NFKC-normalized titles with whitespace collapsed, so fullwidth and other look-alike characters match plain keywords")
+	cleanURLs := flag.Bool("clean-urls", false, "Strip tracking parameters like utm_*, fbclid, gclid, and ref from matched stories' URLs")
+	flattenComments := flag.Bool("flatten-comments", false, "Also match keywords against each story's comments; costs extra fetches per story")
+	commentDepth := flag.Int("comment-depth", 3, "How many levels of replies -flatten-comments walks below each story")
+	commentLimit := flag.Int("comment-limit", 50, "Maximum number of comments -flatten-comments fetches per story")
+	maxCommentFetches := flag.Int("max-comment-fetches", 200, "Maximum number of comments -flatten-comments fetches across the run; 0 disables the cap")
+	matchContext := flag.Bool("match-context", false, "Add a one-line summary to each match from its text or, costing one fetch, its top comment")
+	checkLinks := flag.Bool("check-links", false, "Check each matched story's link with a HEAD request and flag dead ones")
+	sortBy := flag.String("sort", hngrep.SortFeed, "Order of matched stories: feed, or matchcount for most keywords matched first")
+	dedupeTitles := flag.Bool("dedupe-titles", false, "Drop matched stories whose normalized title duplicates another match, keeping the one with the most points")
+	domainExact := flag.Bool("domain-exact", false, "Require the story's URL host to equal -domain exactly instead of containing it")
+	domainMode := flag.String("domain-mode", hngrep.DomainModeOr, "How the domain filters combine with keywords: or matches either, and requires both, only ignores keywords")
+	domainRegex := flag.String("domain-regex", "", "Regex matched against each story's URL host, like '\\.edu$'")
+	failOnEmpty := flag.Bool("fail-on-empty", false, "Exit with an error, without writing output, when the feed returns no stories")
+	selfOnly := flag.Bool("self-only", false, "Keep only self posts, like Ask HN, that have no external URL")
+	linksOnly := flag.Bool("links-only", false, "Keep only link submissions that have an external URL")
+	logPrefix := flag.String("log-prefix", "", "Prefix for every log line, to tell apart several runs logging to one stream")
+	compactLog := flag.Bool("compact-log", false, "Replace the per-story log lines with a 'processed 120/250, matched 8' summary every -compact-log-interval stories")
+	compactLogEvery := flag.Int("compact-log-interval", 25, "Stories between progress summaries with -compact-log")
+	verbose := flag.Bool("verbose", false, "Also log debug lines, like stories skipped for having no title or URL")
+	maxHighlights := flag.Int("max-highlights", 0, "Most matched keywords to wrap in <mark> per title in the HTML output; 0 highlights them all")
+	color := flag.Bool("color", false, "Highlight matched keywords in the console output with ANSI bold and underline")
+	matchStrategy := flag.String("match-strategy", hngrep.StrategyBoundary, "How keywords match titles: "+strings.Join(hngrep.Strategies, ", "))
+	locale := flag.String("locale", "", "BCP 47 language tag, like 'tr', whose case rules fold keywords and titles before matching")
+	ignoreHyphens := flag.Bool("ignore-hyphens", false, "Match hyphenated, joined, and spaced words alike, so 'real-time' and 'realtime' both match 'Real-time', 'Realtime', and 'Real time'")
+	strictAcronyms := flag.Bool("strict-acronyms", false, "Match keywords of up to two characters, like AI or Go, only as written or in capitals and only as standalone words")
+	substring := flag.Bool("substring", false, "Match keywords as case-insensitive substrings instead of whole words, so 'go' also matches 'golang'; shortcut for -match-strategy=substring")
+	maxConsecutiveFailures := flag.Int("max-consecutive-failures", 10, "Abort after this many story fetches fail in a row; 0 disables the check")
+	retries := flag.Int("retries", 0, "Times to retry a story fetch that failed transiently, like a 5xx or a dropped connection, or a feed response that was cut short; 0 disables retries")
+	retryAfterRespect := flag.Bool("retry-after-respect", false, "When a failed response, like a 429, carries Retry-After, wait that long before retrying instead of -delay")
+	retryNull := flag.Bool("retry-null", false, "Refetch items that come back null, as just-posted ones briefly can, twice before skipping them")
+	retryBudget := flag.Int("retry-budget", 50, "Total story fetch retries allowed across the run; 0 disables the cap")
+	slowThreshold := flag.Duration("slow-threshold", 0, "Warn about story fetches slower than this, like 2s; 0 disables the warning")
+	feed := flag.String("feed", "top", "Feed to list story IDs from: top, new, best, ask, show, job, or updates")
+	idsFile := flag.String("ids-file", "", "File with a JSON or newline-separated list of item IDs to fetch instead of the top stories; '-' reads stdin")
+	rankStart := flag.Int("rank-start", 0, "First feed rank to process, 1-based; 0 starts at the top")
+	rankEnd := flag.Int("rank-end", 0, "Last feed rank to process, inclusive; 0 runs to the end of the feed, and a rank past it is an error")
+	sinceID := flag.Int("since-id", 0, "Only fetch stories with IDs greater than this one; 0 disables the check")
+	since := flag.Duration("since", 0, "Skip stories submitted longer ago than this, like 24h; 0 disables the filter")
+	stopWhenStale := flag.Bool("stop-when-stale", false, "Stop fetching at the first story older than -since, as every later one in the time-ordered new feed is older too")
+	fieldMap := flag.String("field-map", "", "Comma-separated field=key pairs reading item fields from other keys, like 'title=headline,url=link', for HN mirrors")
+	caFile := flag.String("ca-file", "", "PEM file of CA certificates to trust, on top of the system ones, for HN API requests")
+	insecureTLS := flag.Bool("insecure-skip-verify", false, "Skip TLS certificate verification for HN API requests; for testing only")
+	strictJSON := flag.Bool("strict-json", false, "Fail on HN item fields outside the documented schema, to spot API changes")
+	itemURLTemplate := flag.String("item-url-template", hngrep.DefaultItemURLTemplate, "URL items are fetched from, with a single %d for the item ID, for caches and proxies of the HN API")
+	maxBodyBytes := flag.Int64("max-body-bytes", hngrep.DefaultMaxBodyBytes, "Largest HN API response body to read, in bytes")
+	var rawHeaders, rawRules repeatedFlag
+	flag.Var(&rawRules, "rule", "Scoped keyword rule like 'title:security,cve' or 'url:github.com'; a story matching any rule matches; repeatable")
+	flag.Var(&rawHeaders, "header", "Extra 'Key: Value' header to send with every HN API request; repeatable")
+	showVersion := flag.Bool("version", false, "Print version information and exit")
+	count := flag.Bool("count", false, "Print only the number of matched stories; no other output, files, or notifications")
+	interactive := flag.Bool("interactive", false, "After the run, browse the matched stories in the terminal: arrow keys or j and k move, Enter opens the HN discussion, q quits")
+	explain := flag.Bool("explain", false, "Print how the keywords are matched under the other matching flags, with sample matches, and exit")
+	cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile of the run to this file")
+	memProfile := flag.String("memprofile", "", "Write a heap profile, taken after the run, to this file")
+
+	// Flags override the environment, which overrides the rc files; both
+	// only change the defaults.
+	if err := applyRCFiles(flag.CommandLine, rcPaths()); err != nil {
+		return nil, err
+	}
+	if err := applyEnv(flag.CommandLine, lookupEnv); err != nil {
+		return nil, err
+	}
+	flag.Parse()
+
+	// -version needs no other flags, so skip validating them.
+	if *showVersion {
+		return &cliFlags{showVersion: true}, nil
+	}
+
+	if *maxStories <= 0 {
+		return nil, fmt.Errorf("max-stories must be a positive integer")
+	}
+	if *profilesFile != "" {
+		// Each profile brings its own keywords, domain, and output, and the
+		// single-search outputs would be overwritten by every profile.
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"keywords", strings.TrimSpace(*keywords) != ""},
+			{"domain", *domain != ""},
+			{"report-file", *reportFile != ""},
+			{"dump-pattern-file", *dumpPatternFile != ""},
+			{"count", *count},
+			{"explain", *explain},
+		} {
+			if f.set {
+				return nil, fmt.Errorf("profiles-file can't be combined with -%s", f.name)
+			}
+		}
+	} else if strings.TrimSpace(*keywords) == "" && len(rawRules) == 0 && *domainMode != hngrep.DomainModeOnly {
+		return nil, fmt.Errorf("keywords must be provided")
+	}
+	// -delay sets both bounds unless -min-delay or -max-delay override them.
+	if *minDelay == 0 {
+		*minDelay = *delay
+	}
+	if *maxDelay == 0 {
+		*maxDelay = *minDelay
+	}
+	if *minDelay < 100*time.Millisecond {
+		return nil, fmt.Errorf("delay must be greater than or equal to 100ms")
+	}
+	if *maxDelay < *minDelay {
+		return nil, fmt.Errorf("max-delay must be greater than or equal to min-delay")
+	}
+	if _, ok := templateStyles[*templateStyle]; !ok {
+		return nil, fmt.Errorf("template-style must be one of full or compact")
+	}
+	location, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return nil, fmt.Errorf("timezone must be an IANA time zone name: %w", err)
+	}
+	if *sampleRate <= 0 || *sampleRate > 1 {
+		return nil, fmt.Errorf("sample-rate must be above 0 and at most 1")
+	}
+	if *maxHighlights < 0 {
+		return nil, fmt.Errorf("max-highlights must not be negative")
+	}
+	if *templateFile != "" && *templateDir != "" {
+		return nil, fmt.Errorf("template and template-dir can't be used together")
+	}
+	defaultOutput, ok := outputFormats[*outputFormat]
+	if !ok {
+		return nil, fmt.Errorf("output-format must be one of %s", joinWords(outputFormatNames(), "or"))
+	}
+	if _, ok := markdownStyles[*markdownStyle]; !ok {
+		return nil, fmt.Errorf("markdown-style must be one of list or tasklist")
+	}
+	columns, err := parseCSVFields(*csvFields)
+	if err != nil {
+		return nil, err
+	}
+	if *mergeFile != "" && *outputFormat != "json" {
+		return nil, fmt.Errorf("merge-file needs output-format json")
+	}
+	if *outputFormat == "html" {
+		// -output-file is just another name for -html-file here.
+		if *outputFile != "" {
+			*htmlFile = *outputFile
+		}
+		*outputFile = ""
+	} else if *outputFile == "" {
+		*outputFile = defaultOutput
+	}
+	if *sortBy != hngrep.SortFeed && *sortBy != hngrep.SortMatchCount {
+		return nil, fmt.Errorf("sort must be one of feed or matchcount")
+	}
+	if *selfOnly && *linksOnly {
+		return nil, fmt.Errorf("self-only and links-only are mutually exclusive")
+	}
+	if *substring {
+		if *matchStrategy != hngrep.StrategyBoundary && *matchStrategy != hngrep.StrategySubstring {
+			return nil, fmt.Errorf("substring conflicts with match-strategy %q", *matchStrategy)
+		}
+		*matchStrategy = hngrep.StrategySubstring
+	}
+	if !slices.Contains(hngrep.Strategies, *matchStrategy) {
+		return nil, fmt.Errorf("match-strategy must be one of %s", strings.Join(hngrep.Strategies, ", "))
+	}
+	compactLogInterval := 0
+	if *compactLog {
+		if *compactLogEvery < 1 {
+			return nil, fmt.Errorf("compact-log-interval must be at least 1")
+		}
+		compactLogInterval = *compactLogEvery
+	}
+	if *retries < 0 {
+		return nil, fmt.Errorf("retries must not be negative")
+	}
+	if *retryBudget < 0 {
+		return nil, fmt.Errorf("retry-budget must not be negative")
+	}
+	if *scorePercentile < 0 || *scorePercentile > 100 {
+		return nil, fmt.Errorf("score-percentile must be between 0 and 100")
+	}
+	if *minAuthorKarma < 0 {
+		return nil, fmt.Errorf("min-author-karma must not be negative")
+	}
+	if _, err := hngrep.UserURLTemplateFor(*itemURLTemplate); *minAuthorKarma > 0 && err != nil {
+		return nil, fmt.Errorf("min-author-karma looks users up on the -item-url-template API: %w", err)
+	}
+	if *maxUserLookups < 0 {
+		return nil, fmt.Errorf("max-user-lookups must not be negative")
+	}
+	if *minVelocity < 0 {
+		return nil, fmt.Errorf("min-velocity must not be negative")
+	}
+	if *matchField != "" && slices.Contains(strings.Split(*matchField, "."), "") {
+		return nil, fmt.Errorf("match-field must be a dot-separated path of field names, like metadata.tags")
+	}
+	if *commentDepth < 1 {
+		return nil, fmt.Errorf("comment-depth must be at least 1")
+	}
+	if *maxCommentFetches < 0 {
+		return nil, fmt.Errorf("max-comment-fetches must not be negative")
+	}
+	if *commentLimit < 1 {
+		return nil, fmt.Errorf("comment-limit must be at least 1")
+	}
+	if *slowThreshold < 0 {
+		return nil, fmt.Errorf("slow-threshold must not be negative")
+	}
+	if _, ok := hngrep.Feeds[*feed]; !ok {
+		return nil, fmt.Errorf("feed must be one of top, new, best, ask, show, job, or updates")
+	}
+	if err := hngrep.CheckItemURLTemplate(*itemURLTemplate); err != nil {
+		return nil, fmt.Errorf("item-url-template is invalid: %w", err)
+	}
+	if *maxBodyBytes <= 0 {
+		return nil, fmt.Errorf("max-body-bytes must be a positive integer")
+	}
+	if *rankStart < 0 || *rankEnd < 0 {
+		return nil, fmt.Errorf("rank-start and rank-end must not be negative")
+	}
+	if *rankEnd > 0 && *rankStart > *rankEnd {
+		return nil, fmt.Errorf("rank-start must be less than or equal to rank-end")
+	}
+	if *sinceID < 0 {
+		return nil, fmt.Errorf("since-id must not be negative")
+	}
+	if *since < 0 {
+		return nil, fmt.Errorf("since must not be negative")
+	}
+	if *stopWhenStale && (*since == 0 || *feed != "new") {
+		return nil, fmt.Errorf("stop-when-stale needs since and feed new, the only time-ordered feed")
+	}
+	if *locale != "" {
+		if _, err := language.Parse(*locale); err != nil {
+			return nil, fmt.Errorf("locale must be a valid BCP 47 language tag: %w", err)
+		}
+	}
+	if *maxConsecutiveFailures < 0 {
+		return nil, fmt.Errorf("max-consecutive-failures must not be negative")
+	}
+
+	var domainPattern *regexp.Regexp
+	switch *domainMode {
+	case hngrep.DomainModeOr, hngrep.DomainModeAnd, hngrep.DomainModeOnly:
+	default:
+		return nil, fmt.Errorf("domain-mode must be one of or, and, or only")
+	}
+	if *domainMode != hngrep.DomainModeOr && *domain == "" && *domainRegex == "" {
+		return nil, fmt.Errorf("domain-mode %s needs -domain or -domain-regex", *domainMode)
+	}
+	if *domainRegex != "" {
+		var err error
+		domainPattern, err = regexp.Compile(*domainRegex)
+		if err != nil {
+			return nil, fmt.Errorf("domain-regex must be a valid regular expression: %w", err)
+		}
+	}
+
+	var rules []hngrep.Rule
+	for _, raw := range rawRules {
+		r, err := hngrep.ParseRule(raw)
+		if err != nil {
+			return nil, err
+		}
+		if *matchStrategy == hngrep.StrategyRegex && r.Scope == hngrep.ScopeTitle {
+			for _, kw := range r.Keywords {
+				if _, err := regexp.Compile(kw); err != nil {
+					return nil, fmt.Errorf("rule keyword %q must be a valid regular expression: %w", kw, err)
+				}
+			}
+		}
+		rules = append(rules, r)
+	}
+
+	var fields map[string]string
+	if *fieldMap != "" {
+		var err error
+		if fields, err = hngrep.ParseFieldMap(*fieldMap); err != nil {
+			return nil, err
+		}
+	}
+
+	var headers http.Header
+	for _, raw := range rawHeaders {
+		key, value, err := parseHeader(raw)
+		if err != nil {
+			return nil, err
+		}
+		if headers == nil {
+			headers = make(http.Header)
+		}
+		headers.Add(key, value)
+	}
+
+	cleanedKeywords, weights, warnings, err := parseKeywords(strings.Split(*keywords, ","), *matchStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []keywordProfile
+	if *profilesFile != "" {
+		if profiles, err = loadProfiles(*profilesFile, *matchStrategy); err != nil {
+			return nil, fmt.Errorf("failed to load profiles file: %w", err)
+		}
+	}
+
+	var synonyms map[string][]string
+	if *synonymsFile != "" {
+		var err error
+		synonyms, err = loadSynonyms(*synonymsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load synonyms file: %w", err)
+		}
+	}
+
+	var notifiers []hngrep.Notifier
+	if strings.TrimSpace(*onMatch) != "" {
+		notifiers = append(notifiers, &hngrep.CommandNotifier{Command: *onMatch})
+	}
+	if *notifiersFile != "" {
+		configured, err := loadNotifiers(*notifiersFile, webhookBody(cleanedKeywords, *domain, location))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load notifiers file: %w", err)
+		}
+		notifiers = append(notifiers, configured...)
+	}
+
+	return &cliFlags{
+		maxStories: *maxStories,
+		keywords:   cleanedKeywords,
+		domain:     *domain,
+		htmlFile:   *htmlFile,
+		delay:      *minDelay,
+		maxDelay:   *maxDelay,
+		synonyms:   synonyms,
+
+		templateStyle: *templateStyle,
+		templateFile:  *templateFile,
+		templateDir:   *templateDir,
+		templateName:  *templateName,
+		lang:          *lang,
+
+		maxHighlights: *maxHighlights,
+
+		minify: *minify,
+
+		timezone: location,
+
+		outputFormat:  *outputFormat,
+		outputFile:    *outputFile,
+		mergeFile:     *mergeFile,
+		csvFields:     columns,
+		markdownStyle: *markdownStyle,
+
+		sample:     *sample,
+		sampleRate: *sampleRate,
+		seed:       *seed,
+
+		feed:    *feed,
+		idsFile: *idsFile,
+		sinceID: *sinceID,
+
+		since:         *since,
+		stopWhenStale: *stopWhenStale,
+
+		rankStart: *rankStart,
+		rankEnd:   *rankEnd,
+
+		reportFile:  *reportFile,
+		archiveFile: *archiveFile,
+
+		dumpPatternFile: *dumpPatternFile,
+
+		profiles: profiles,
+
+		includeRejected: *includeRejected,
+
+		maxConsecutiveFailures: *maxConsecutiveFailures,
+		retries:                *retries,
+		retryBudget:            *retryBudget,
+		retryNull:              *retryNull,
+		retryAfterRespect:      *retryAfterRespect,
+		slowThreshold:          *slowThreshold,
+
+		domainExact: *domainExact,
+		domainRegex: domainPattern,
+		domainMode:  *domainMode,
+
+		matchStrategy:  *matchStrategy,
+		locale:         *locale,
+		strictAcronyms: *strictAcronyms,
+		ignoreHyphens:  *ignoreHyphens,
+		rules:          rules,
+
+		weights:      weights,
+		minRelevance: *minRelevance,
+		minVelocity:  *minVelocity,
+
+		scorePercentile: *scorePercentile,
+
+		minAuthorKarma: *minAuthorKarma,
+		maxUserLookups: *maxUserLookups,
+
+		fetchArticleTitles: *fetchArticleTitles,
+		checkLinks:         *checkLinks,
+		matchURLText:       *matchURLText,
+		matchField:         *matchField,
+		normalizeTitle:     *normalizeTitle,
+		cleanURLs:          *cleanURLs,
+
+		flattenComments: *flattenComments,
+		commentDepth:    *commentDepth,
+		commentLimit:    *commentLimit,
+		matchContext:    *matchContext,
+
+		maxCommentFetches: *maxCommentFetches,
+
+		dedupeTitles: *dedupeTitles,
+		sortBy:       *sortBy,
+
+		failOnEmpty: *failOnEmpty,
+
+		selfOnly:  *selfOnly,
+		linksOnly: *linksOnly,
+
+		color:     *color,
+		logPrefix: *logPrefix,
+
+		compactLogInterval: compactLogInterval,
+		verbose:            *verbose,
+
+		headers:      headers,
+		maxBodyBytes: *maxBodyBytes,
+		strictJSON:   *strictJSON,
+		caFile:       *caFile,
+		insecureTLS:  *insecureTLS,
+		fieldMap:     fields,
+
+		itemURLTemplate: *itemURLTemplate,
+
+		notifiers: notifiers,
+
+		warnings: warnings,
+
+		explain:     *explain,
+		count:       *count,
+		interactive: *interactive,
+
+		cpuProfile: *cpuProfile,
+		memProfile: *memProfile,
+	}, nil
+}
+
+// rcFileName is the file that applyRCFiles reads flag defaults from.
+const rcFileName = ".hngreprc"
+
+// rcPaths returns the rc files parseFlags loads. It's a variable so tests can
+// point it away from the developer's own rc files.
+var rcPaths = defaultRCPaths
+
+// defaultRCPaths returns the rc files to load, lowest precedence first: the one
+// in the home directory, then the one in the working directory, which is the
+// same file when run from home and then loaded only once.
+func defaultRCPaths() []string {
+	var paths []string
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, rcFileName))
+	}
+	return uniquePaths(append(paths, rcFileName))
+}
+
+// uniquePaths returns paths without the ones that resolve to the same absolute
+// path as an earlier one, keeping their order.
+func uniquePaths(paths []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(paths))
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		if seen[abs] {
+			continue
+		}
+		seen[abs] = true
+		unique = append(unique, path)
+	}
+	return unique
+}
+
+// applyRCFiles sets flags in fs from each existing file in paths, in order, so
+// later files win. Each line is a flag name and value like "keywords=go,rust";
+// a leading dash is optional, and blank lines and lines starting with # are
+// skipped. Missing files are ignored.
+func applyRCFiles(fs *flag.FlagSet, paths []string) error {
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %q: %w", path, err)
+		}
+
+		for i, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			name, value, ok := strings.Cut(strings.TrimLeft(line, "-"), "=")
+			if !ok {
+				// A bare name turns a boolean flag on, as it does on the command line.
+				value = "true"
+			}
+			if err := fs.Set(strings.TrimSpace(name), strings.TrimSpace(value)); err != nil {
+				return fmt.Errorf("%s:%d: %w", path, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envPrefix starts the environment variables that applyEnv reads flags from.
+const envPrefix = "HNGREP_"
+
+// lookupEnv is how parseFlags reads the environment. It's a variable so tests
+// can keep the developer's own environment out.
+var lookupEnv = os.LookupEnv
+
+// envName returns the environment variable for the flag name, like
+// HNGREP_MAX_STORIES for max-stories.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets each flag in fs whose environment variable, as named by
+// envName, is set according to lookup. A repeatable flag takes a single value
+// this way.
+func applyEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := lookup(envName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if serr := fs.Set(f.Name, value); serr != nil {
+			err = fmt.Errorf("%s: %w", envName(f.Name), serr)
+		}
+	})
+	return err
+}
+
+// parseKeywords parses raw keyword entries, each optionally weighted as
+// keyword:weight, into the keywords to match, in order and without
+// case-insensitive repeats, and their explicit weights, keyed by lowercased
+// keyword. The warnings name repeats and, for the substring strategy,
+// keywords that overlap.
+func parseKeywords(raw []string, strategy string) ([]string, map[string]int, []string, error) {
+	cleaned := make([]string, 0, len(raw))
+	var weights map[string]int
+	var warnings []string
+	firstSpelling := make(map[string]string) // Lowercased keyword to how it was first written.
+	for _, kw := range raw {
+		kw, weight, hasWeight, err := parseWeightedKeyword(kw)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if kw == "" {
+			continue
+		}
+		if strategy == hngrep.StrategyRegex {
+			if _, err := regexp.Compile(kw); err != nil {
+				return nil, nil, nil, fmt.Errorf("keyword %q must be a valid regular expression: %w", kw, err)
+			}
+		}
+		// Keywords match case-insensitively, so a repeat only inflates the
+		// pattern; its weight, if any, still applies.
+		if first, ok := firstSpelling[strings.ToLower(kw)]; ok {
+			if first == kw {
+				warnings = append(warnings, fmt.Sprintf("keyword %q is listed more than once; ignoring the duplicate", kw))
+			} else {
+				warnings = append(warnings, fmt.Sprintf("keyword %q duplicates %q, as keywords ignore case; ignoring it", kw, first))
+			}
+		} else {
+			firstSpelling[strings.ToLower(kw)] = kw
+			cleaned = append(cleaned, kw)
+		}
+		if hasWeight {
+			if weights == nil {
+				weights = make(map[string]int)
+			}
+			weights[strings.ToLower(kw)] = weight
+		}
+	}
+
+	if strategy == hngrep.StrategySubstring {
+		warnings = append(warnings, overlappingKeywords(cleaned)...)
+	}
+	return cleaned, weights, warnings, nil
+}
+
+// parseHeader splits a "Key: Value" header into its trimmed key and value.
+// The key must be a valid HTTP header name; the value may be empty.
+func parseHeader(raw string) (string, string, error) {
+	key, value, ok := strings.Cut(raw, ":")
+	key = strings.TrimSpace(key)
+	if !ok || !httpguts.ValidHeaderFieldName(key) {
+		return "", "", fmt.Errorf("header must be in 'Key: Value' form, got %q", raw)
+	}
+	value = strings.TrimSpace(value)
+	if !httpguts.ValidHeaderFieldValue(value) {
+		return "", "", fmt.Errorf("header %q has an invalid value", key)
+	}
+	return key, value, nil
+}
+
+// parseWeightedKeyword splits a "keyword:weight" entry into its trimmed keyword and
+// weight. Entries whose text after the last colon isn't a number, like "std::move",
+// are taken as plain keywords without an explicit weight.
+func parseWeightedKeyword(entry string) (string, int, bool, error) {
+	entry = strings.TrimSpace(entry)
+	idx := strings.LastIndex(entry, ":")
+	if idx < 0 {
+		return entry, 0, false, nil
+	}
+
+	weight, err := strconv.Atoi(strings.TrimSpace(entry[idx+1:]))
+	if err != nil {
+		return entry, 0, false, nil
+	}
+	if weight <= 0 {
+		return "", 0, false, fmt.Errorf("keyword weight must be a positive integer, got %q", entry)
+	}
+	return strings.TrimSpace(entry[:idx]), weight, true, nil
+}
+
+// overlappingKeywords warns about each keyword that contains another, since
+// under substring matching every title it matches also matches the shorter one.
+func overlappingKeywords(keywords []string) []string {
+	var warnings []string
+	for _, long := range keywords {
+		for _, short := range keywords {
+			if len(short) < len(long) && strings.Contains(strings.ToLower(long), strings.ToLower(short)) {
+				warnings = append(warnings, fmt.Sprintf("keyword %q contains %q, so under substring matching every title it matches also matches %q", long, short, short))
+			}
+		}
+	}
+	return warnings
+}
+
+// loadSynonyms reads a mapping of canonical keyword to synonyms from path.
+// Files ending in .csv hold one group per line, canonical keyword first;
+// anything else is parsed as a JSON object of canonical keyword to a list of synonyms.
+func loadSynonyms(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q: %w", path, err)
+	}
+
+	synonyms := make(map[string][]string)
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing CSV %q: %w", path, err)
+		}
+		for _, rec := range records {
+			canonical := strings.TrimSpace(rec[0])
+			if canonical == "" {
+				continue
+			}
+			synonyms[canonical] = append(synonyms[canonical], rec[1:]...)
+		}
+		return synonyms, nil
+	}
+
+	if err := json.Unmarshal(data, &synonyms); err != nil {
+		return nil, fmt.Errorf("error unmarshalling synonyms %q: %w", path, err)
+	}
+	return synonyms, nil
+}
+
+// keywordProfile is one entry of -profiles-file: a keyword set with its own
+// domain filter and output file.
+type keywordProfile struct {
+	name       string
+	keywords   []string
+	weights    map[string]int // Explicit keyword weights, keyed by lowercased keyword.
+	domain     string
+	outputFile string
+}
+
+// loadProfiles reads the keyword profiles in the JSON file at path, a list of
+// objects like {"name": "go", "keywords": ["go", "golang:2"], "domain": "",
+// "output_file": "go.html"}. Keywords are parsed as with -keywords for
+// strategy; each profile needs keywords or a domain, and names and output
+// files must be unique.
+func loadProfiles(path, strategy string) ([]keywordProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q: %w", path, err)
+	}
+	var raw []struct {
+		Name       string   `json:"name"`
+		Keywords   []string `json:"keywords"`
+		Domain     string   `json:"domain"`
+		OutputFile string   `json:"output_file"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error unmarshalling profiles %q: %w", path, err)
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("%q has no profiles", path)
+	}
+
+	profiles := make([]keywordProfile, 0, len(raw))
+	names := make(map[string]bool)
+	outputs := make(map[string]bool)
+	for i, r := range raw {
+		if r.Name == "" {
+			return nil, fmt.Errorf("profile %d has no name", i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("profile %q is defined more than once", r.Name)
+		}
+		names[r.Name] = true
+		if r.OutputFile == "" {
+			return nil, fmt.Errorf("profile %q has no output_file", r.Name)
+		}
+		if outputs[filepath.Clean(r.OutputFile)] {
+			return nil, fmt.Errorf("profile %q writes to %q, like an earlier profile", r.Name, r.OutputFile)
+		}
+		outputs[filepath.Clean(r.OutputFile)] = true
+
+		keywords, weights, _, err := parseKeywords(r.Keywords, strategy)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", r.Name, err)
+		}
+		if len(keywords) == 0 && r.Domain == "" {
+			return nil, fmt.Errorf("profile %q needs keywords or a domain", r.Name)
+		}
+		profiles = append(profiles, keywordProfile{
+			name:       r.Name,
+			keywords:   keywords,
+			weights:    weights,
+			domain:     r.Domain,
+			outputFile: r.OutputFile,
+		})
+	}
+	return profiles, nil
+}
+
+// loadNotifiers reads the notifiers in the JSON file at path: a list of
+// objects, each with a "type" of "command", running "command" once per story
+// like -on-match, or "webhook", posting the stories to "url" as encode renders
+// them.
+func loadNotifiers(path string, encode func([]hngrep.Story) ([]byte, error)) ([]hngrep.Notifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q: %w", path, err)
+	}
+	var raw []struct {
+		Type    string `json:"type"`
+		Command string `json:"command"`
+		URL     string `json:"url"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error unmarshalling notifiers %q: %w", path, err)
+	}
+
+	notifiers := make([]hngrep.Notifier, 0, len(raw))
+	for i, r := range raw {
+		switch r.Type {
+		case "command":
+			if strings.TrimSpace(r.Command) == "" {
+				return nil, fmt.Errorf("notifier %d has no command", i+1)
+			}
+			notifiers = append(notifiers, &hngrep.CommandNotifier{Command: r.Command})
+		case "webhook":
+			u, err := url.Parse(r.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("notifier %d needs an http or https url, got %q", i+1, r.URL)
+			}
+			notifiers = append(notifiers, &hngrep.WebhookNotifier{URL: r.URL, Encode: encode})
+		default:
+			return nil, fmt.Errorf("notifier %d has unknown type %q; want command or webhook", i+1, r.Type)
+		}
+	}
+	return notifiers, nil
+}
+
+// readIDs parses item IDs from r, given either as a JSON array or one per line.
+func readIDs(r io.Reader) ([]int, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading IDs: %w", err)
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var ids []int
+		if err := json.Unmarshal(trimmed, &ids); err != nil {
+			return nil, fmt.Errorf("error unmarshalling IDs: %w", err)
+		}
+		return ids, nil
+	}
+
+	var ids []int
+	for _, line := range strings.Split(string(trimmed), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		id, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid item ID %q: %w", line, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// loadIDs reads item IDs from path, or from stdin if path is "-".
+func loadIDs(path string) ([]int, error) {
+	if path == "-" {
+		return readIDs(os.Stdin)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening IDs file %q: %w", path, err)
+	}
+	defer file.Close()
+	return readIDs(file)
+}
+
+// loadTemplate returns the HTML template selected by cfg: the file given via
+// -template if set, then -template-name among the templates in -template-dir,
+// otherwise the embedded template named by -template-style.
+func loadTemplate(cfg *cliFlags) (*template.Template, error) {
+	if cfg.templateFile != "" {
+		tmpl, err := newTemplate(filepath.Base(cfg.templateFile), cfg).ParseFiles(cfg.templateFile)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing template %q: %w", cfg.templateFile, err)
+		}
+		return tmpl, nil
+	}
+	if cfg.templateDir != "" {
+		pattern := filepath.Join(cfg.templateDir, "*.html")
+		tmpl, err := newTemplate(cfg.templateName, cfg).ParseGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing templates in %q: %w", cfg.templateDir, err)
+		}
+		if tmpl.Tree == nil {
+			return nil, fmt.Errorf("template dir %q has no template named %q", cfg.templateDir, cfg.templateName)
+		}
+		return tmpl, nil
+	}
+
+	name, ok := templateStyles[cfg.templateStyle]
+	if !ok {
+		return nil, fmt.Errorf("unknown template style %q", cfg.templateStyle)
+	}
+	tmpl, err := newTemplate(name, cfg).ParseFS(templatesFS, name)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing embedded template %q: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// newTemplate returns an empty template named name with the template
+// functions, configured by cfg, that every HTML template gets.
+func newTemplate(name string, cfg *cliFlags) *template.Template {
+	return template.New(name).Funcs(templateFuncs).Funcs(langFuncs(cfg.lang)).Funcs(highlightFuncs(cfg.maxHighlights)).Funcs(timeFuncs(cfg.location()))
+}
+
+// writeHTML applies tmpl to data and writes the resulting HTML to htmlFilePath,
+// passing it through minifyHTML first if minify is set.
+func writeHTML(htmlFilePath string, tmpl *template.Template, data HTMLData, minify bool) error {
+	file, err := os.OpenFile(htmlFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open HTML file %q: %w", htmlFilePath, err)
+	}
+	defer file.Close()
+
+	if !minify {
+		if err := tmpl.Execute(file, data); err != nil {
+			return fmt.Errorf("failed to execute template: %w", err)
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+	if _, err := io.WriteString(file, minifyHTML(buf.String())); err != nil {
+		return fmt.Errorf("failed to write HTML file %q: %w", htmlFilePath, err)
+	}
+	return nil
 }
 
-// cliFlags holds all command-line flag values.
-type cliFlags struct {
-	maxStories int
-	keywords   []string
-	domain     string
-	htmlFile   string
-	delay      time.Duration
+// rawTextTags are the elements whose contents minifyHTML copies as they are:
+// whitespace is significant in pre and textarea, and a newline in a script
+// can end a line comment. Stylesheets are collapsed like markup, since CSS
+// has no line comments.
+var rawTextTags = []string{"pre", "textarea", "script"}
+
+// minifyHTML strips comments from src and collapses each run of whitespace
+// outside rawTextTags and quoted attribute values into a single space. A
+// space is kept rather than dropped even between tags, since browsers render
+// it between inline elements like links.
+func minifyHTML(src string) string {
+	var b strings.Builder
+	pending := false // Whether whitespace was collapsed since the last byte written.
+	write := func(s string) {
+		if pending && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		pending = false
+		b.WriteString(s)
+	}
+
+	var quote byte // Quote of the attribute value being copied, or 0.
+	inTag := false
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote != 0:
+			b.WriteByte(c)
+			if c == quote {
+				quote = 0
+			}
+		case inTag && (c == '"' || c == '\''):
+			write(src[i : i+1])
+			quote = c
+		case inTag && c == '>':
+			pending = false
+			b.WriteByte(c)
+			inTag = false
+		case !inTag && strings.HasPrefix(src[i:], "<!--"):
+			end := strings.Index(src[i+len("<!--"):], "-->")
+			if end < 0 {
+				return strings.TrimSpace(b.String())
+			}
+			i += len("<!--") + end + len("-->") - 1
+		case !inTag && c == '<':
+			if tag := rawTextTag(src[i:]); tag != "" {
+				end := indexFold(src[i:], "</"+tag)
+				if end < 0 {
+					end = len(src) - i
+				}
+				write(src[i : i+end])
+				i += end - 1
+				continue
+			}
+			write("<")
+			inTag = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			pending = true
+		default:
+			write(src[i : i+1])
+		}
+	}
+	return strings.TrimSpace(b.String())
 }
 
-// HTMLData represents the data passed to the HTML template.
-type HTMLData struct {
-	Keywords   string
-	Domain     string
-	Stories    []story
-	MaxStories int
+// rawTextTag returns which of rawTextTags s opens with, or "".
+func rawTextTag(s string) string {
+	for _, tag := range rawTextTags {
+		n := len("<") + len(tag)
+		if len(s) > n && strings.EqualFold(s[1:n], tag) && strings.IndexByte(" \t\n\r\f/>", s[n]) >= 0 {
+			return tag
+		}
+	}
+	return ""
 }
 
-// parseFlags parses and validates command-line flags, returning a fully populated *cliFlags.
-func parseFlags() (*cliFlags, error) {
-	maxStories := flag.Int("max-stories", 100, "Maximum number of stories to fetch")
-	keywords := flag.String("keywords", "", "Comma-separated list of keywords to filter stories")
-	domain := flag.String("domain", "", "Domain to filter stories by URL, (default '')")
-	htmlFile := flag.String("html-file", "index.html", "Output HTML file for matched stories")
-	delay := flag.Duration("delay", 100*time.Millisecond, "Delay between requests")
+// indexFold is strings.Index, ignoring ASCII case.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
 
-	flag.Parse()
+// reportEntry records the match outcome of a single fetched story.
+type reportEntry struct {
+	ID            int      `json:"id"`
+	Title         string   `json:"title"`
+	Domain        string   `json:"domain,omitempty"` // The linked page's host.
+	Matched       bool     `json:"matched"`
+	Keywords      []string `json:"keywords"`
+	Score         int      `json:"score"`
+	DomainMatched bool     `json:"domain_matched"` // Whether the -domain filter matched.
+	Reason        string   `json:"reason,omitempty"`
+}
 
-	if *maxStories <= 0 {
-		return nil, fmt.Errorf("max-stories must be a positive integer")
-	}
-	if strings.TrimSpace(*keywords) == "" {
-		return nil, fmt.Errorf("keywords must be provided")
+// writeReport writes entries as indented JSON to reportFilePath.
+func writeReport(reportFilePath string, entries []reportEntry) error {
+	body, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
 	}
-	if *delay < 100*time.Millisecond {
-		return nil, fmt.Errorf("delay must be greater than or equal to 100ms")
+	if err := os.WriteFile(reportFilePath, append(body, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write report file %q: %w", reportFilePath, err)
 	}
+	return nil
+}
 
-	rawKeywords := strings.Split(*keywords, ",")
-	cleanedKeywords := make([]string, 0, len(rawKeywords))
-	for _, kw := range rawKeywords {
-		kw = strings.TrimSpace(kw)
-		if kw != "" {
-			cleanedKeywords = append(cleanedKeywords, kw)
+// newJSONOutput builds the JSON envelope for keywords and data. Slices are never
+// nil, so they encode as [] rather than null.
+func newJSONOutput(keywords []string, data HTMLData) jsonOutput {
+	out := jsonOutput{
+		Version:     jsonSchemaVersion,
+		GeneratedAt: data.GeneratedAt,
+		Keywords:    append([]string{}, keywords...),
+		Domain:      data.Domain,
+		Stories:     make([]jsonStory, len(data.Stories)),
+	}
+	for i, s := range data.Stories {
+		out.Stories[i] = jsonStory{
+			ID:              s.ID,
+			Title:           s.Title,
+			URL:             s.URL,
+			Domain:          s.Domain,
+			HNURL:           s.StoryURL,
+			Score:           s.Score,
+			MatchedKeywords: append([]string{}, s.MatchedKeywords...),
+			Relevance:       s.Relevance,
+			Snippet:         s.Snippet,
+			Summary:         s.Summary,
+		}
+		if s.Link != nil {
+			out.Stories[i].Link = &jsonLink{Alive: s.Link.Alive, StatusCode: s.Link.StatusCode}
 		}
 	}
-
-	return &cliFlags{
-		maxStories: *maxStories,
-		keywords:   cleanedKeywords,
-		domain:     *domain,
-		htmlFile:   *htmlFile,
-		delay:      *delay,
-	}, nil
+	return out
 }
 
-// hackerNewsClient defines an interface for fetching top stories and individual story details.
-type hackerNewsClient interface {
-	getTopStories() ([]int, error)
-	getStory(id int) (*story, error)
+// webhookBody returns an encoder rendering stories as the envelope
+// -output-format=json writes, for webhook notifiers to post.
+func webhookBody(keywords []string, domain string, loc *time.Location) func([]hngrep.Story) ([]byte, error) {
+	return func(stories []hngrep.Story) ([]byte, error) {
+		data := HTMLData{Domain: domain, Stories: stories, GeneratedAt: time.Now().In(loc)}
+		return json.Marshal(newJSONOutput(keywords, data))
+	}
 }
 
-// hnClient implements hackerNewsClient, fetching data from the live Hacker News API.
-type hnClient struct {
-	topStoriesURL   string
-	itemURLTemplate string
-	maxStories      int
+// writeJSON writes out as indented JSON to path.
+func writeJSON(path string, out jsonOutput) error {
+	body, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON output: %w", err)
+	}
+	if err := os.WriteFile(path, append(body, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write JSON file %q: %w", path, err)
+	}
+	return nil
 }
 
-// Compile-time check that hnClient implements hackerNewsClient.
-var _ hackerNewsClient = (*hnClient)(nil)
-
-// getTopStories fetches the IDs of the top stories from Hacker News.
-func (c *hnClient) getTopStories() ([]int, error) {
-	resp, err := http.Get(c.topStoriesURL)
+// readJSON reads the envelope writeJSON wrote to path. A missing file reads
+// as an envelope with no stories, so the first run of a digest has nothing to
+// merge; one written by another schema version is an error.
+func readJSON(path string) (jsonOutput, error) {
+	body, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return jsonOutput{Version: jsonSchemaVersion}, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error fetching top stories: %w", err)
+		return jsonOutput{}, fmt.Errorf("failed to read JSON file %q: %w", path, err)
+	}
+	var out jsonOutput
+	if err := json.Unmarshal(body, &out); err != nil {
+		return jsonOutput{}, fmt.Errorf("failed to parse JSON file %q: %w", path, err)
+	}
+	if out.Version != jsonSchemaVersion {
+		return jsonOutput{}, fmt.Errorf("JSON file %q has schema version %d, want %d", path, out.Version, jsonSchemaVersion)
+	}
+	return out, nil
+}
+
+// mergeStories returns stories followed by the earlier ones not among them,
+// deduplicated by ID, so a story matched again keeps its newer score and
+// keywords.
+func mergeStories(stories, earlier []jsonStory) []jsonStory {
+	merged := append([]jsonStory{}, stories...)
+	seen := make(map[int]bool, len(stories))
+	for _, s := range stories {
+		seen[s.ID] = true
+	}
+	for _, s := range earlier {
+		if !seen[s.ID] {
+			seen[s.ID] = true
+			merged = append(merged, s)
+		}
 	}
-	defer resp.Body.Close()
+	return merged
+}
 
-	body, err := io.ReadAll(resp.Body)
+// writeYAML writes stories to path as a YAML list.
+func writeYAML(path string, stories []jsonStory) error {
+	body, err := yaml.Marshal(stories)
 	if err != nil {
-		return nil, fmt.Errorf("error reading top stories body: %w", err)
+		return fmt.Errorf("failed to marshal YAML output: %w", err)
+	}
+	if err := os.WriteFile(path, body, 0o644); err != nil {
+		return fmt.Errorf("failed to write YAML file %q: %w", path, err)
 	}
+	return nil
+}
 
-	var ids []int
-	if err := json.Unmarshal(body, &ids); err != nil {
-		return nil, fmt.Errorf("error unmarshalling top story IDs: %w", err)
+// storyColumns are the columns the TSV and CSV outputs can hold, by name,
+// each rendering a story's field as text.
+var storyColumns = map[string]func(s hngrep.Story) string{
+	"id":               func(s hngrep.Story) string { return strconv.Itoa(s.ID) },
+	"title":            func(s hngrep.Story) string { return s.Title },
+	"url":              func(s hngrep.Story) string { return s.URL },
+	"domain":           func(s hngrep.Story) string { return s.Domain },
+	"hn_url":           func(s hngrep.Story) string { return s.StoryURL },
+	"score":            func(s hngrep.Story) string { return strconv.Itoa(s.Score) },
+	"comments":         func(s hngrep.Story) string { return strconv.Itoa(s.Comments) },
+	"matched_keywords": func(s hngrep.Story) string { return strings.Join(s.MatchedKeywords, ", ") },
+	"relevance":        func(s hngrep.Story) string { return strconv.Itoa(s.Relevance) },
+}
+
+// tsvHeader names the columns writeTSV writes, in order.
+var tsvHeader = []string{"id", "title", "url", "domain", "hn_url", "score", "matched_keywords", "relevance"}
+
+// defaultCSVFields is the default -csv-fields: the TSV columns plus comments.
+const defaultCSVFields = "id,title,url,domain,hn_url,score,comments,matched_keywords,relevance"
+
+// parseCSVFields parses a comma-separated list of storyColumns names.
+func parseCSVFields(s string) ([]string, error) {
+	var fields []string
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		if _, ok := storyColumns[field]; !ok {
+			return nil, fmt.Errorf("csv-fields must name columns among %s, got %q", strings.Join(slices.Sorted(maps.Keys(storyColumns)), ", "), field)
+		}
+		fields = append(fields, field)
 	}
-	return ids, nil
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("csv-fields must name at least one column")
+	}
+	return fields, nil
+}
+
+// tsvSpace matches the tabs and line breaks that tsvField replaces.
+var tsvSpace = regexp.MustCompile(`[\t\r\n]+`)
+
+// tsvField replaces each run of tabs and line breaks in s with a space, so
+// the field pastes into a single spreadsheet cell.
+func tsvField(s string) string {
+	return tsvSpace.ReplaceAllString(s, " ")
 }
 
-// getStory fetches the details of a single story by ID from Hacker News.
-func (c *hnClient) getStory(id int) (*story, error) {
-	url := fmt.Sprintf(c.itemURLTemplate, id)
-	resp, err := http.Get(url)
+// writeTSV writes stories to path as tab-separated values with a header row,
+// for pasting into spreadsheets like Google Sheets.
+func writeTSV(path string, stories []hngrep.Story) error {
+	body, err := storyTable(stories, tsvHeader, '\t', tsvField)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching story %d: %w", id, err)
+		return fmt.Errorf("failed to write TSV output: %w", err)
+	}
+	if err := os.WriteFile(path, body, 0o644); err != nil {
+		return fmt.Errorf("failed to write TSV file %q: %w", path, err)
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// writeCSV writes stories to path as comma-separated values with a header
+// row, holding the storyColumns named by fields, in that order.
+func writeCSV(path string, stories []hngrep.Story, fields []string) error {
+	body, err := storyTable(stories, fields, ',', func(s string) string { return s })
 	if err != nil {
-		return nil, fmt.Errorf("error reading story body: %w", err)
+		return fmt.Errorf("failed to write CSV output: %w", err)
 	}
-
-	var s story
-	if err := json.Unmarshal(body, &s); err != nil {
-		return nil, fmt.Errorf("error unmarshalling story %d: %w", id, err)
+	if err := os.WriteFile(path, body, 0o644); err != nil {
+		return fmt.Errorf("failed to write CSV file %q: %w", path, err)
 	}
-
-	s.StoryURL = fmt.Sprintf("https://news.ycombinator.com/item?id=%d", id)
-	return &s, nil
+	return nil
 }
 
-// compilePattern compiles a regex pattern that matches any of the provided keywords as full words.
-func compilePattern(keywords []string) string {
-	escapedKeywords := make([]string, len(keywords))
-	for i, kw := range keywords {
-		// Escape regex metacharacters and lowercase each keyword
-		escapedKeywords[i] = regexp.QuoteMeta(strings.ToLower(kw))
+// storyTable renders stories as a header row of columns and a row per story,
+// separated by comma, passing each field through clean.
+func storyTable(stories []hngrep.Story, columns []string, comma rune, clean func(string) string) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = comma
+	if err := w.Write(columns); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+	record := make([]string, len(columns))
+	for _, s := range stories {
+		for i, c := range columns {
+			record[i] = clean(storyColumns[c](s))
+		}
+		if err := w.Write(record); err != nil {
+			return nil, fmt.Errorf("failed to write row for story %d: %w", s.ID, err)
+		}
 	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// opmlDocument is the OPML 2.0 subscription list written by -output-format=opml.
+type opmlDocument struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Created string        `xml:"head>dateCreated"`
+	Items   []opmlOutline `xml:"body>outline"`
+}
 
-	// Simulate word boundaries: (^|[^A-Za-z0-9_]) for the start and ($|[^A-Za-z0-9_]) for the end
-	// Example: (?i)(^|[^A-Za-z0-9_])(go|rust|python)($|[^A-Za-z0-9_])
-	return `(?i)(^|[^A-Za-z0-9_])(` + strings.Join(escapedKeywords, "|") + `)($|[^A-Za-z0-9_])`
+// opmlOutline is an OPML outline element linking to url. Each matched story
+// gets one, with a nested outline for its HN discussion.
+type opmlOutline struct {
+	Text  string        `xml:"text,attr"`
+	Type  string        `xml:"type,attr,omitempty"`
+	URL   string        `xml:"url,attr,omitempty"`
+	Items []opmlOutline `xml:"outline,omitempty"`
 }
 
-// matches checks whether the given story's title or domain (URL) matches any
-// of the specified keywords or the provided domain filter.
-func matches(s *story, keywords []string, domain string) bool {
-	// If domain is non-empty, check if the story's URL contains it (case-insensitive).
-	if domain != "" && strings.Contains(strings.ToLower(s.URL), strings.ToLower(domain)) {
-		return true
+// writeOPML writes stories to path as an OPML reading list. Self posts, which
+// have no URL, link to their HN discussion instead.
+func writeOPML(path string, stories []hngrep.Story, created time.Time) error {
+	doc := opmlDocument{
+		Version: "2.0",
+		Title:   "HN Grep",
+		Created: created.Format(time.RFC1123Z),
+		Items:   make([]opmlOutline, len(stories)),
+	}
+	for i, s := range stories {
+		link := s.URL
+		if link == "" {
+			link = s.StoryURL
+		}
+		doc.Items[i] = opmlOutline{
+			Text:  s.Title,
+			Type:  "link",
+			URL:   link,
+			Items: []opmlOutline{{Text: "HN discussion", Type: "link", URL: s.StoryURL}},
+		}
 	}
 
-	// Compile a single regex pattern for all keywords
-	pattern := compilePattern(keywords)
-	re := regexp.MustCompile(pattern)
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal OPML output: %w", err)
+	}
+	body = append([]byte(xml.Header), append(body, '\n')...)
+	if err := os.WriteFile(path, body, 0o644); err != nil {
+		return fmt.Errorf("failed to write OPML file %q: %w", path, err)
+	}
+	return nil
+}
 
-	// Check if the story's title matches any keyword
-	return re.MatchString(strings.ToLower(s.Title))
+// archiveRecord is a line of the -archive-file JSON Lines log.
+type archiveRecord struct {
+	SeenAt time.Time `json:"seen_at"`
+	Story  jsonStory `json:"story"`
 }
 
-// writeHTML applies tmpl to data and writes the resulting HTML to htmlFilePath.
-func writeHTML(htmlFilePath string, tmpl *template.Template, data HTMLData) error {
-	file, err := os.OpenFile(htmlFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+// appendArchive appends a record stamped seenAt for each story not already in
+// the JSON Lines archive at path, creating it if needed. Existing records are
+// never rewritten.
+func appendArchive(path string, stories []jsonStory, seenAt time.Time) error {
+	archived, err := archivedIDs(path)
 	if err != nil {
-		return fmt.Errorf("failed to open HTML file %q: %w", htmlFilePath, err)
+		return err
 	}
-	defer file.Close()
 
-	if err := tmpl.Execute(file, data); err != nil {
-		return fmt.Errorf("failed to execute template: %w", err)
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, s := range stories {
+		if archived[s.ID] {
+			continue
+		}
+		archived[s.ID] = true
+		if err := enc.Encode(archiveRecord{SeenAt: seenAt, Story: s}); err != nil {
+			return fmt.Errorf("failed to marshal archive record: %w", err)
+		}
 	}
-	return nil
+	if b.Len() == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file %q: %w", path, err)
+	}
+	if _, err := f.Write(b.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write archive file %q: %w", path, err)
+	}
+	return f.Close()
 }
 
-// run orchestrates the high-level application logic: fetching top stories,
-// filtering them, logging matches, and writing the matched stories to an HTML file.
-func run(cfg *cliFlags, logger *log.Logger, client hackerNewsClient, tmpl *template.Template) error {
-	ids, err := client.getTopStories()
+// archivedIDs returns the IDs of the stories recorded in the archive at path,
+// which may not exist yet.
+func archivedIDs(path string) (map[int]bool, error) {
+	ids := make(map[int]bool)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ids, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get top stories: %w", err)
+		return nil, fmt.Errorf("failed to open archive file %q: %w", path, err)
 	}
+	defer f.Close()
 
-	logger.Printf("Fetched %d stories. Displaying first %d...", len(ids), cfg.maxStories)
-	logger.Println(strings.Repeat("=", 80))
+	dec := json.NewDecoder(f)
+	for {
+		var rec archiveRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return ids, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read archive file %q: %w", path, err)
+		}
+		ids[rec.Story.ID] = true
+	}
+}
 
-	var matchedStories []story
+// markdownEscaper escapes the characters that would break a Markdown link's text.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`)
 
-	for i, id := range ids {
-		if i >= cfg.maxStories {
-			break
+// writeMarkdown writes stories to path as a Markdown list of links in style,
+// one of markdownStyles. Self posts link to their HN discussion.
+func writeMarkdown(path string, stories []hngrep.Story, style string) error {
+	var b strings.Builder
+	for _, s := range stories {
+		link := s.URL
+		if link == "" {
+			link = s.StoryURL
 		}
+		fmt.Fprintf(&b, "%s[%s](%s)", markdownStyles[style], markdownEscaper.Replace(s.Title), link)
+		if s.Domain != "" {
+			fmt.Fprintf(&b, " (%s)", s.Domain)
+		}
+		b.WriteByte('\n')
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("failed to write Markdown file %q: %w", path, err)
+	}
+	return nil
+}
+
+// run orchestrates the high-level application logic: fetching top stories,
+// filtering them, logging matches, and writing the matched stories to an HTML file.
+// It returns the matched stories, including those matched before an aborted run.
+func run(ctx context.Context, cfg *cliFlags, logger *log.Logger, client hngrep.Client, tmpl *template.Template) ([]hngrep.Story, error) {
+	return search(ctx, cfg, logger, tmpl, func(opts hngrep.Options) (hngrep.Result, error) {
+		return hngrep.Grep(ctx, opts, client)
+	})
+}
 
-		storyData, err := client.getStory(id)
+// search finds the matching stories with find, then writes and notifies them
+// as cfg says. It returns the matched stories, including those matched before
+// an aborted run.
+func search(ctx context.Context, cfg *cliFlags, logger *log.Logger, tmpl *template.Template, find func(hngrep.Options) (hngrep.Result, error)) ([]hngrep.Story, error) {
+	opts := cfg.options(logger)
+	if cfg.dumpPatternFile != "" {
+		f, err := os.Create(cfg.dumpPatternFile)
 		if err != nil {
-			logger.Printf("Failed to fetch story %d: %v", id, err)
-			continue
-		}
-		if storyData == nil {
-			logger.Printf("Story %d not found (nil).", id)
-			continue
+			return nil, fmt.Errorf("failed to create pattern dump file %q: %w", cfg.dumpPatternFile, err)
 		}
+		defer f.Close()
+		opts.PatternDump = f
+	}
+	res, err := find(opts)
+	if err != nil && !errors.Is(err, hngrep.ErrTooManyFailures) {
+		return res.Stories, err
+	}
 
-		// Log the story title to stdout
-		logger.Printf("[%d] Title: %s", i+1, storyData.Title)
+	// Flush partial results too when the run was cut short by failing fetches.
+	if werr := writeOutputs(cfg, tmpl, res); werr != nil {
+		return res.Stories, werr
+	}
 
-		// Check if this story matches the keywords or domain
-		if matches(storyData, cfg.keywords, cfg.domain) {
-			logger.Println("   MATCHED!")
-			matchedStories = append(matchedStories, *storyData)
-		} else {
-			logger.Println("   NOT MATCHED.")
+	if len(cfg.notifiers) > 0 {
+		if nerr := hngrep.Notify(ctx, cfg.notifiers, res.Stories); nerr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to notify: %w", nerr))
 		}
+	}
+	return res.Stories, err
+}
+
+// runProfiles runs the search once per profile in cfg.profiles, each with its
+// own keywords, domain, and output file, writing each profile's matches as
+// run does. The feed and its stories are fetched once, up front, and every
+// profile filters that same Feed, so what one profile looked up, like
+// comments, serves the rest, and -max-comment-fetches caps the whole run.
+func runProfiles(ctx context.Context, cfg *cliFlags, logger *log.Logger, client hngrep.Client, tmpl *template.Template) ([]hngrep.Story, error) {
+	feed, fetchErr := hngrep.Fetch(ctx, cfg.options(logger), client)
+	if fetchErr != nil && !errors.Is(fetchErr, hngrep.ErrTooManyFailures) {
+		return nil, fetchErr
+	}
 
-		logger.Println(strings.Repeat("-", 80))
-		time.Sleep(cfg.delay)
+	var matched []hngrep.Story
+	for _, p := range cfg.profiles {
+		pcfg := *cfg
+		pcfg.profiles = nil
+		pcfg.keywords, pcfg.weights, pcfg.domain = p.keywords, p.weights, p.domain
+		pcfg.htmlFile, pcfg.outputFile = p.outputFile, p.outputFile
+
+		logger.Printf("Profile %s:", p.name)
+		stories, err := search(ctx, &pcfg, logger, tmpl, func(opts hngrep.Options) (hngrep.Result, error) {
+			return hngrep.Filter(ctx, opts, feed, client)
+		})
+		matched = append(matched, stories...)
+		if err != nil {
+			return matched, fmt.Errorf("profile %s: %w", p.name, err)
+		}
 	}
+	// Each profile wrote what was fetched before the run was cut short.
+	return matched, fetchErr
+}
 
-	logger.Printf("\nMatched %d stories.\n", len(matchedStories))
+// runCount runs the search without logging, writing output files, or notifying,
+// and prints only the number of matched stories to w. A run cut short by
+// failing fetches still prints what it matched before returning the error.
+func runCount(ctx context.Context, cfg *cliFlags, client hngrep.Client, w io.Writer) error {
+	res, err := hngrep.Grep(ctx, cfg.options(nil), client)
+	if err != nil && !errors.Is(err, hngrep.ErrTooManyFailures) {
+		return err
+	}
+	if _, werr := fmt.Fprintln(w, len(res.Stories)); werr != nil {
+		return werr
+	}
+	return err
+}
 
+// writeOutputs writes the matched stories to the HTML file and, if configured,
+// the per-story report to the report file.
+func writeOutputs(cfg *cliFlags, tmpl *template.Template, res hngrep.Result) error {
 	data := HTMLData{
 		Keywords:   strings.Join(cfg.keywords, ", "),
 		Domain:     cfg.domain,
-		Stories:    matchedStories,
+		Stories:    res.Stories,
 		MaxStories: cfg.maxStories,
+
+		GeneratedAt:  time.Now().In(cfg.location()),
+		TotalFetched: res.Fetched,
+	}
+	if cfg.includeRejected {
+		for _, o := range res.Outcomes {
+			if !o.Matched {
+				data.Rejected = append(data.Rejected, rejectedStory{Title: o.Title, StoryURL: o.StoryURL, Reason: o.Reason})
+			}
+		}
+	}
+
+	switch cfg.outputFormat {
+	case "json":
+		out := newJSONOutput(cfg.keywords, data)
+		if cfg.mergeFile != "" {
+			earlier, err := readJSON(cfg.mergeFile)
+			if err != nil {
+				return fmt.Errorf("failed to read merge file: %w", err)
+			}
+			out.Stories = mergeStories(out.Stories, earlier.Stories)
+		}
+		if err := writeJSON(cfg.outputFile, out); err != nil {
+			return fmt.Errorf("failed to write JSON file: %w", err)
+		}
+	case "yaml":
+		if err := writeYAML(cfg.outputFile, newJSONOutput(cfg.keywords, data).Stories); err != nil {
+			return fmt.Errorf("failed to write YAML file: %w", err)
+		}
+	case "tsv":
+		if err := writeTSV(cfg.outputFile, res.Stories); err != nil {
+			return fmt.Errorf("failed to write TSV file: %w", err)
+		}
+	case "csv":
+		if err := writeCSV(cfg.outputFile, res.Stories, cfg.csvFields); err != nil {
+			return fmt.Errorf("failed to write CSV file: %w", err)
+		}
+	case "opml":
+		if err := writeOPML(cfg.outputFile, res.Stories, data.GeneratedAt); err != nil {
+			return fmt.Errorf("failed to write OPML file: %w", err)
+		}
+	case "markdown":
+		if err := writeMarkdown(cfg.outputFile, res.Stories, cfg.markdownStyle); err != nil {
+			return fmt.Errorf("failed to write Markdown file: %w", err)
+		}
+	default:
+		if err := writeHTML(cfg.htmlFile, tmpl, data, cfg.minify); err != nil {
+			return fmt.Errorf("failed to write HTML file: %w", err)
+		}
 	}
 
-	if err := writeHTML(cfg.htmlFile, tmpl, data); err != nil {
-		return fmt.Errorf("failed to write HTML file: %w", err)
+	if cfg.archiveFile != "" {
+		if err := appendArchive(cfg.archiveFile, newJSONOutput(cfg.keywords, data).Stories, data.GeneratedAt); err != nil {
+			return fmt.Errorf("failed to archive stories: %w", err)
+		}
+	}
+
+	if cfg.reportFile != "" {
+		report := make([]reportEntry, len(res.Outcomes))
+		for i, o := range res.Outcomes {
+			report[i] = reportEntry{
+				ID:            o.ID,
+				Title:         o.Title,
+				Domain:        o.Host,
+				Matched:       o.Matched,
+				Keywords:      append([]string{}, o.Keywords...),
+				Score:         o.Score,
+				DomainMatched: o.Domain,
+				Reason:        o.Reason,
+			}
+		}
+		if err := writeReport(cfg.reportFile, report); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// matchList is the state of the -interactive browser: the matched stories and
+// which one is selected.
+type matchList struct {
+	stories  []hngrep.Story
+	selected int
+}
+
+// up selects the previous story, staying on the first one.
+func (l *matchList) up() {
+	if l.selected > 0 {
+		l.selected--
+	}
+}
+
+// down selects the next story, staying on the last one.
+func (l *matchList) down() {
+	if l.selected < len(l.stories)-1 {
+		l.selected++
+	}
+}
+
+// current returns the selected story, reporting false when there are none.
+func (l *matchList) current() (hngrep.Story, bool) {
+	if len(l.stories) == 0 {
+		return hngrep.Story{}, false
+	}
+	return l.stories[l.selected], true
+}
+
+// browseRows is how many stories the browser shows at once.
+const browseRows = 20
+
+// render draws l to w, a terminal in raw mode, followed by status, if any.
+func (l *matchList) render(w io.Writer, status string) error {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J") // Home the cursor and clear the screen.
+	fmt.Fprintf(&b, "Matched %d stories. Arrow keys or j and k move, Enter opens the HN discussion, q quits.\r\n\r\n", len(l.stories))
+	first := max(0, l.selected-browseRows+1)
+	for i := first; i < len(l.stories) && i < first+browseRows; i++ {
+		marker := "  "
+		if i == l.selected {
+			marker = "> "
+		}
+		fmt.Fprintf(&b, "%s%s (%d points)\r\n", marker, l.stories[i].Title, l.stories[i].Score)
+	}
+	if status != "" {
+		fmt.Fprintf(&b, "\r\n%s\r\n", status)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// opener opens a URL for the user.
+type opener interface {
+	Open(url string) error
+}
+
+// browserOpener opens URLs in the system's default browser.
+type browserOpener struct{}
+
+func (browserOpener) Open(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
+
+// browse runs the -interactive browser over l, reading keypresses from in and
+// drawing to out, until q, Ctrl-C, or the end of in. Enter opens the selected
+// story's HN discussion with op.
+func browse(l *matchList, in io.Reader, out io.Writer, op opener) error {
+	r := bufio.NewReader(in)
+	status := ""
+	for {
+		if err := l.render(out, status); err != nil {
+			return err
+		}
+		status = ""
+		key, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch key {
+		case 'q', 3: // 3 is Ctrl-C, which raw mode delivers as a byte.
+			return nil
+		case 'k':
+			l.up()
+		case 'j':
+			l.down()
+		case '\r', '\n':
+			if s, ok := l.current(); ok {
+				if err := op.Open(s.StoryURL); err != nil {
+					status = fmt.Sprintf("Failed to open %s: %v", s.StoryURL, err)
+				}
+			}
+		case 0x1b:
+			// Arrow keys arrive as ESC [ A for up and ESC [ B for down, all in
+			// one read. An ESC with nothing buffered after it is the Escape key
+			// on its own, so it isn't left waiting for a next key.
+			if r.Buffered() < 2 {
+				continue
+			}
+			seq, _ := r.Peek(2)
+			if seq[0] != '[' {
+				continue
+			}
+			_, _ = r.Discard(2)
+			switch seq[1] {
+			case 'A':
+				l.up()
+			case 'B':
+				l.down()
+			}
+		}
+	}
+}
+
+// newHTTPClient returns the HTTP client for HN API requests, trusting the CAs
+// in caFile besides the system ones and skipping verification if insecure.
+// It returns nil, meaning http.DefaultClient, when neither is set.
+func newHTTPClient(caFile string, insecure bool) (*http.Client, error) {
+	if caFile == "" && !insecure {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file %q: %w", caFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %q has no PEM certificates", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// newLogger returns the run's logger, writing to w with prefix at the start of
+// every line, including the extra lines of multi-line messages.
+func newLogger(w io.Writer, prefix string) *log.Logger {
+	if prefix != "" {
+		w = &prefixWriter{w: w, prefix: prefix}
+	}
+	return log.New(w, "", log.LstdFlags)
+}
+
+// prefixWriter writes prefix before each line written through it.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  string
+	midLine bool // Whether the last write ended partway through a line.
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	var b bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !pw.midLine {
+			b.WriteString(pw.prefix)
+		}
+		b.Write(line)
+		pw.midLine = line[len(line)-1] != '\n'
+	}
+	if _, err := pw.w.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// profile runs fn, writing a CPU profile of it to cpuPath and a heap profile
+// taken after it to memPath. An empty path skips that profile. The profiles are
+// flushed before profile returns, since log.Fatalf skips deferred calls.
+func profile(cpuPath, memPath string, fn func() error) error {
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			return fmt.Errorf("failed to create CPU profile %q: %w", cpuPath, err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	runErr := fn()
+	if memPath != "" {
+		if err := writeHeapProfile(memPath); err != nil {
+			return errors.Join(runErr, err)
+		}
+	}
+	return runErr
+}
+
+// writeHeapProfile writes a heap profile, as of the last garbage collection, to path.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create memory profile %q: %w", path, err)
+	}
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write memory profile %q: %w", path, err)
+	}
+	return f.Close()
+}
+
 // main is the entry point of the program, orchestrating flag parsing and the run sequence.
 func main() {
 	cfg, err := parseFlags()
 	if err != nil {
 		log.Fatalf("Failed to parse CLI flags: %v", err)
 	}
+	// Warnings and fatal errors go through the standard logger; they carry
+	// -log-prefix like the run's own lines.
+	log.SetPrefix(cfg.logPrefix)
+	for _, w := range cfg.warnings {
+		log.Printf("WARNING: %s.", w)
+	}
+
+	if err := dispatch(cfg, os.Stdout, newClient); err != nil {
+		log.Fatalf("Application error: %v", err)
+	}
+}
+
+// dispatch runs the mode cfg selects, writing to stdout. It builds the HN
+// client with newClient only for the modes that fetch, so -version and
+// -explain never touch the network.
+func dispatch(cfg *cliFlags, stdout io.Writer, newClient func(*cliFlags) (hngrep.Client, error)) error {
+	if cfg.showVersion {
+		_, err := fmt.Fprintln(stdout, versionString())
+		return err
+	}
 
-	logger := log.New(os.Stdout, "", log.LstdFlags)
+	if cfg.explain {
+		if err := hngrep.Explain(stdout, cfg.options(nil)); err != nil {
+			return fmt.Errorf("failed to explain keywords: %w", err)
+		}
+		return nil
+	}
 
-	tmpl, err := template.ParseFiles("template.html")
+	client, err := newClient(cfg)
 	if err != nil {
-		log.Fatalf("Failed to load HTML template: %v", err)
+		return err
 	}
 
-	client := &hnClient{
-		topStoriesURL:   "https://hacker-news.firebaseio.com/v0/topstories.json",
-		itemURLTemplate: "https://hacker-news.firebaseio.com/v0/item/%d.json",
-		maxStories:      cfg.maxStories,
+	if cfg.count {
+		return profile(cfg.cpuProfile, cfg.memProfile, func() error {
+			return runCount(context.Background(), cfg, client, stdout)
+		})
 	}
 
-	if err := run(cfg, logger, client, tmpl); err != nil {
-		log.Fatalf("Application error: %v", err)
+	logger := newLogger(stdout, cfg.logPrefix)
+
+	tmpl, err := loadTemplate(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load HTML template: %w", err)
+	}
+
+	search := run
+	if len(cfg.profiles) > 0 {
+		search = runProfiles
+	}
+	var stories []hngrep.Story
+	err = profile(cfg.cpuProfile, cfg.memProfile, func() error {
+		var err error
+		stories, err = search(context.Background(), cfg, logger, client, tmpl)
+		return err
+	})
+	if err != nil || !cfg.interactive {
+		return err
+	}
+	return browseTerminal(&matchList{stories: stories}, stdout)
+}
+
+// newClient builds the HN API client cfg describes.
+func newClient(cfg *cliFlags) (hngrep.Client, error) {
+	hnClient := hngrep.NewHNClient()
+	if cfg.insecureTLS {
+		log.Println("WARNING: -insecure-skip-verify is set; HN API responses can be intercepted or forged.")
+	}
+	var err error
+	if hnClient.HTTPClient, err = newHTTPClient(cfg.caFile, cfg.insecureTLS); err != nil {
+		return nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+	hnClient.Header = cfg.headers
+	hnClient.MaxBodyBytes = cfg.maxBodyBytes
+	hnClient.StrictJSON = cfg.strictJSON
+	hnClient.KeepRaw = cfg.matchField != ""
+	hnClient.FieldMap = cfg.fieldMap
+	hnClient.TopStoriesURL = hngrep.Feeds[cfg.feed]
+	hnClient.ItemURLTemplate = cfg.itemURLTemplate
+	// Users come from the same API as items; an unknown layout leaves them
+	// unset, which parseFlags only allows without -min-author-karma.
+	hnClient.UserURLTemplate, _ = hngrep.UserURLTemplateFor(cfg.itemURLTemplate)
+	var client hngrep.Client = hnClient
+
+	if cfg.idsFile != "" {
+		ids, err := loadIDs(cfg.idsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load item IDs: %w", err)
+		}
+		client = &hngrep.FixedIDsClient{Client: client, IDs: ids}
 	}
+	return client, nil
 }
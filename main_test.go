@@ -2,30 +2,51 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"html/template"
+	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
-	"regexp"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
+
+	"github.com/rednafi/hn-alert/hngrep"
+	"gopkg.in/yaml.v3"
 )
 
-// FakeHackerNewsClient is a mock implementation of hackerNewsClient.
+// FakeHackerNewsClient is a mock implementation of hngrep.Client.
 type FakeHackerNewsClient struct {
-	TopStories []int
-	Stories    map[int]story
+	TopStories     []int
+	Stories        map[int]hngrep.Story
+	Fetched        []int         // IDs passed to GetStory, in call order.
+	Errors         map[int]error // Errors returned by GetStory for specific IDs.
+	TopStoriesHits int           // Calls to GetTopStories.
 }
 
-// getTopStories simulates fetching top story IDs.
-func (f *FakeHackerNewsClient) getTopStories() ([]int, error) {
+// GetTopStories simulates fetching top story IDs.
+func (f *FakeHackerNewsClient) GetTopStories() ([]int, error) {
+	f.TopStoriesHits++
 	return f.TopStories, nil
 }
 
-// getStory simulates fetching a story by ID.
-func (f *FakeHackerNewsClient) getStory(id int) (*story, error) {
+// GetStory simulates fetching a story by ID.
+func (f *FakeHackerNewsClient) GetStory(id int) (*hngrep.Story, error) {
+	f.Fetched = append(f.Fetched, id)
+	if err, ok := f.Errors[id]; ok {
+		return nil, err
+	}
 	st, ok := f.Stories[id]
 	if !ok {
 		// Simulate a story not found (nil, nil).
@@ -34,10 +55,66 @@ func (f *FakeHackerNewsClient) getStory(id int) (*story, error) {
 	return &st, nil
 }
 
+// defaultFlags returns what parseFlags returns for "-keywords=go" and no rc
+// files or environment, with overrides applied, for TestParseFlags' cases.
+func defaultFlags(overrides ...func(c *cliFlags)) *cliFlags {
+	c := &cliFlags{
+		maxStories: 100,
+		keywords:   []string{"go"},
+		htmlFile:   "index.html",
+		delay:      100 * time.Millisecond,
+		maxDelay:   100 * time.Millisecond,
+
+		templateStyle: "full",
+		templateName:  "index.html",
+		outputFormat:  "html",
+		csvFields:     []string{"id", "title", "url", "domain", "hn_url", "score", "comments", "matched_keywords", "relevance"},
+		markdownStyle: "list",
+		lang:          "en",
+
+		matchStrategy: "boundary",
+
+		maxConsecutiveFailures: 10,
+		retryBudget:            50,
+
+		commentDepth: 3,
+		commentLimit: 50,
+
+		maxCommentFetches: 200,
+
+		maxUserLookups: 100,
+
+		sampleRate: 1,
+
+		feed:       "top",
+		domainMode: "or",
+
+		sortBy: "feed",
+
+		maxBodyBytes: hngrep.DefaultMaxBodyBytes,
+
+		timezone: time.UTC,
+
+		itemURLTemplate: hngrep.DefaultItemURLTemplate,
+	}
+	for _, override := range overrides {
+		override(c)
+	}
+	return c
+}
+
 func TestParseFlags(t *testing.T) {
 	t.Parallel()
 	originalArgs := os.Args
 	defer func() { os.Args = originalArgs }()
+	// Keep the developer's own rc files out of the defaults under test.
+	originalRCPaths := rcPaths
+	defer func() { rcPaths = originalRCPaths }()
+	noRC := []string{filepath.Join(t.TempDir(), rcFileName)}
+	rcPaths = func() []string { return noRC }
+	originalLookupEnv := lookupEnv
+	defer func() { lookupEnv = originalLookupEnv }()
+	lookupEnv = func(string) (string, bool) { return "", false }
 
 	tests := []struct {
 		name        string
@@ -50,13 +127,55 @@ func TestParseFlags(t *testing.T) {
 			args: []string{
 				"cmd", "-max-stories=10", "-keywords=go,rust", "-domain=example.com", "-html-file=test.html", "-delay=200ms",
 			},
-			want: &cliFlags{
-				maxStories: 10,
-				keywords:   []string{"go", "rust"},
-				domain:     "example.com",
-				htmlFile:   "test.html",
-				delay:      200 * time.Millisecond,
-			},
+			want: defaultFlags(func(c *cliFlags) {
+				c.maxStories = 10
+				c.keywords = []string{"go", "rust"}
+				c.domain = "example.com"
+				c.htmlFile = "test.html"
+				c.delay, c.maxDelay = 200*time.Millisecond, 200*time.Millisecond
+			}),
+		},
+		{
+			name: "Weighted keywords",
+			args: []string{"cmd", "-keywords=go:3, rust ,std::move", "-min-relevance=2"},
+			want: defaultFlags(func(c *cliFlags) {
+				c.keywords = []string{"go", "rust", "std::move"}
+				c.weights = map[string]int{"go": 3}
+				c.minRelevance = 2
+			}),
+		},
+		{
+			name: "Duplicate keywords",
+			args: []string{"cmd", "-keywords=go,rust,Go,go:2"},
+			want: defaultFlags(func(c *cliFlags) {
+				c.keywords = []string{"go", "rust"}
+				c.weights = map[string]int{"go": 2}
+				c.warnings = []string{
+					`keyword "Go" duplicates "go", as keywords ignore case; ignoring it`,
+					`keyword "go" is listed more than once; ignoring the duplicate`,
+				}
+			}),
+		},
+		{
+			name: "Overlapping substring keywords",
+			args: []string{"cmd", "-keywords=go,golang,rust", "-substring"},
+			want: defaultFlags(func(c *cliFlags) {
+				c.keywords = []string{"go", "golang", "rust"}
+				c.matchStrategy = "substring"
+				c.warnings = []string{
+					`keyword "golang" contains "go", so under substring matching every title it matches also matches "go"`,
+				}
+			}),
+		},
+		{
+			name:        "Non-positive keyword weight",
+			args:        []string{"cmd", "-keywords=go:0"},
+			expectError: "keyword weight must be a positive integer",
+		},
+		{
+			name: "Version flag skips validation",
+			args: []string{"cmd", "-version", "-max-stories=-5"},
+			want: &cliFlags{showVersion: true},
 		},
 		{
 			name:        "Missing keywords",
@@ -73,6 +192,211 @@ func TestParseFlags(t *testing.T) {
 			args:        []string{"cmd", "-max-stories=10", "-keywords=go", "-delay=50ms"},
 			expectError: "delay must be greater than or equal to 100ms",
 		},
+		{
+			name: "Jittered delay",
+			args: []string{"cmd", "-keywords=go", "-min-delay=200ms", "-max-delay=1s"},
+			want: defaultFlags(func(c *cliFlags) {
+				c.delay, c.maxDelay = 200*time.Millisecond, time.Second
+			}),
+		},
+		{
+			name:        "Max delay below min delay",
+			args:        []string{"cmd", "-keywords=go", "-min-delay=500ms", "-max-delay=200ms"},
+			expectError: "max-delay must be greater than or equal to min-delay",
+		},
+		{
+			name:        "Invalid domain regex",
+			args:        []string{"cmd", "-keywords=go", "-domain-regex=(edu"},
+			expectError: "domain-regex must be a valid regular expression",
+		},
+		{
+			name:        "Self-only with links-only",
+			args:        []string{"cmd", "-keywords=go", "-self-only", "-links-only"},
+			expectError: "self-only and links-only are mutually exclusive",
+		},
+		{
+			name: "Substring shortcut",
+			args: []string{"cmd", "-keywords=go", "-substring"},
+			want: defaultFlags(func(c *cliFlags) {
+				c.matchStrategy = "substring"
+			}),
+		},
+		{
+			name:        "Unknown match strategy",
+			args:        []string{"cmd", "-keywords=go", "-match-strategy=fuzzy"},
+			expectError: "match-strategy must be one of boundary, substring, regex",
+		},
+		{
+			name:        "Substring with another strategy",
+			args:        []string{"cmd", "-keywords=go", "-substring", "-match-strategy=regex"},
+			expectError: `substring conflicts with match-strategy "regex"`,
+		},
+		{
+			name:        "Invalid regex keyword",
+			args:        []string{"cmd", "-keywords=go(", "-match-strategy=regex"},
+			expectError: `keyword "go(" must be a valid regular expression`,
+		},
+		{
+			name: "Repeated headers",
+			args: []string{"cmd", "-keywords=go", "-header=Authorization: Bearer secret", "-header", "X-Forwarded-For:10.0.0.1"},
+			want: defaultFlags(func(c *cliFlags) {
+				c.headers = http.Header{
+					"Authorization":   {"Bearer secret"},
+					"X-Forwarded-For": {"10.0.0.1"},
+				}
+			}),
+		},
+		{
+			name:        "Malformed header",
+			args:        []string{"cmd", "-keywords=go", "-header=Authorization Bearer"},
+			expectError: "header must be in 'Key: Value' form",
+		},
+		{
+			name:        "Negative since-id",
+			args:        []string{"cmd", "-keywords=go", "-since-id=-1"},
+			expectError: "since-id must not be negative",
+		},
+		{
+			name:        "Invalid locale",
+			args:        []string{"cmd", "-keywords=go", "-locale=not_a_tag!"},
+			expectError: "locale must be a valid BCP 47 language tag",
+		},
+		{
+			name: "Rules without keywords",
+			args: []string{"cmd", "-rule=title:security,cve", "-rule", "url:github.com"},
+			want: defaultFlags(func(c *cliFlags) {
+				c.keywords = []string{}
+				c.rules = []hngrep.Rule{
+					{Scope: hngrep.ScopeTitle, Keywords: []string{"security", "cve"}},
+					{Scope: hngrep.ScopeURL, Keywords: []string{"github.com"}},
+				}
+			}),
+		},
+		{
+			name:        "Unknown rule scope",
+			args:        []string{"cmd", "-keywords=go", "-rule=body:security"},
+			expectError: "rule scope must be title or url",
+		},
+		{
+			name:        "Unknown output format",
+			args:        []string{"cmd", "-keywords=go", "-output-format=xml"},
+			expectError: "output-format must be one of csv, html, json, markdown, opml, tsv, or yaml",
+		},
+		{
+			name:        "Rank start after rank end",
+			args:        []string{"cmd", "-keywords=go", "-rank-start=100", "-rank-end=50"},
+			expectError: "rank-start must be less than or equal to rank-end",
+		},
+		{
+			name:        "Unknown feed",
+			args:        []string{"cmd", "-keywords=go", "-feed=worst"},
+			expectError: "feed must be one of top, new, best, ask, show, job, or updates",
+		},
+		{
+			name:        "Non-positive max body bytes",
+			args:        []string{"cmd", "-keywords=go", "-max-body-bytes=0"},
+			expectError: "max-body-bytes must be a positive integer",
+		},
+		{
+			name:        "Unknown markdown style",
+			args:        []string{"cmd", "-keywords=go", "-markdown-style=table"},
+			expectError: "markdown-style must be one of list or tasklist",
+		},
+		{
+			name:        "Negative since",
+			args:        []string{"cmd", "-keywords=go", "-since=-1h"},
+			expectError: "since must not be negative",
+		},
+		{
+			name:        "Stop when stale outside the new feed",
+			args:        []string{"cmd", "-keywords=go", "-since=24h", "-stop-when-stale"},
+			expectError: "stop-when-stale needs since and feed new, the only time-ordered feed",
+		},
+		{
+			name:        "Stop when stale without since",
+			args:        []string{"cmd", "-keywords=go", "-feed=new", "-stop-when-stale"},
+			expectError: "stop-when-stale needs since and feed new, the only time-ordered feed",
+		},
+		{
+			name:        "Unknown CSV field",
+			args:        []string{"cmd", "-keywords=go", "-csv-fields=id,votes"},
+			expectError: `csv-fields must name columns among comments, domain, hn_url, id, matched_keywords, relevance, score, title, url, got "votes"`,
+		},
+		{
+			name:        "Merge file without JSON output",
+			args:        []string{"cmd", "-keywords=go", "-merge-file=old.json"},
+			expectError: "merge-file needs output-format json",
+		},
+		{
+			name:        "Unknown sort order",
+			args:        []string{"cmd", "-keywords=go", "-sort=score"},
+			expectError: "sort must be one of feed or matchcount",
+		},
+		{
+			name:        "Item URL template without %d",
+			args:        []string{"cmd", "-keywords=go", "-item-url-template=http://cache.local/item"},
+			expectError: "item-url-template is invalid",
+		},
+		{
+			name:        "Profiles with keywords",
+			args:        []string{"cmd", "-keywords=go", "-profiles-file=profiles.json"},
+			expectError: "profiles-file can't be combined with -keywords",
+		},
+		{
+			name:        "Profiles with a report file",
+			args:        []string{"cmd", "-profiles-file=profiles.json", "-report-file=report.json"},
+			expectError: "profiles-file can't be combined with -report-file",
+		},
+		{
+			name:        "Author karma with an item template users can't be derived from",
+			args:        []string{"cmd", "-keywords=go", "-min-author-karma=10", "-item-url-template=http://cache.local/items/%d"},
+			expectError: "min-author-karma looks users up",
+		},
+		{
+			name:        "Zero sample rate",
+			args:        []string{"cmd", "-keywords=go", "-sample-rate=0"},
+			expectError: "sample-rate must be above 0 and at most 1",
+		},
+		{
+			name:        "Sample rate above 1",
+			args:        []string{"cmd", "-keywords=go", "-sample-rate=1.5"},
+			expectError: "sample-rate must be above 0 and at most 1",
+		},
+		{
+			name:        "Unknown timezone",
+			args:        []string{"cmd", "-keywords=go", "-timezone=Mars/Olympus_Mons"},
+			expectError: "timezone must be an IANA time zone name",
+		},
+		{
+			name:        "Match field with an empty segment",
+			args:        []string{"cmd", "-keywords=go", "-match-field=metadata..tags"},
+			expectError: "match-field must be a dot-separated path of field names",
+		},
+		{
+			name:        "Compact log interval below 1",
+			args:        []string{"cmd", "-keywords=go", "-compact-log", "-compact-log-interval=0"},
+			expectError: "compact-log-interval must be at least 1",
+		},
+		{
+			name:        "Unknown domain mode",
+			args:        []string{"cmd", "-keywords=go", "-domain=go.dev", "-domain-mode=xor"},
+			expectError: "domain-mode must be one of or, and, or only",
+		},
+		{
+			name:        "Domain-only mode without a domain",
+			args:        []string{"cmd", "-domain-mode=only"},
+			expectError: "domain-mode only needs -domain or -domain-regex",
+		},
+		{
+			name:        "Domain mode without a domain",
+			args:        []string{"cmd", "-keywords=go", "-domain-mode=and"},
+			expectError: "domain-mode and needs -domain or -domain-regex",
+		},
+		{
+			name:        "Unknown template style",
+			args:        []string{"cmd", "-keywords=go", "-template-style=fancy"},
+			expectError: "template-style must be one of full or compact",
+		},
 	}
 
 	for _, tt := range tests {
@@ -97,114 +421,348 @@ func TestParseFlags(t *testing.T) {
 	}
 }
 
-func TestCompilePattern(t *testing.T) {
+func TestUniquePaths(t *testing.T) {
 	t.Parallel()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd returned error: %v", err)
+	}
+	paths := []string{filepath.Join(wd, rcFileName), rcFileName, "other/" + rcFileName}
+	want := []string{filepath.Join(wd, rcFileName), "other/" + rcFileName}
+	if got := uniquePaths(paths); !reflect.DeepEqual(got, want) {
+		t.Errorf("uniquePaths(%v) = %v, want %v", paths, got, want)
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	// Not parallel: it swaps the package-level build information.
+	origVersion, origCommit, origDate := version, commit, date
+	defer func() { version, commit, date = origVersion, origCommit, origDate }()
+
+	version, commit, date = "v1.2.3", "abc1234", "2024-01-02T03:04:05Z"
+
+	want := "hn-grep v1.2.3 (commit abc1234, built 2024-01-02T03:04:05Z)"
+	if got := versionString(); got != want {
+		t.Errorf("versionString() = %q, want %q", got, want)
+	}
+}
+
+func TestDispatchNoFetch(t *testing.T) {
+	// Not parallel: it swaps os.Args, the flag set, the rc file paths, and the environment.
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+	originalRCPaths := rcPaths
+	defer func() { rcPaths = originalRCPaths }()
+	noRC := []string{filepath.Join(t.TempDir(), rcFileName)}
+	rcPaths = func() []string { return noRC }
+	originalLookupEnv := lookupEnv
+	defer func() { lookupEnv = originalLookupEnv }()
+	lookupEnv = func(string) (string, bool) { return "", false }
+
 	tests := []struct {
-		name     string
-		keywords []string
-		input    string
-		want     bool
+		name string
+		args []string
+		want string // Expected in the output.
 	}{
-		{
-			name:     "Single keyword, partial match",
-			keywords: []string{"go"},
-			input:    "I love golang", // Should NOT match
-			want:     false,
-		},
-		{
-			name:     "Single keyword, full word match",
-			keywords: []string{"go"},
-			input:    "Let's learn go today", // Should match
-			want:     true,
-		},
-		{
-			name:     "Multiple keywords, one matches",
-			keywords: []string{"java", "rust", "python"},
-			input:    "Rust is fast", // Should match "rust"
-			want:     true,
-		},
-		{
-			name:     "Case-insensitivity",
-			keywords: []string{"gO"},
-			input:    "Let's learn Go today", // "gO" => should match
-			want:     true,
-		},
-		{
-			name:     "No match",
-			keywords: []string{"go"},
-			input:    "Rust is also cool", // Doesn't contain "go"
-			want:     false,
-		},
-		{
-			name:     "Special regex characters, should be escaped",
-			keywords: []string{"c++", "c#"},
-			input:    "I like c++ and c# a lot", // Should match both
-			want:     true,
-		},
+		{name: "Version", args: []string{"cmd", "-version"}, want: versionString()},
+		{name: "Version with keywords", args: []string{"cmd", "-version", "-keywords=go"}, want: versionString()},
+		{name: "Explain", args: []string{"cmd", "-explain", "-keywords=go"}, want: `match     "Notes on go"`},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			pattern := compilePattern(tt.keywords)
-			re := regexp.MustCompile(pattern)
+			os.Args = tt.args
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+			cfg, err := parseFlags()
+			if err != nil {
+				t.Fatalf("parseFlags() returned error: %v", err)
+			}
 
-			got := re.MatchString(tt.input)
-			if got != tt.want {
-				t.Errorf("For keywords=%v and input=%q, pattern=%q => got %v, want %v",
-					tt.keywords, tt.input, pattern, got, tt.want)
+			fakeClient := &FakeHackerNewsClient{TopStories: []int{1}, Stories: map[int]hngrep.Story{1: {ID: 1, Title: "Go tips"}}}
+			var out bytes.Buffer
+			err = dispatch(cfg, &out, func(*cliFlags) (hngrep.Client, error) { return fakeClient, nil })
+			if err != nil {
+				t.Fatalf("dispatch(...) returned error: %v", err)
+			}
+			if fakeClient.TopStoriesHits != 0 || len(fakeClient.Fetched) != 0 {
+				t.Errorf("Fetched the feed %d times and stories %v, want no requests", fakeClient.TopStoriesHits, fakeClient.Fetched)
+			}
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("Output = %q, want it to contain %q", out.String(), tt.want)
 			}
 		})
 	}
 }
 
-func TestMatches(t *testing.T) {
+func TestMatchList(t *testing.T) {
 	t.Parallel()
+	stories := []hngrep.Story{{ID: 1}, {ID: 2}, {ID: 3}}
 	tests := []struct {
-		name     string
-		s        story
-		keywords []string
-		domain   string
-		want     bool
+		name  string
+		moves string // u for up, d for down.
+		want  int    // Selected ID.
+	}{
+		{name: "Starts on the first story", want: 1},
+		{name: "Down", moves: "d", want: 2},
+		{name: "Down then up", moves: "ddu", want: 2},
+		{name: "Up stays on the first story", moves: "uu", want: 1},
+		{name: "Down stays on the last story", moves: "dddd", want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			l := &matchList{stories: stories}
+			for _, move := range tt.moves {
+				if move == 'u' {
+					l.up()
+				} else {
+					l.down()
+				}
+			}
+			if s, ok := l.current(); !ok || s.ID != tt.want {
+				t.Errorf("current() = %d, %t; want %d, true", s.ID, ok, tt.want)
+			}
+		})
+	}
+
+	if _, ok := (&matchList{}).current(); ok {
+		t.Error("current() on an empty list reported a story")
+	}
+}
+
+// fakeOpener records the URLs it's asked to open.
+type fakeOpener struct {
+	opened []string
+	err    error
+}
+
+func (o *fakeOpener) Open(url string) error {
+	o.opened = append(o.opened, url)
+	return o.err
+}
+
+func TestBrowse(t *testing.T) {
+	t.Parallel()
+	stories := []hngrep.Story{
+		{ID: 1, Title: "Go tips", StoryURL: "https://news.ycombinator.com/item?id=1"},
+		{ID: 2, Title: "Rust news", StoryURL: "https://news.ycombinator.com/item?id=2"},
+		{ID: 3, Title: "Zig notes", StoryURL: "https://news.ycombinator.com/item?id=3"},
+	}
+	tests := []struct {
+		name       string
+		keys       string
+		typed      bool // Keys arrive a byte per read, as when typed.
+		openErr    error
+		wantOpened []string
+		wantOutput string // Expected in the last screen drawn.
+	}{
+		{name: "Enter opens the selection", keys: "\r", wantOpened: []string{stories[0].StoryURL}},
+		{name: "Arrow keys", keys: "\x1b[B\x1b[B\x1b[A\r", wantOpened: []string{stories[1].StoryURL}},
+		{name: "Escape alone", keys: "\x1bj\r", typed: true, wantOpened: []string{stories[1].StoryURL}},
+		{name: "Escape before a key", keys: "\x1bj\r", wantOpened: []string{stories[1].StoryURL}},
+		{name: "j and k", keys: "jjk\rj\r", wantOpened: []string{stories[1].StoryURL, stories[2].StoryURL}},
+		{name: "q quits", keys: "q\r", wantOpened: nil},
+		{name: "Selection marker", keys: "j", wantOutput: "> Rust news (0 points)"},
+		{name: "Open failure", keys: "\r", openErr: errors.New("no browser"), wantOpened: []string{stories[0].StoryURL}, wantOutput: "Failed to open " + stories[0].StoryURL + ": no browser"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			op := &fakeOpener{err: tt.openErr}
+			var out bytes.Buffer
+			var in io.Reader = strings.NewReader(tt.keys)
+			if tt.typed {
+				in = iotest.OneByteReader(in)
+			}
+			if err := browse(&matchList{stories: stories}, in, &out, op); err != nil {
+				t.Fatalf("browse(...) returned error: %v", err)
+			}
+			if !reflect.DeepEqual(op.opened, tt.wantOpened) {
+				t.Errorf("Opened %v, want %v", op.opened, tt.wantOpened)
+			}
+			screens := strings.Split(out.String(), "\x1b[H\x1b[2J")
+			if last := screens[len(screens)-1]; !strings.Contains(last, tt.wantOutput) {
+				t.Errorf("Last screen = %q, want it to contain %q", last, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestReadIDs(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		input string
+		want  []int
+	}{
+		{name: "JSON array", input: "[3, 1, 2]", want: []int{3, 1, 2}},
+		{name: "Newline separated", input: "3\n1\n\n2\n", want: []int{3, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readIDs(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("readIDs(%q) returned error: %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readIDs(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := readIDs(strings.NewReader("12\nabc\n")); err == nil {
+		t.Error("Expected an error for a non-numeric ID")
+	}
+}
+
+func TestOutputFileDefaults(t *testing.T) {
+	t.Parallel()
+	got := outputFileDefaults()
+	// Every format's default is named, so a new format can't be left out.
+	for name, file := range outputFormats {
+		if file == "" {
+			file = "-html-file"
+		}
+		if want := file + " for " + name; !strings.Contains(got, want) {
+			t.Errorf("outputFileDefaults() = %q, want it to mention %q", got, want)
+		}
+	}
+}
+
+func TestJoinWords(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{words: nil, want: ""},
+		{words: []string{"html"}, want: "html"},
+		{words: []string{"html", "json"}, want: "html or json"},
+		{words: []string{"html", "json", "yaml"}, want: "html, json, or yaml"},
+	}
+	for _, tt := range tests {
+		if got := joinWords(tt.words, "or"); got != tt.want {
+			t.Errorf("joinWords(%q, \"or\") = %q, want %q", tt.words, got, tt.want)
+		}
+	}
+}
+
+func TestApplyRCFiles(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	home := filepath.Join(dir, "home.hngreprc")
+	local := filepath.Join(dir, "local.hngreprc")
+	if err := os.WriteFile(home, []byte("# Home defaults\nkeywords=go\n-max-stories=5\ndomain=example.com\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write home rc file: %v", err)
+	}
+	if err := os.WriteFile(local, []byte("\nkeywords = rust\ncolor\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write local rc file: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		paths []string
+		args  []string
+		want  map[string]string
 	}{
 		{
-			name:     "Domain match only",
-			s:        story{Title: "Random Title", URL: "https://example.com/path"},
-			keywords: []string{"go"},
-			domain:   "example.com",
-			want:     true,
+			name:  "Home rc only",
+			paths: []string{home},
+			want:  map[string]string{"keywords": "go", "max-stories": "5", "domain": "example.com", "color": "false"},
 		},
 		{
-			name:     "Keyword match only",
-			s:        story{Title: "Go is awesome", URL: "https://otherdomain.com"},
-			keywords: []string{"go"},
-			domain:   "",
-			want:     true,
+			name:  "Local rc overrides home rc",
+			paths: []string{home, local},
+			want:  map[string]string{"keywords": "rust", "max-stories": "5", "domain": "example.com", "color": "true"},
 		},
 		{
-			name:     "Neither domain nor keyword match",
-			s:        story{Title: "Rust tips", URL: "https://otherdomain.com"},
-			keywords: []string{"go"},
-			domain:   "example.com",
-			want:     false,
+			name:  "Flags override rc files",
+			paths: []string{home, local},
+			args:  []string{"-keywords=zig", "-color=false"},
+			want:  map[string]string{"keywords": "zig", "max-stories": "5", "domain": "example.com", "color": "false"},
 		},
 		{
-			name:     "Multiple keywords, domain mismatch",
-			s:        story{Title: "Python concurrency", URL: "https://xyz.com/python"},
-			keywords: []string{"go", "rust", "python"},
-			domain:   "example.com",
-			want:     true,
+			name:  "Missing files are ignored",
+			paths: []string{filepath.Join(dir, "missing"), local},
+			want:  map[string]string{"keywords": "rust", "max-stories": "100", "domain": "", "color": "true"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := matches(&tt.s, tt.keywords, tt.domain)
-			if got != tt.want {
-				t.Errorf("matches(%+v, %+v, %q) = %v, want %v",
-					tt.s, tt.keywords, tt.domain, got, tt.want)
+			fs := flag.NewFlagSet("cmd", flag.ContinueOnError)
+			fs.String("keywords", "", "")
+			fs.Int("max-stories", 100, "")
+			fs.String("domain", "", "")
+			fs.Bool("color", false, "")
+
+			if err := applyRCFiles(fs, tt.paths); err != nil {
+				t.Fatalf("applyRCFiles(%v) returned error: %v", tt.paths, err)
+			}
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatalf("Parse(%v) returned error: %v", tt.args, err)
+			}
+			for name, want := range tt.want {
+				if got := fs.Lookup(name).Value.String(); got != want {
+					t.Errorf("Flag %q = %q, want %q", name, got, want)
+				}
 			}
 		})
 	}
+
+	bad := filepath.Join(dir, "bad.hngreprc")
+	if err := os.WriteFile(bad, []byte("keywords=go\nno-such-flag=1\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write bad rc file: %v", err)
+	}
+	fs := flag.NewFlagSet("cmd", flag.ContinueOnError)
+	fs.String("keywords", "", "")
+	if err := applyRCFiles(fs, []string{bad}); err == nil || !strings.Contains(err.Error(), bad+":2:") {
+		t.Errorf("applyRCFiles with an unknown flag returned error %v, want one pointing at line 2", err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Parallel()
+	rc := filepath.Join(t.TempDir(), rcFileName)
+	if err := os.WriteFile(rc, []byte("keywords=go\nmax-stories=5\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write rc file: %v", err)
+	}
+	env := map[string]string{"HNGREP_KEYWORDS": "rust", "HNGREP_COLOR": "true", "HNGREP_DOMAIN": "example.com", "KEYWORDS": "zig"}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	fs := flag.NewFlagSet("cmd", flag.ContinueOnError)
+	fs.String("keywords", "", "")
+	fs.Int("max-stories", 100, "")
+	fs.String("domain", "", "")
+	fs.Bool("color", false, "")
+	if err := applyRCFiles(fs, []string{rc}); err != nil {
+		t.Fatalf("applyRCFiles returned error: %v", err)
+	}
+	if err := applyEnv(fs, lookup); err != nil {
+		t.Fatalf("applyEnv returned error: %v", err)
+	}
+	if err := fs.Parse([]string{"-domain=go.dev"}); err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	// The environment overrides the rc file, and flags override both.
+	want := map[string]string{"keywords": "rust", "max-stories": "5", "domain": "go.dev", "color": "true"}
+	for name, w := range want {
+		if got := fs.Lookup(name).Value.String(); got != w {
+			t.Errorf("Flag %q = %q, want %q", name, got, w)
+		}
+	}
+
+	env = map[string]string{"HNGREP_MAX_STORIES": "many"}
+	if err := applyEnv(fs, lookup); err == nil || !strings.Contains(err.Error(), "HNGREP_MAX_STORIES") {
+		t.Errorf("applyEnv with a bad value returned error %v, want one naming HNGREP_MAX_STORIES", err)
+	}
 }
 
 func TestWriteHTML(t *testing.T) {
@@ -219,7 +777,7 @@ func TestWriteHTML(t *testing.T) {
 	data := HTMLData{
 		Keywords: "go, rust",
 		Domain:   "example.com",
-		Stories: []story{
+		Stories: []hngrep.Story{
 			{Title: "Story 1", URL: "https://example.com/1", StoryURL: "https://news.ycombinator.com/item?id=1"},
 			{Title: "Story 2", URL: "https://example.com/2", StoryURL: "https://news.ycombinator.com/item?id=2"},
 		},
@@ -230,7 +788,7 @@ func TestWriteHTML(t *testing.T) {
 	_ = os.Remove(outFile) // Clean up old files if they exist
 
 	// 2. Act
-	err = writeHTML(outFile, tmpl, data)
+	err = writeHTML(outFile, tmpl, data, false)
 	if err != nil {
 		t.Fatalf("writeHTML returned error: %v", err)
 	}
@@ -260,38 +818,363 @@ func TestWriteHTML(t *testing.T) {
 	_ = os.Remove(outFile)
 }
 
-func TestRun(t *testing.T) {
+func TestWriteHTMLRunMetadata(t *testing.T) {
 	t.Parallel()
-	// 1. Arrange (setup)
-	fakeClient := &FakeHackerNewsClient{
-		TopStories: []int{101, 202, 303},
-		Stories: map[int]story{
-			101: {ID: 101, Title: "Go is cool", URL: "https://golang.org"},
-			202: {ID: 202, Title: "Random article", URL: "https://example.com/abc"},
-			303: {ID: 303, Title: "Rust is also cool", URL: "https://rust-lang.org"},
-		},
-	}
+	data := HTMLData{
+		Keywords:   "go, rust",
+		Stories:    []hngrep.Story{{Title: "Story 1", URL: "https://example.com/1"}},
+		MaxStories: 30,
 
-	// We'll match stories that have "go" in the title OR that have domain "example.com".
-	cfg := &cliFlags{
-		maxStories: 3,
-		keywords:   []string{"go"},
-		domain:     "example.com",
-		htmlFile:   "test_output.html",
-		delay:      0, // zero for no actual sleeping in tests
+		GeneratedAt:  time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		TotalFetched: 27,
 	}
 
-	var logBuf bytes.Buffer
-	stdoutLogger := log.New(&logBuf, "", 0)
+	for style := range templateStyles {
+		t.Run(style, func(t *testing.T) {
+			tmpl, err := loadTemplate(&cliFlags{templateStyle: style})
+			if err != nil {
+				t.Fatalf("loadTemplate returned error: %v", err)
+			}
 
-	tmpl, err := template.New("test").Parse(`
-<!DOCTYPE html>
-<html>
-<body>
-	<h2>Keywords: {{.Keywords}}</h2>
-	<h2>Domain: {{.Domain}}</h2>
-	<ul>
-	{{range .Stories}}
+			outFile := filepath.Join(t.TempDir(), "out.html")
+			if err := writeHTML(outFile, tmpl, data, false); err != nil {
+				t.Fatalf("writeHTML returned error: %v", err)
+			}
+			contents, err := os.ReadFile(outFile)
+			if err != nil {
+				t.Fatalf("Failed to read output file %q: %v", outFile, err)
+			}
+
+			for _, want := range []string{"Generated 2024-05-06 07:08:09 UTC", "from 27 fetched stories", "max-stories 30"} {
+				if !strings.Contains(string(contents), want) {
+					t.Errorf("HTML output does not contain %q.\nOutput:\n%s", want, contents)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteHTMLMinify(t *testing.T) {
+	t.Parallel()
+	data := HTMLData{
+		Keywords: "go",
+		Stories: []hngrep.Story{
+			{Title: "Go tips", URL: "https://example.com/1"},
+			{Title: "Rust news", URL: "https://example.com/2"},
+		},
+		MaxStories: 30,
+	}
+	tmpl, err := loadTemplate(&cliFlags{templateStyle: "full"})
+	if err != nil {
+		t.Fatalf("loadTemplate returned error: %v", err)
+	}
+
+	var sizes []int
+	for _, minify := range []bool{false, true} {
+		outFile := filepath.Join(t.TempDir(), "out.html")
+		if err := writeHTML(outFile, tmpl, data, minify); err != nil {
+			t.Fatalf("writeHTML(..., %t) returned error: %v", minify, err)
+		}
+		contents, err := os.ReadFile(outFile)
+		if err != nil {
+			t.Fatalf("Failed to read output file %q: %v", outFile, err)
+		}
+		for _, want := range []string{"Go tips", "Rust news"} {
+			if !strings.Contains(string(contents), want) {
+				t.Errorf("HTML output with minify %t does not contain %q.\nOutput:\n%s", minify, want, contents)
+			}
+		}
+		sizes = append(sizes, len(contents))
+	}
+	if sizes[1] >= sizes[0] {
+		t.Errorf("Minified HTML is %d bytes, want fewer than the %d unminified", sizes[1], sizes[0])
+	}
+}
+
+func TestMinifyHTML(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "Whitespace collapsed",
+			src:  "<ul>\n    <li><a href=\"/1\">One</a>\n    (x.com)</li>\n</ul>\n",
+			want: "<ul> <li><a href=\"/1\">One</a> (x.com)</li> </ul>",
+		},
+		{
+			name: "Comments stripped",
+			src:  "<p>a <!-- note\nhere --> b</p>",
+			want: "<p>a b</p>",
+		},
+		{
+			name: "Pre and script kept",
+			src:  "<PRE>  x\n  y</PRE>\n<script>// hi\nrun()</script>",
+			want: "<PRE>  x\n  y</PRE> <script>// hi\nrun()</script>",
+		},
+		{
+			name: "Quoted attributes kept",
+			src:  "<a  title=\"two  spaces\"\n  href='/x' >Link</a>",
+			want: "<a title=\"two  spaces\" href='/x'>Link</a>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := minifyHTML(tt.src); got != tt.want {
+				t.Errorf("minifyHTML(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslationsCoverRejectReasons(t *testing.T) {
+	t.Parallel()
+	for lang, table := range translations {
+		for _, reason := range hngrep.RejectReasons {
+			if _, ok := table[reason]; !ok {
+				t.Errorf("translations[%q] is missing reject reason %q", lang, reason)
+			}
+		}
+	}
+}
+
+func TestTemplateLang(t *testing.T) {
+	t.Parallel()
+	data := HTMLData{
+		Keywords: "go",
+		Stories: []hngrep.Story{{
+			Title: "Story 1", URL: "https://example.com/1", Score: 7,
+			MatchedKeywords: []string{"go"}, Link: &hngrep.LinkCheck{StatusCode: 404},
+		}},
+		MaxStories:   30,
+		GeneratedAt:  time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		TotalFetched: 27,
+	}
+
+	tests := []struct {
+		lang string
+		want []string
+	}{
+		{
+			lang: "de",
+			want: []string{`lang="de"`, "Stichwörter:", "1 Stories gefunden", "7 Punkte", "Treffer: go", "Toter Link (404)", "Diskussion", "aus 27 abgerufenen Stories"},
+		},
+		{
+			lang: "fr-CA",
+			want: []string{`lang="fr"`, "Mots-clés:", "1 articles correspondants", "Lien mort (404)", "Généré le 2024-05-06 07:08:09 UTC"},
+		},
+		{
+			lang: "xx",
+			want: []string{`lang="en"`, "Keywords:", "Matched 1 stories", "7 points", "Dead link (404)", "from 27 fetched stories"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.lang, func(t *testing.T) {
+			tmpl, err := loadTemplate(&cliFlags{templateStyle: "full", lang: tt.lang})
+			if err != nil {
+				t.Fatalf("loadTemplate returned error: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, data); err != nil {
+				t.Fatalf("Execute returned error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("HTML output does not contain %q.\nOutput:\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateOptionalFields(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "custom.html")
+	custom := `{{range .Stories}}{{.Title}}|{{.Score}}|{{.Relevance}}|{{join .MatchedKeywords ", "}}|` +
+		`{{default "no article" .ArticleTitle}}|{{default "none" .MatchedKeywords}};{{end}}`
+	if err := os.WriteFile(path, []byte(custom), 0o644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+
+	// A story with only an ID and title, as an older or partial run might produce.
+	data := HTMLData{Stories: []hngrep.Story{{ID: 1, Title: "Story 1"}}}
+
+	for _, cfg := range []*cliFlags{{templateFile: path}, {templateStyle: "full"}, {templateStyle: "compact"}} {
+		tmpl, err := loadTemplate(cfg)
+		if err != nil {
+			t.Fatalf("loadTemplate(%+v) returned error: %v", cfg, err)
+		}
+		outFile := filepath.Join(t.TempDir(), "out.html")
+		if err := writeHTML(outFile, tmpl, data, false); err != nil {
+			t.Fatalf("writeHTML with %+v returned error: %v", cfg, err)
+		}
+
+		if cfg.templateFile == "" {
+			continue
+		}
+		contents, err := os.ReadFile(outFile)
+		if err != nil {
+			t.Fatalf("Failed to read output file %q: %v", outFile, err)
+		}
+		if want := "Story 1|0|0||no article|none;"; string(contents) != want {
+			t.Errorf("HTML output = %q, want %q", contents, want)
+		}
+	}
+}
+
+func TestTimezone(t *testing.T) {
+	t.Parallel()
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("No tzdata for Asia/Tokyo: %v", err)
+	}
+	tests := []struct {
+		timezone *time.Location
+		want     string
+	}{
+		{timezone: nil, want: "2023-11-14 22:13 UTC"},
+		{timezone: time.UTC, want: "2023-11-14 22:13 UTC"},
+		{timezone: tokyo, want: "2023-11-15 07:13 JST"},
+	}
+
+	for _, tt := range tests {
+		tmpl := template.Must(newTemplate("t", &cliFlags{timezone: tt.timezone}).Parse(`{{(localtime 1700000000).Format "2006-01-02 15:04 MST"}}`))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, nil); err != nil {
+			t.Fatalf("Execute returned error: %v", err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("localtime in %v = %q, want %q", tt.timezone, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestHighlightFuncs(t *testing.T) {
+	t.Parallel()
+	// "Go & go, or GO?" with all three "go"s matched.
+	story := hngrep.Story{Title: "Go & go, or GO?", TitleSpans: [][2]int{{0, 2}, {5, 7}, {12, 14}}}
+	tests := []struct {
+		max  int
+		want string
+	}{
+		{max: 0, want: "<mark>Go</mark> &amp; <mark>go</mark>, or <mark>GO</mark>?"},
+		{max: 2, want: "<mark>Go</mark> &amp; <mark>go</mark>, or GO?"},
+		{max: 1, want: "<mark>Go</mark> &amp; go, or GO?"},
+		{max: 5, want: "<mark>Go</mark> &amp; <mark>go</mark>, or <mark>GO</mark>?"},
+	}
+
+	for _, tt := range tests {
+		tmpl := template.Must(template.New("t").Funcs(highlightFuncs(tt.max)).Parse(`{{highlight .}}`))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, story); err != nil {
+			t.Fatalf("Execute returned error: %v", err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("highlight with max %d = %q, want %q", tt.max, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestLoadTemplateDir(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":  `<html>{{template "header.html" .}}<ul>{{range .Stories}}{{template "story" .}}{{end}}</ul></html>`,
+		"header.html": `<h1>{{.Keywords}}</h1>`,
+		"story.html":  `{{define "story"}}<li>{{.Title}}</li>{{end}}`,
+		"notes.txt":   `{{not a template`,
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	data := HTMLData{Keywords: "go", Stories: []hngrep.Story{{Title: "Story 1"}, {Title: "Story 2"}}}
+
+	tmpl, err := loadTemplate(&cliFlags{templateDir: dir, templateName: "index.html"})
+	if err != nil {
+		t.Fatalf("loadTemplate returned error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if want := "<html><h1>go</h1><ul><li>Story 1</li><li>Story 2</li></ul></html>"; buf.String() != want {
+		t.Errorf("Rendered %q, want %q", buf.String(), want)
+	}
+
+	if _, err := loadTemplate(&cliFlags{templateDir: dir, templateName: "page.html"}); err == nil {
+		t.Error("loadTemplate with a missing -template-name returned nil error")
+	}
+}
+
+func TestLoadTemplateCompact(t *testing.T) {
+	t.Parallel()
+	tmpl, err := loadTemplate(&cliFlags{templateStyle: "compact"})
+	if err != nil {
+		t.Fatalf("loadTemplate returned error: %v", err)
+	}
+
+	data := HTMLData{
+		Stories: []hngrep.Story{
+			{Title: "Story 1", URL: "https://example.com/1", StoryURL: "https://news.ycombinator.com/item?id=1"},
+		},
+	}
+
+	outFile := filepath.Join(t.TempDir(), "compact.html")
+	if err := writeHTML(outFile, tmpl, data, false); err != nil {
+		t.Fatalf("writeHTML returned error: %v", err)
+	}
+
+	contents, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file %q: %v", outFile, err)
+	}
+	htmlOutput := string(contents)
+
+	if !strings.Contains(htmlOutput, `<a href="https://news.ycombinator.com/item?id=1">Story 1</a>`) {
+		t.Errorf("Compact HTML does not link the title to the HN discussion.\nOutput:\n%s", htmlOutput)
+	}
+	if strings.Contains(htmlOutput, "https://example.com/1") {
+		t.Errorf("Compact HTML should not contain the story URL.\nOutput:\n%s", htmlOutput)
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	// 1. Arrange (setup)
+	fakeClient := &FakeHackerNewsClient{
+		TopStories: []int{101, 202, 303},
+		Stories: map[int]hngrep.Story{
+			101: {ID: 101, Title: "Go is cool", URL: "https://golang.org"},
+			202: {ID: 202, Title: "Random article", URL: "https://example.com/abc"},
+			303: {ID: 303, Title: "Rust is also cool", URL: "https://rust-lang.org"},
+		},
+	}
+
+	// We'll match stories that have "go" in the title OR that have domain "example.com".
+	cfg := &cliFlags{
+		maxStories: 3,
+		keywords:   []string{"go"},
+		domain:     "example.com",
+		htmlFile:   "test_output.html",
+		delay:      0, // zero for no actual sleeping in tests
+	}
+
+	var logBuf bytes.Buffer
+	stdoutLogger := log.New(&logBuf, "", 0)
+
+	tmpl, err := template.New("test").Parse(`
+<!DOCTYPE html>
+<html>
+<body>
+	<h2>Keywords: {{.Keywords}}</h2>
+	<h2>Domain: {{.Domain}}</h2>
+	<ul>
+	{{range .Stories}}
 		<li><a href="{{.StoryURL}}">{{.Title}}</a> - {{.URL}}</li>
 	{{end}}
 	</ul>
@@ -304,12 +1187,23 @@ func TestRun(t *testing.T) {
 	_ = os.Remove(cfg.htmlFile) // Clean old file if present
 
 	// 2. Act
-	err = run(cfg, stdoutLogger, fakeClient, tmpl)
+	matched, err := run(context.Background(), cfg, stdoutLogger, fakeClient, tmpl)
 	if err != nil {
 		t.Fatalf("run(...) returned error: %v", err)
 	}
 
 	// 3. Assert
+	var matchedIDs []int
+	for _, s := range matched {
+		matchedIDs = append(matchedIDs, s.ID)
+	}
+	if want := []int{101, 202}; !reflect.DeepEqual(matchedIDs, want) {
+		t.Errorf("run(...) returned matched IDs %v, want %v", matchedIDs, want)
+	}
+	if len(matched) == 2 && !reflect.DeepEqual(matched[0].MatchedKeywords, []string{"go"}) {
+		t.Errorf("Expected story 101 to report keyword %q, got %v", "go", matched[0].MatchedKeywords)
+	}
+
 	logOutput := logBuf.String()
 	if !strings.Contains(logOutput, "[1] Title: Go is cool") ||
 		!strings.Contains(logOutput, "MATCHED!") {
@@ -341,3 +1235,1163 @@ func TestRun(t *testing.T) {
 
 	_ = os.Remove(cfg.htmlFile)
 }
+
+func TestLoadSynonyms(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	want := map[string][]string{
+		"kubernetes": {"k8s", "kube"},
+		"postgres":   {"postgresql"},
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+	}{
+		{
+			name:     "JSON mapping",
+			file:     "synonyms.json",
+			contents: `{"kubernetes": ["k8s", "kube"], "postgres": ["postgresql"]}`,
+		},
+		{
+			name:     "CSV mapping",
+			file:     "synonyms.csv",
+			contents: "kubernetes, k8s, kube\npostgres, postgresql\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.contents), 0o644); err != nil {
+				t.Fatalf("Failed to write synonyms file: %v", err)
+			}
+
+			got, err := loadSynonyms(path)
+			if err != nil {
+				t.Fatalf("loadSynonyms(%q) returned error: %v", path, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("loadSynonyms(%q) = %v, want %v", path, got, want)
+			}
+		})
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		contents string
+		want     []keywordProfile
+		wantErr  string
+	}{
+		{
+			name: "Two profiles",
+			contents: `[
+				{"name": "go", "keywords": ["go", "golang:2", "Go"], "output_file": "go.html"},
+				{"name": "github", "domain": "github.com", "output_file": "github.json"}
+			]`,
+			want: []keywordProfile{
+				{name: "go", keywords: []string{"go", "golang"}, weights: map[string]int{"golang": 2}, outputFile: "go.html"},
+				{name: "github", keywords: []string{}, domain: "github.com", outputFile: "github.json"},
+			},
+		},
+		{name: "No profiles", contents: `[]`, wantErr: "has no profiles"},
+		{name: "Missing name", contents: `[{"keywords": ["go"], "output_file": "go.html"}]`, wantErr: "profile 1 has no name"},
+		{name: "Missing output file", contents: `[{"name": "go", "keywords": ["go"]}]`, wantErr: `profile "go" has no output_file`},
+		{name: "Nothing to match", contents: `[{"name": "go", "output_file": "go.html"}]`, wantErr: `profile "go" needs keywords or a domain`},
+		{
+			name:     "Duplicate name",
+			contents: `[{"name": "go", "keywords": ["go"], "output_file": "a.html"}, {"name": "go", "keywords": ["rust"], "output_file": "b.html"}]`,
+			wantErr:  `profile "go" is defined more than once`,
+		},
+		{
+			name:     "Shared output file",
+			contents: `[{"name": "go", "keywords": ["go"], "output_file": "out.html"}, {"name": "rust", "keywords": ["rust"], "output_file": "./out.html"}]`,
+			wantErr:  `profile "rust" writes to "./out.html", like an earlier profile`,
+		},
+		{name: "Bad weight", contents: `[{"name": "go", "keywords": ["go:0"], "output_file": "go.html"}]`, wantErr: `profile "go": keyword weight must be a positive integer`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".json")
+			if err := os.WriteFile(path, []byte(tt.contents), 0o644); err != nil {
+				t.Fatalf("Failed to write profiles file: %v", err)
+			}
+			got, err := loadProfiles(path, hngrep.StrategyBoundary)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadProfiles(...) error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadProfiles(...) returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadProfiles(...) = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunProfiles(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeHackerNewsClient{
+		TopStories: []int{101, 202, 303, 404},
+		Stories: map[int]hngrep.Story{
+			101: {ID: 101, Title: "Go is cool", URL: "https://golang.org"},
+			202: {ID: 202, Title: "Rust is also cool", URL: "https://rust-lang.org"},
+			303: {ID: 303, Title: "Go and Rust together", URL: "https://github.com/x/y"},
+			404: {ID: 404, Title: "A new editor", URL: "https://github.com/a/b"},
+		},
+	}
+
+	dir := t.TempDir()
+	cfg := &cliFlags{
+		maxStories: 4,
+		profiles: []keywordProfile{
+			{name: "go", keywords: []string{"go"}, outputFile: filepath.Join(dir, "go.html")},
+			{name: "github", domain: "github.com", outputFile: filepath.Join(dir, "github.html")},
+		},
+	}
+	tmpl := template.Must(template.New("test").Parse(`{{.Keywords}}|{{.Domain}}|{{range .Stories}}{{.ID}} {{end}}`))
+
+	stories, err := runProfiles(context.Background(), cfg, log.New(io.Discard, "", 0), fakeClient, tmpl)
+	if err != nil {
+		t.Fatalf("runProfiles(...) returned error: %v", err)
+	}
+	if got, want := len(stories), 4; got != want {
+		t.Errorf("runProfiles(...) returned %d stories, want %d", got, want)
+	}
+
+	wantFiles := map[string]string{
+		"go.html":     "go||101 303 ",
+		"github.html": "|github.com|303 404 ",
+	}
+	for name, want := range wantFiles {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// The second profile matches the stories the first one fetched.
+	if fakeClient.TopStoriesHits != 1 {
+		t.Errorf("Fetched the feed %d times, want once", fakeClient.TopStoriesHits)
+	}
+	if want := []int{101, 202, 303, 404}; !reflect.DeepEqual(fakeClient.Fetched, want) {
+		t.Errorf("Fetched stories %v, want each once: %v", fakeClient.Fetched, want)
+	}
+}
+
+func TestRunProfilesSample(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeHackerNewsClient{TopStories: make([]int, 50), Stories: make(map[int]hngrep.Story)}
+	for i := range fakeClient.TopStories {
+		id := i + 1
+		fakeClient.TopStories[i] = id
+		fakeClient.Stories[id] = hngrep.Story{ID: id, Title: "Go story", URL: "https://github.com/x/y"}
+	}
+
+	dir := t.TempDir()
+	cfg := &cliFlags{
+		maxStories: 5,
+		sample:     true,
+		sampleRate: 0.5,
+		profiles: []keywordProfile{
+			{name: "go", keywords: []string{"go"}, outputFile: filepath.Join(dir, "go.html")},
+			{name: "github", domain: "github.com", outputFile: filepath.Join(dir, "github.html")},
+		},
+	}
+	tmpl := template.Must(template.New("test").Parse(`{{range .Stories}}{{.ID}} {{end}}`))
+
+	if _, err := runProfiles(context.Background(), cfg, log.New(io.Discard, "", 0), fakeClient, tmpl); err != nil {
+		t.Fatalf("runProfiles(...) returned error: %v", err)
+	}
+
+	// Without a seed, both profiles still see the same random sample.
+	goHTML, _ := os.ReadFile(filepath.Join(dir, "go.html"))
+	githubHTML, _ := os.ReadFile(filepath.Join(dir, "github.html"))
+	if string(goHTML) != string(githubHTML) {
+		t.Errorf("Profiles matched %q and %q, want the same sample", goHTML, githubHTML)
+	}
+	if n := len(fakeClient.Fetched); n != len(strings.Fields(string(goHTML))) {
+		t.Errorf("Fetched %d stories for a sample of %q, want each once", n, goHTML)
+	}
+}
+
+func TestRunReportFile(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeHackerNewsClient{
+		TopStories: []int{101, 202, 303},
+		Stories: map[int]hngrep.Story{
+			101: {ID: 101, Title: "Go is cool", URL: "https://golang.org"},
+			202: {ID: 202, Title: "Random article", URL: "https://example.com/abc"},
+			303: {ID: 303, Title: "Rust is also cool", URL: "https://rust-lang.org"},
+		},
+	}
+
+	dir := t.TempDir()
+	cfg := &cliFlags{
+		maxStories: 3,
+		keywords:   []string{"go"},
+		domain:     "example.com",
+		htmlFile:   filepath.Join(dir, "out.html"),
+		reportFile: filepath.Join(dir, "report.json"),
+	}
+	tmpl := template.Must(template.New("test").Parse(`{{range .Stories}}{{.Title}}{{end}}`))
+
+	if _, err := run(context.Background(), cfg, log.New(io.Discard, "", 0), fakeClient, tmpl); err != nil {
+		t.Fatalf("run(...) returned error: %v", err)
+	}
+
+	body, err := os.ReadFile(cfg.reportFile)
+	if err != nil {
+		t.Fatalf("Failed to read report file %q: %v", cfg.reportFile, err)
+	}
+	var got []reportEntry
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("Failed to unmarshal report: %v", err)
+	}
+
+	want := []reportEntry{
+		{ID: 101, Title: "Go is cool", Domain: "golang.org", Matched: true, Keywords: []string{"go"}, Score: 1},
+		{ID: 202, Title: "Random article", Domain: "example.com", Matched: true, Keywords: []string{}, DomainMatched: true},
+		{ID: 303, Title: "Rust is also cool", Domain: "rust-lang.org", Matched: false, Keywords: []string{}, Reason: hngrep.RejectNoKeyword},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Report = %+v, want %+v", got, want)
+	}
+	for _, key := range []string{`"domain": "example.com"`, `"domain_matched": true`} {
+		if !strings.Contains(string(body), key) {
+			t.Errorf("Report is missing %s:\n%s", key, body)
+		}
+	}
+}
+
+func TestRunDumpPatternFile(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeHackerNewsClient{
+		TopStories: []int{101},
+		Stories: map[int]hngrep.Story{
+			101: {ID: 101, Title: "Go is cool", URL: "https://golang.org"},
+		},
+	}
+
+	dir := t.TempDir()
+	cfg := &cliFlags{
+		maxStories:      1,
+		keywords:        []string{"go", "c++"},
+		htmlFile:        filepath.Join(dir, "out.html"),
+		dumpPatternFile: filepath.Join(dir, "pattern.txt"),
+	}
+	tmpl := template.Must(template.New("test").Parse(`{{range .Stories}}{{.Title}}{{end}}`))
+
+	if _, err := run(context.Background(), cfg, log.New(io.Discard, "", 0), fakeClient, tmpl); err != nil {
+		t.Fatalf("run(...) returned error: %v", err)
+	}
+
+	body, err := os.ReadFile(cfg.dumpPatternFile)
+	if err != nil {
+		t.Fatalf("Failed to read pattern dump file %q: %v", cfg.dumpPatternFile, err)
+	}
+	for _, want := range []string{
+		"Strategy: boundary\n",
+		"Keywords: go, c++\n",
+		`Pattern: (?i)(?:(?:^|[^A-Za-z0-9_])(go)(?:$|[^A-Za-z0-9_])|(?:^|[^A-Za-z0-9_])(c\+\+))` + "\n",
+		"Flags: case-insensitive\n",
+		`  go: (?i)(?:(?:^|[^A-Za-z0-9_])(go)(?:$|[^A-Za-z0-9_]))` + "\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Pattern dump = %q, want it to contain %q", body, want)
+		}
+	}
+}
+
+func TestRunScorePercentileReport(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeHackerNewsClient{
+		TopStories: []int{1, 2, 3},
+		Stories: map[int]hngrep.Story{
+			1: {ID: 1, Title: "Go tips", Score: 10},
+			2: {ID: 2, Title: "Go news", Score: 300},
+			3: {ID: 3, Title: "Cooking", Score: 200},
+		},
+	}
+
+	dir := t.TempDir()
+	cfg := &cliFlags{
+		maxStories:      3,
+		keywords:        []string{"go"},
+		scorePercentile: 50,
+		htmlFile:        filepath.Join(dir, "out.html"),
+		reportFile:      filepath.Join(dir, "report.json"),
+	}
+	tmpl := template.Must(template.New("test").Parse(`{{range .Stories}}{{.Title}}{{end}}`))
+
+	stories, err := run(context.Background(), cfg, log.New(io.Discard, "", 0), fakeClient, tmpl)
+	if err != nil {
+		t.Fatalf("run(...) returned error: %v", err)
+	}
+	body, err := os.ReadFile(cfg.reportFile)
+	if err != nil {
+		t.Fatalf("Failed to read report file %q: %v", cfg.reportFile, err)
+	}
+	var report []reportEntry
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatalf("Failed to unmarshal report: %v", err)
+	}
+
+	// Sorted scores are 10 200 300, so the 50th percentile is 200 and "Go tips" is cut.
+	var outputIDs, reportIDs []int
+	for _, s := range stories {
+		outputIDs = append(outputIDs, s.ID)
+	}
+	for _, e := range report {
+		if e.Matched {
+			reportIDs = append(reportIDs, e.ID)
+		}
+	}
+	if want := []int{2}; !reflect.DeepEqual(outputIDs, want) || !reflect.DeepEqual(reportIDs, want) {
+		t.Errorf("Output IDs = %v and report matches = %v, want both %v", outputIDs, reportIDs, want)
+	}
+	if got := report[0].Reason; got != hngrep.RejectLowScore {
+		t.Errorf("Reason for story 1 = %q, want %q", got, hngrep.RejectLowScore)
+	}
+}
+
+func TestRunIncludeRejected(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeHackerNewsClient{
+		TopStories: []int{1, 2, 3, 4},
+		Stories: map[int]hngrep.Story{
+			1: {ID: 1, Title: "Rust at work", URL: "https://example.com/rust", StoryURL: "https://news.ycombinator.com/item?id=1"},
+			2: {ID: 2, Title: "Rust news", URL: "https://other.com/rust", StoryURL: "https://news.ycombinator.com/item?id=2"},
+			3: {ID: 3, Title: "Go tips", URL: "https://example.com/go", StoryURL: "https://news.ycombinator.com/item?id=3"},
+			4: {ID: 4, Title: "Cooking", URL: "https://example.com/food", StoryURL: "https://news.ycombinator.com/item?id=4"},
+		},
+	}
+
+	for _, include := range []bool{false, true} {
+		dir := t.TempDir()
+		cfg := &cliFlags{
+			maxStories:      4,
+			keywords:        []string{"go", "rust"},
+			weights:         map[string]int{"rust": 2},
+			minRelevance:    2,
+			domain:          "example.com",
+			domainMode:      hngrep.DomainModeAnd,
+			htmlFile:        filepath.Join(dir, "out.html"),
+			templateStyle:   "full",
+			lang:            "en",
+			includeRejected: include,
+		}
+		tmpl, err := loadTemplate(cfg)
+		if err != nil {
+			t.Fatalf("loadTemplate returned error: %v", err)
+		}
+		if _, err := run(context.Background(), cfg, log.New(io.Discard, "", 0), fakeClient, tmpl); err != nil {
+			t.Fatalf("run(...) returned error: %v", err)
+		}
+		body, err := os.ReadFile(cfg.htmlFile)
+		if err != nil {
+			t.Fatalf("Failed to read HTML file %q: %v", cfg.htmlFile, err)
+		}
+
+		rejected := []string{
+			"Rejected stories (3)",
+			`item?id=2" target="_blank" class="text-material-blue hover:underline">Rust news</a> <span class="text-xs text-gray-600">(wrong domain)`,
+			`>Go tips</a> <span class="text-xs text-gray-600">(below minimum relevance)`,
+			`>Cooking</a> <span class="text-xs text-gray-600">(no keyword hit)`,
+		}
+		for _, want := range rejected {
+			if got := strings.Contains(string(body), want); got != include {
+				t.Errorf("With include-rejected %t, HTML contains %q = %t, want %t", include, want, got, include)
+			}
+		}
+	}
+}
+
+func TestRunArchiveFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	cfg := &cliFlags{
+		maxStories:  3,
+		keywords:    []string{"go"},
+		htmlFile:    filepath.Join(dir, "out.html"),
+		archiveFile: filepath.Join(dir, "archive.jsonl"),
+	}
+	tmpl := template.Must(template.New("test").Parse(`{{range .Stories}}{{.Title}}{{end}}`))
+
+	runs := []*FakeHackerNewsClient{
+		{
+			TopStories: []int{101, 202},
+			Stories: map[int]hngrep.Story{
+				101: {ID: 101, Title: "Go is cool"},
+				202: {ID: 202, Title: "Random article"},
+			},
+		},
+		{
+			TopStories: []int{101, 303},
+			Stories: map[int]hngrep.Story{
+				101: {ID: 101, Title: "Go is cool"},
+				303: {ID: 303, Title: "Go 2 when?"},
+			},
+		},
+	}
+	for i, fakeClient := range runs {
+		if _, err := run(context.Background(), cfg, log.New(io.Discard, "", 0), fakeClient, tmpl); err != nil {
+			t.Fatalf("Run %d returned error: %v", i+1, err)
+		}
+	}
+
+	body, err := os.ReadFile(cfg.archiveFile)
+	if err != nil {
+		t.Fatalf("Failed to read archive file %q: %v", cfg.archiveFile, err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	var ids []int
+	for _, line := range lines {
+		var rec archiveRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("Failed to unmarshal archive record %q: %v", line, err)
+		}
+		if rec.SeenAt.IsZero() {
+			t.Errorf("Archive record %q has no seen_at", line)
+		}
+		ids = append(ids, rec.Story.ID)
+	}
+	if want := []int{101, 303}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Archived story IDs = %v, want %v", ids, want)
+	}
+}
+
+func TestNewLoggerPrefix(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeHackerNewsClient{
+		TopStories: []int{101, 202},
+		Stories: map[int]hngrep.Story{
+			101: {ID: 101, Title: "Go is cool", URL: "https://golang.org"},
+			202: {ID: 202, Title: "Random article", URL: "https://example.com/abc"},
+		},
+	}
+	cfg := &cliFlags{
+		maxStories: 2,
+		keywords:   []string{"go"},
+		htmlFile:   filepath.Join(t.TempDir(), "out.html"),
+	}
+	tmpl := template.Must(template.New("test").Parse(`{{range .Stories}}{{.Title}}{{end}}`))
+
+	var buf bytes.Buffer
+	if _, err := run(context.Background(), cfg, newLogger(&buf, "[go-feed] "), fakeClient, tmpl); err != nil {
+		t.Fatalf("run(...) returned error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("Expected several log lines, got %q", buf.String())
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "[go-feed] ") {
+			t.Errorf("Log line %q is missing the prefix", line)
+		}
+	}
+}
+
+func TestRunConsecutiveFailures(t *testing.T) {
+	t.Parallel()
+	errDown := errors.New("api down")
+	stories := map[int]hngrep.Story{
+		1: {ID: 1, Title: "Go is cool"},
+		4: {ID: 4, Title: "Go again"},
+		7: {ID: 7, Title: "Go forever"},
+	}
+
+	tests := []struct {
+		name        string
+		errors      map[int]error
+		wantFetched []int
+		wantErr     bool
+		wantTitles  []string
+	}{
+		{
+			name:        "Aborts after max consecutive failures",
+			errors:      map[int]error{2: errDown, 3: errDown, 4: errDown, 5: errDown},
+			wantFetched: []int{1, 2, 3, 4},
+			wantErr:     true,
+			wantTitles:  []string{"Go is cool"},
+		},
+		{
+			name:        "Success resets the counter",
+			errors:      map[int]error{2: errDown, 3: errDown, 5: errDown, 6: errDown},
+			wantFetched: []int{1, 2, 3, 4, 5, 6, 7},
+			wantErr:     false,
+			wantTitles:  []string{"Go is cool", "Go again", "Go forever"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := &FakeHackerNewsClient{
+				TopStories: []int{1, 2, 3, 4, 5, 6, 7},
+				Stories:    stories,
+				Errors:     tt.errors,
+			}
+			cfg := &cliFlags{
+				maxStories:             10,
+				keywords:               []string{"go"},
+				htmlFile:               filepath.Join(t.TempDir(), "out.html"),
+				maxConsecutiveFailures: 3,
+			}
+			tmpl := template.Must(template.New("test").Parse(`{{range .Stories}}{{.Title}};{{end}}`))
+
+			_, err := run(context.Background(), cfg, log.New(io.Discard, "", 0), fakeClient, tmpl)
+			if tt.wantErr && !errors.Is(err, errDown) {
+				t.Errorf("Expected an error wrapping %v, got %v", errDown, err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(fakeClient.Fetched, tt.wantFetched) {
+				t.Errorf("Fetched IDs = %v, want %v", fakeClient.Fetched, tt.wantFetched)
+			}
+
+			// Partial results are flushed even when the run aborts.
+			contents, err := os.ReadFile(cfg.htmlFile)
+			if err != nil {
+				t.Fatalf("Failed to read output file %q: %v", cfg.htmlFile, err)
+			}
+			if want := strings.Join(tt.wantTitles, ";") + ";"; string(contents) != want {
+				t.Errorf("HTML output = %q, want %q", contents, want)
+			}
+		})
+	}
+}
+
+// fakeNotifier records the stories it's asked to deliver and returns Err.
+type fakeNotifier struct {
+	Got [][]hngrep.Story
+	Err error
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, stories []hngrep.Story) error {
+	f.Got = append(f.Got, stories)
+	return f.Err
+}
+
+func TestLoadNotifiers(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		contents string
+		want     []hngrep.Notifier
+		wantErr  string
+	}{
+		{
+			name: "Command and webhook",
+			contents: `[
+				{"type": "command", "command": "notify-send \"$HNGREP_TITLE\""},
+				{"type": "webhook", "url": "https://hooks.example.com/hn"}
+			]`,
+			want: []hngrep.Notifier{
+				&hngrep.CommandNotifier{Command: `notify-send "$HNGREP_TITLE"`},
+				&hngrep.WebhookNotifier{URL: "https://hooks.example.com/hn"},
+			},
+		},
+		{name: "None", contents: `[]`, want: []hngrep.Notifier{}},
+		{name: "Missing command", contents: `[{"type": "command"}]`, wantErr: "notifier 1 has no command"},
+		{name: "Bad webhook URL", contents: `[{"type": "webhook", "url": "hooks.example.com"}]`, wantErr: "notifier 1 needs an http or https url"},
+		{name: "Unknown type", contents: `[{"type": "pager"}]`, wantErr: `notifier 1 has unknown type "pager"`},
+		{name: "Invalid JSON", contents: `{`, wantErr: "error unmarshalling notifiers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".json")
+			if err := os.WriteFile(path, []byte(tt.contents), 0o644); err != nil {
+				t.Fatalf("Failed to write notifiers file: %v", err)
+			}
+			got, err := loadNotifiers(path, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadNotifiers(...) error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadNotifiers(...) returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadNotifiers(...) = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookBody(t *testing.T) {
+	t.Parallel()
+	var got jsonOutput
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	n := &hngrep.WebhookNotifier{URL: srv.URL, HTTPClient: srv.Client(), Encode: webhookBody([]string{"go"}, "go.dev", time.UTC)}
+	stories := []hngrep.Story{{ID: 1, Title: "Go 1.23", URL: "https://go.dev/blog", StoryURL: "https://news.ycombinator.com/item?id=1", Score: 42, MatchedKeywords: []string{"go"}}}
+	if err := n.Notify(context.Background(), stories); err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	// The webhook gets the -output-format=json envelope.
+	if got.Version != jsonSchemaVersion || got.GeneratedAt.IsZero() || got.Domain != "go.dev" || !reflect.DeepEqual(got.Keywords, []string{"go"}) {
+		t.Errorf("Webhook envelope = %+v, want version %d, a generation time, domain go.dev, and keywords [go]", got, jsonSchemaVersion)
+	}
+	want := []jsonStory{{ID: 1, Title: "Go 1.23", URL: "https://go.dev/blog", HNURL: "https://news.ycombinator.com/item?id=1", Score: 42, MatchedKeywords: []string{"go"}}}
+	if !reflect.DeepEqual(got.Stories, want) {
+		t.Errorf("Webhook stories = %+v, want %+v", got.Stories, want)
+	}
+}
+
+func TestRunNotifiers(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeHackerNewsClient{
+		TopStories: []int{101, 202},
+		Stories: map[int]hngrep.Story{
+			101: {ID: 101, Title: "Go is cool"},
+			202: {ID: 202, Title: "Rust is also cool"},
+		},
+	}
+
+	errSlack := errors.New("slack is down")
+	errEmail := errors.New("smtp refused")
+	first := &fakeNotifier{Err: errSlack}
+	ok := &fakeNotifier{}
+	last := &fakeNotifier{Err: errEmail}
+
+	cfg := &cliFlags{
+		maxStories: 10,
+		keywords:   []string{"go"},
+		htmlFile:   filepath.Join(t.TempDir(), "out.html"),
+		notifiers:  []hngrep.Notifier{first, ok, last},
+	}
+	tmpl := template.Must(template.New("test").Parse(`{{range .Stories}}{{.Title}}{{end}}`))
+
+	_, err := run(context.Background(), cfg, log.New(io.Discard, "", 0), fakeClient, tmpl)
+	if !errors.Is(err, errSlack) || !errors.Is(err, errEmail) {
+		t.Fatalf("Expected both notifier errors, got %v", err)
+	}
+
+	for i, n := range []*fakeNotifier{first, ok, last} {
+		if len(n.Got) != 1 || len(n.Got[0]) != 1 || n.Got[0][0].ID != 101 {
+			t.Errorf("Notifier %d got %v, want one call with story 101", i, n.Got)
+		}
+	}
+
+	// A notifier failure must not keep the HTML from being written.
+	if contents, err := os.ReadFile(cfg.htmlFile); err != nil || string(contents) != "Go is cool" {
+		t.Errorf("HTML output = %q (err %v), want %q", contents, err, "Go is cool")
+	}
+}
+
+func TestRunCount(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeHackerNewsClient{
+		TopStories: []int{101, 202, 303},
+		Stories: map[int]hngrep.Story{
+			101: {ID: 101, Title: "Go is cool"},
+			202: {ID: 202, Title: "Rust is also cool"},
+			303: {ID: 303, Title: "Go generics"},
+		},
+	}
+
+	notifier := &fakeNotifier{}
+	cfg := &cliFlags{
+		maxStories: 10,
+		keywords:   []string{"go"},
+		htmlFile:   filepath.Join(t.TempDir(), "out.html"),
+		notifiers:  []hngrep.Notifier{notifier},
+		count:      true,
+	}
+
+	var out bytes.Buffer
+	if err := runCount(context.Background(), cfg, fakeClient, &out); err != nil {
+		t.Fatalf("runCount(...) returned error: %v", err)
+	}
+
+	if got := out.String(); got != "2\n" {
+		t.Errorf("Output = %q, want only the count %q", got, "2\n")
+	}
+	if _, err := os.Stat(cfg.htmlFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no HTML file in count mode, stat error = %v", err)
+	}
+	if len(notifier.Got) != 0 {
+		t.Errorf("Expected no notifications in count mode, got %v", notifier.Got)
+	}
+}
+
+func TestRunCountTooManyFailures(t *testing.T) {
+	t.Parallel()
+	errDown := errors.New("HN is down")
+	fakeClient := &FakeHackerNewsClient{
+		TopStories: []int{101, 202, 303, 404},
+		Stories: map[int]hngrep.Story{
+			101: {ID: 101, Title: "Go is cool"},
+			404: {ID: 404, Title: "Go generics"},
+		},
+		Errors: map[int]error{202: errDown, 303: errDown},
+	}
+	cfg := &cliFlags{
+		maxStories:             10,
+		keywords:               []string{"go"},
+		maxConsecutiveFailures: 2,
+		count:                  true,
+	}
+
+	var out bytes.Buffer
+	err := runCount(context.Background(), cfg, fakeClient, &out)
+	if !errors.Is(err, hngrep.ErrTooManyFailures) {
+		t.Errorf("runCount(...) error = %v, want %v", err, hngrep.ErrTooManyFailures)
+	}
+	// The story matched before the abort is still counted.
+	if got := out.String(); got != "1\n" {
+		t.Errorf("Output = %q, want %q", got, "1\n")
+	}
+}
+
+func TestRunJSONOutput(t *testing.T) {
+	t.Parallel()
+	fakeClient := &FakeHackerNewsClient{
+		TopStories: []int{101, 202},
+		Stories: map[int]hngrep.Story{
+			101: {ID: 101, Title: "Go is cool", URL: "https://go.dev", Score: 42, StoryURL: "https://news.ycombinator.com/item?id=101"},
+			202: {ID: 202, Title: "Rust is also cool"},
+		},
+	}
+
+	dir := t.TempDir()
+	cfg := &cliFlags{
+		maxStories:   10,
+		keywords:     []string{"go"},
+		htmlFile:     filepath.Join(dir, "out.html"),
+		outputFormat: "json",
+		outputFile:   filepath.Join(dir, "stories.json"),
+	}
+
+	if _, err := run(context.Background(), cfg, log.New(io.Discard, "", 0), fakeClient, nil); err != nil {
+		t.Fatalf("run(...) returned error: %v", err)
+	}
+
+	body, err := os.ReadFile(cfg.outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read JSON file %q: %v", cfg.outputFile, err)
+	}
+
+	// Check the envelope's field names, not just that it round-trips.
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		t.Fatalf("Failed to unmarshal JSON output: %v\n%s", err, body)
+	}
+	for _, key := range []string{"version", "generated_at", "keywords", "stories"} {
+		if _, ok := raw[key]; !ok {
+			t.Errorf("JSON output is missing %q:\n%s", key, body)
+		}
+	}
+
+	var got jsonOutput
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("Failed to unmarshal JSON output: %v", err)
+	}
+	if got.Version != jsonSchemaVersion {
+		t.Errorf("version = %d, want %d", got.Version, jsonSchemaVersion)
+	}
+	if got.GeneratedAt.IsZero() {
+		t.Error("generated_at is zero")
+	}
+	if !reflect.DeepEqual(got.Keywords, []string{"go"}) {
+		t.Errorf("keywords = %v, want [go]", got.Keywords)
+	}
+	want := []jsonStory{{
+		ID:              101,
+		Title:           "Go is cool",
+		URL:             "https://go.dev",
+		Domain:          "go.dev",
+		HNURL:           "https://news.ycombinator.com/item?id=101",
+		Score:           42,
+		MatchedKeywords: []string{"go"},
+		Relevance:       1,
+		Snippet:         "[Go] is cool",
+	}}
+	if !reflect.DeepEqual(got.Stories, want) {
+		t.Errorf("stories = %+v, want %+v", got.Stories, want)
+	}
+
+	if _, err := os.Stat(cfg.htmlFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no HTML file with -output-format=json, stat error = %v", err)
+	}
+}
+
+func TestRunMergeFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	cfg := &cliFlags{
+		maxStories:   10,
+		keywords:     []string{"go"},
+		outputFormat: "json",
+		outputFile:   filepath.Join(dir, "digest.json"),
+	}
+	cfg.mergeFile = cfg.outputFile
+
+	runs := []*FakeHackerNewsClient{
+		{
+			TopStories: []int{101, 202},
+			Stories: map[int]hngrep.Story{
+				101: {ID: 101, Title: "Go is cool", Score: 10},
+				202: {ID: 202, Title: "Go 2 when?", Score: 5},
+			},
+		},
+		{
+			TopStories: []int{303, 101},
+			Stories: map[int]hngrep.Story{
+				303: {ID: 303, Title: "Go generics", Score: 7},
+				101: {ID: 101, Title: "Go is cool", Score: 80},
+			},
+		},
+	}
+	for i, fakeClient := range runs {
+		if _, err := run(context.Background(), cfg, log.New(io.Discard, "", 0), fakeClient, nil); err != nil {
+			t.Fatalf("Run %d returned error: %v", i+1, err)
+		}
+	}
+
+	got, err := readJSON(cfg.outputFile)
+	if err != nil {
+		t.Fatalf("readJSON(%q) returned error: %v", cfg.outputFile, err)
+	}
+	// This run's matches come first, then the earlier ones it didn't match again.
+	var ids, scores []int
+	for _, s := range got.Stories {
+		ids = append(ids, s.ID)
+		scores = append(scores, s.Score)
+	}
+	if want := []int{303, 101, 202}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Merged story IDs = %v, want %v", ids, want)
+	}
+	if want := []int{7, 80, 5}; !reflect.DeepEqual(scores, want) {
+		t.Errorf("Merged scores = %v, want %v, with the newer score of 101", scores, want)
+	}
+}
+
+func TestReadJSONVersion(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "old.json")
+	if err := os.WriteFile(path, []byte(`{"version": 99, "stories": []}`), 0o644); err != nil {
+		t.Fatalf("Failed to write %q: %v", path, err)
+	}
+	if _, err := readJSON(path); err == nil || !strings.Contains(err.Error(), "schema version 99") {
+		t.Errorf("readJSON(...) error = %v, want a schema version error", err)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	t.Parallel()
+	stories := []hngrep.Story{
+		{ID: 1, Title: "Go 1.23 [video]", URL: "https://go.dev/blog", Domain: "go.dev"},
+		{ID: 2, Title: "Ask HN: Go or Rust?", StoryURL: "https://news.ycombinator.com/item?id=2"},
+	}
+
+	tests := []struct {
+		style string
+		want  string
+	}{
+		{
+			style: "list",
+			want: "- [Go 1.23 \\[video\\]](https://go.dev/blog) (go.dev)\n" +
+				"- [Ask HN: Go or Rust?](https://news.ycombinator.com/item?id=2)\n",
+		},
+		{
+			style: "tasklist",
+			want: "- [ ] [Go 1.23 \\[video\\]](https://go.dev/blog) (go.dev)\n" +
+				"- [ ] [Ask HN: Go or Rust?](https://news.ycombinator.com/item?id=2)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "stories.md")
+			if err := writeMarkdown(path, stories, tt.style); err != nil {
+				t.Fatalf("writeMarkdown returned error: %v", err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read Markdown file %q: %v", path, err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Markdown output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteYAML(t *testing.T) {
+	t.Parallel()
+	data := HTMLData{Stories: []hngrep.Story{
+		{
+			ID: 1, Title: "Go 1.23: what's new", URL: "https://go.dev/blog", StoryURL: "https://news.ycombinator.com/item?id=1",
+			Score: 42, MatchedKeywords: []string{"go"}, Relevance: 1, Link: &hngrep.LinkCheck{Alive: true, StatusCode: 200},
+		},
+		{ID: 2, Title: "Zürich’s 東京 meetup", StoryURL: "https://news.ycombinator.com/item?id=2"},
+	}}
+	want := newJSONOutput(nil, data).Stories
+
+	path := filepath.Join(t.TempDir(), "stories.yaml")
+	if err := writeYAML(path, want); err != nil {
+		t.Fatalf("writeYAML returned error: %v", err)
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read YAML file %q: %v", path, err)
+	}
+	for _, field := range []string{"hn_url:", "matched_keywords:", "status_code:", "Zürich’s 東京 meetup"} {
+		if !strings.Contains(string(body), field) {
+			t.Errorf("YAML output is missing %q:\n%s", field, body)
+		}
+	}
+
+	var got []jsonStory
+	if err := yaml.Unmarshal(body, &got); err != nil {
+		t.Fatalf("Failed to unmarshal YAML output: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshalled stories = %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteTSV(t *testing.T) {
+	t.Parallel()
+	data := HTMLData{Stories: []hngrep.Story{
+		{
+			ID: 1, Title: "Go\tvs\tRust: a \"fair\" fight", URL: "https://example.com/a?b=1", Domain: "example.com",
+			StoryURL: "https://news.ycombinator.com/item?id=1", Score: 42, MatchedKeywords: []string{"go", "rust"}, Relevance: 2,
+		},
+		{ID: 2, Title: "Ask HN: line\nbreaks?", StoryURL: "https://news.ycombinator.com/item?id=2", Score: 3},
+	}}
+
+	path := filepath.Join(t.TempDir(), "stories.tsv")
+	if err := writeTSV(path, data.Stories); err != nil {
+		t.Fatalf("writeTSV returned error: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open TSV file %q: %v", path, err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = '\t'
+	got, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse TSV output: %v", err)
+	}
+	want := [][]string{
+		tsvHeader,
+		{"1", `Go vs Rust: a "fair" fight`, "https://example.com/a?b=1", "example.com", "https://news.ycombinator.com/item?id=1", "42", "go, rust", "2"},
+		{"2", "Ask HN: line breaks?", "", "", "https://news.ycombinator.com/item?id=2", "3", "", "0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TSV rows = %q, want %q", got, want)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	t.Parallel()
+	stories := []hngrep.Story{
+		{ID: 1, Title: "Go, \"fast\"\nand simple", StoryURL: "https://news.ycombinator.com/item?id=1", Score: 42, Comments: 17},
+		{ID: 2, Title: "Rust news", StoryURL: "https://news.ycombinator.com/item?id=2", Score: 3},
+	}
+
+	fields, err := parseCSVFields("score, comments,id,title")
+	if err != nil {
+		t.Fatalf("parseCSVFields(...) returned error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "stories.csv")
+	if err := writeCSV(path, stories, fields); err != nil {
+		t.Fatalf("writeCSV returned error: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open CSV file %q: %v", path, err)
+	}
+	defer f.Close()
+
+	got, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV output: %v", err)
+	}
+	// Quoting keeps commas and line breaks inside their field.
+	want := [][]string{
+		{"score", "comments", "id", "title"},
+		{"42", "17", "1", "Go, \"fast\"\nand simple"},
+		{"3", "0", "2", "Rust news"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CSV rows = %q, want %q", got, want)
+	}
+}
+
+func TestWriteOPML(t *testing.T) {
+	t.Parallel()
+	stories := []hngrep.Story{
+		{ID: 1, Title: "Go & Rust <compared>", URL: "https://example.com/a?x=1&y=2", StoryURL: "https://news.ycombinator.com/item?id=1"},
+		{ID: 2, Title: "Ask HN: Favorite \"Go\" tools?", StoryURL: "https://news.ycombinator.com/item?id=2"},
+	}
+
+	path := filepath.Join(t.TempDir(), "stories.opml")
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := writeOPML(path, stories, created); err != nil {
+		t.Fatalf("writeOPML returned error: %v", err)
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read OPML file %q: %v", path, err)
+	}
+	if !strings.HasPrefix(string(body), xml.Header) {
+		t.Errorf("OPML output doesn't start with the XML header:\n%s", body)
+	}
+
+	var got opmlDocument
+	if err := xml.Unmarshal(body, &got); err != nil {
+		t.Fatalf("OPML output isn't valid XML: %v\n%s", err, body)
+	}
+	want := opmlDocument{
+		XMLName: xml.Name{Local: "opml"},
+		Version: "2.0",
+		Title:   "HN Grep",
+		Created: "Wed, 01 May 2024 12:00:00 +0000",
+		Items: []opmlOutline{
+			{
+				Text: "Go & Rust <compared>", Type: "link", URL: "https://example.com/a?x=1&y=2",
+				Items: []opmlOutline{{Text: "HN discussion", Type: "link", URL: "https://news.ycombinator.com/item?id=1"}},
+			},
+			{
+				Text: "Ask HN: Favorite \"Go\" tools?", Type: "link", URL: "https://news.ycombinator.com/item?id=2",
+				Items: []opmlOutline{{Text: "HN discussion", Type: "link", URL: "https://news.ycombinator.com/item?id=2"}},
+			},
+		},
+	}
+	if len(got.Items) != len(stories) {
+		t.Errorf("OPML has %d outlines, want %d", len(got.Items), len(stories))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshalled OPML = %+v, want %+v", got, want)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	cpuPath := filepath.Join(dir, "cpu.pprof")
+	memPath := filepath.Join(dir, "mem.pprof")
+
+	ran := false
+	err := profile(cpuPath, memPath, func() error {
+		ran = true
+		_ = strings.Repeat("go", 1<<16)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("profile returned error: %v", err)
+	}
+	if !ran {
+		t.Error("profile didn't run the workload")
+	}
+	for _, path := range []string{cpuPath, memPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("Profile %q wasn't written: %v", path, err)
+		} else if info.Size() == 0 {
+			t.Errorf("Profile %q is empty", path)
+		}
+	}
+
+	// Without paths, nothing is profiled and the workload's error comes back as-is.
+	wantErr := errors.New("boom")
+	if err := profile("", "", func() error { return wantErr }); err != wantErr {
+		t.Errorf("profile without paths returned %v, want %v", err, wantErr)
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "[1, 2, 3]")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		caFile     string
+		insecure   bool
+		wantErr    string
+		wantDialOK bool
+	}{
+		{name: "Custom CA", caFile: caFile, wantDialOK: true},
+		{name: "Skip verification", insecure: true, wantDialOK: true},
+		{name: "Default client", wantDialOK: false},
+		{name: "Missing CA file", caFile: filepath.Join(dir, "missing.pem"), wantErr: "error reading CA file"},
+		{name: "CA file without certificates", caFile: notPEM, wantErr: "has no PEM certificates"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpClient, err := newHTTPClient(tt.caFile, tt.insecure)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newHTTPClient error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newHTTPClient returned error: %v", err)
+			}
+			if tt.caFile != "" {
+				tlsConfig := httpClient.Transport.(*http.Transport).TLSClientConfig
+				if tlsConfig.RootCAs == nil || tlsConfig.InsecureSkipVerify {
+					t.Errorf("TLS config = %+v, want the custom CA pool with verification on", tlsConfig)
+				}
+			}
+
+			client := hngrep.NewHNClient()
+			client.TopStoriesURL = srv.URL
+			client.HTTPClient = httpClient
+			_, err = client.GetTopStories()
+			if tt.wantDialOK && err != nil {
+				t.Errorf("GetTopStories returned error: %v", err)
+			}
+			if !tt.wantDialOK && err == nil {
+				t.Error("GetTopStories succeeded against an untrusted certificate")
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+//go:build unix || windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// browseTerminal runs browse on the terminal at stdin, switching it to raw
+// mode so keypresses arrive one at a time, and restoring it however browse
+// returns.
+func browseTerminal(l *matchList, out io.Writer) error {
+	if len(l.stories) == 0 {
+		_, err := fmt.Fprintln(out, "No matched stories to browse.")
+		return err
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("interactive mode needs a terminal")
+	}
+	saved, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed to switch the terminal to raw mode: %w", err)
+	}
+	defer term.Restore(fd, saved)
+	return browse(l, os.Stdin, out, browserOpener{})
+}
@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// browseTerminal reports that -interactive isn't supported, as there's no
+// raw terminal mode on this platform.
+func browseTerminal(l *matchList, out io.Writer) error {
+	return fmt.Errorf("interactive mode isn't supported on %s", runtime.GOOS)
+}